@@ -27,6 +27,16 @@ func (cm ControlMode) String() string {
 	}
 }
 
+// ParseControlMode returns the ControlMode with the matching String value
+func ParseControlMode(s string) ControlMode {
+	for _, cm := range []ControlMode{ControlModeFan, ControlModePower, ControlModeTimer} {
+		if cm.String() == s {
+			return cm
+		}
+	}
+	return ControlModeUnknown
+}
+
 // Next goes to the next mode on the FreshRoast display
 func (cm ControlMode) Next() ControlMode {
 	if cm == ControlModeTimer {
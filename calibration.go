@@ -0,0 +1,141 @@
+package autoroast
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// CalibrationValueSize is the number of ASCII characters used for a value when setting calibration
+const CalibrationValueSize = 6
+
+// CalibrationField identifies a calibration value that can be read or changed at runtime. Durations are
+// in milliseconds
+type CalibrationField byte
+
+const (
+	CalibrationServoBasePosition     CalibrationField = 'b'
+	CalibrationServoClickPosition    CalibrationField = 'c'
+	CalibrationServoPressDelay       CalibrationField = 'p'
+	CalibrationServoResetDelay       CalibrationField = 'r'
+	CalibrationStepsPerIncrement     CalibrationField = 's'
+	CalibrationDelayAfterStepperMove CalibrationField = 'd'
+	CalibrationBackstepRatio         CalibrationField = 'x'
+
+	// CalibrationAll is used to read all fields at once
+	CalibrationAll CalibrationField = '*'
+)
+
+// CalibrationFields is every field that can be read or changed
+var CalibrationFields = []CalibrationField{
+	CalibrationServoBasePosition,
+	CalibrationServoClickPosition,
+	CalibrationServoPressDelay,
+	CalibrationServoResetDelay,
+	CalibrationStepsPerIncrement,
+	CalibrationDelayAfterStepperMove,
+	CalibrationBackstepRatio,
+}
+
+func (f CalibrationField) String() string {
+	switch f {
+	case CalibrationServoBasePosition:
+		return "ServoBasePosition"
+	case CalibrationServoClickPosition:
+		return "ServoClickPosition"
+	case CalibrationServoPressDelay:
+		return "ServoPressDelay"
+	case CalibrationServoResetDelay:
+		return "ServoResetDelay"
+	case CalibrationStepsPerIncrement:
+		return "StepsPerIncrement"
+	case CalibrationDelayAfterStepperMove:
+		return "DelayAfterStepperMove"
+	case CalibrationBackstepRatio:
+		return "BackstepRatio"
+	case CalibrationAll:
+		return "All"
+	default:
+		return "Unknown"
+	}
+}
+
+// ParseCalibrationField returns the CalibrationField with the matching String value
+func ParseCalibrationField(s string) (CalibrationField, bool) {
+	for _, f := range CalibrationFields {
+		if f.String() == s {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// EncodeCalibrationValue formats a non-negative value into exactly CalibrationValueSize characters, reducing
+// decimal precision until it fits
+func EncodeCalibrationValue(v float64) ([]byte, error) {
+	if v < 0 {
+		return nil, errors.New("calibration value must not be negative")
+	}
+
+	for precision := 3; precision >= 0; precision-- {
+		s := strconv.FormatFloat(v, 'f', precision, 64)
+		if len(s) <= CalibrationValueSize {
+			return []byte(strings.Repeat("0", CalibrationValueSize-len(s)) + s), nil
+		}
+	}
+	return nil, errors.New("calibration value is too large")
+}
+
+// ParseCalibrationValue parses a value created by EncodeCalibrationValue
+func ParseCalibrationValue(in []byte) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(string(in)), 64)
+	if err != nil || v < 0 {
+		return 0, errors.New("invalid calibration value: " + string(in))
+	}
+	return v, nil
+}
+
+// CalibrationValues maps calibration fields to their values. It is encoded as space-separated name=value pairs
+type CalibrationValues map[CalibrationField]float64
+
+// MarshalText encodes the values in the order of CalibrationFields
+func (cv CalibrationValues) MarshalText() ([]byte, error) {
+	var out []byte
+	for _, f := range CalibrationFields {
+		v, ok := cv[f]
+		if !ok {
+			continue
+		}
+		if len(out) > 0 {
+			out = append(out, ' ')
+		}
+		out = append(out, f.String()+"="...)
+		out = strconv.AppendFloat(out, v, 'f', -1, 32)
+	}
+	return out, nil
+}
+
+// UnmarshalText parses name=value pairs created by MarshalText. Unknown fields are ignored
+func (cv *CalibrationValues) UnmarshalText(text []byte) error {
+	if *cv == nil {
+		*cv = CalibrationValues{}
+	}
+	for _, pair := range strings.Fields(string(text)) {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return errors.New("invalid calibration field: " + pair)
+		}
+
+		f, ok := ParseCalibrationField(name)
+		if !ok {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.New("invalid calibration value for " + name + ": " + value)
+		}
+		(*cv)[f] = v
+	}
+	return nil
+}
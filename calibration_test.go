@@ -0,0 +1,65 @@
+package autoroast
+
+import "testing"
+
+func TestEncodeCalibrationValue(t *testing.T) {
+	for v, want := range map[float64]string{
+		30:       "30.000",
+		68.26667: "68.267",
+		250:      "250.00",
+		12345.6:  "012346",
+		0:        "00.000",
+	} {
+		got, err := EncodeCalibrationValue(v)
+		if err != nil {
+			t.Errorf("EncodeCalibrationValue(%v) error = %v", v, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("EncodeCalibrationValue(%v) = %q, want %q", v, got, want)
+		}
+
+		parsed, err := ParseCalibrationValue(got)
+		if err != nil {
+			t.Errorf("ParseCalibrationValue(%q) error = %v", got, err)
+		}
+		if diff := parsed - v; diff > 0.5 || diff < -0.5 {
+			t.Errorf("ParseCalibrationValue(%q) = %v, want about %v", got, parsed, v)
+		}
+	}
+
+	for _, v := range []float64{-1, 1234567} {
+		if _, err := EncodeCalibrationValue(v); err == nil {
+			t.Errorf("EncodeCalibrationValue(%v) error = nil, want error", v)
+		}
+	}
+}
+
+func TestCalibrationValuesRoundTrip(t *testing.T) {
+	values := CalibrationValues{
+		CalibrationServoBasePosition: 30,
+		CalibrationStepsPerIncrement: 68.5,
+		CalibrationBackstepRatio:     2,
+	}
+
+	text, err := values.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText() error = %v", err)
+	}
+	if got, want := string(text), "ServoBasePosition=30 StepsPerIncrement=68.5 BackstepRatio=2"; got != want {
+		t.Errorf("MarshalText() = %q, want %q", got, want)
+	}
+
+	var parsed CalibrationValues
+	if err := parsed.UnmarshalText(text); err != nil {
+		t.Fatalf("UnmarshalText() error = %v", err)
+	}
+	if len(parsed) != len(values) {
+		t.Fatalf("UnmarshalText() = %v, want %v", parsed, values)
+	}
+	for f, v := range values {
+		if parsed[f] != v {
+			t.Errorf("%s = %v, want %v", f, parsed[f], v)
+		}
+	}
+}
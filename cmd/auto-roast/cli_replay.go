@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calvinmclean/autoroast/controller"
+)
+
+// runCLIReplay runs the controller like runCLI while a replay sends the commands from cfg.RoastFile. Commands
+// typed on stdin are sent between the replay's commands, and pressing Enter continues after an ALERT or
+// WAITFOR CONFIRM
+func runCLIReplay(cfg controller.Config) error {
+	actions, err := controller.LoadReplayWithVars(cfg.RoastFile, cfg.ReplayVars)
+	if err != nil {
+		return fmt.Errorf("error loading replay file: %w", err)
+	}
+	for _, issue := range controller.CheckReplay(actions) {
+		if !issue.Warning {
+			return fmt.Errorf("invalid replay file: %s", issue)
+		}
+		fmt.Println(issue)
+	}
+
+	c, err := controller.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	console := &replayConsole{}
+	replay := controller.NewReplay(actions, console.notify, console.alert)
+	console.replay = replay
+	if cfg.Resume {
+		replay.ResumeSession(c.Session())
+	}
+	c.OnResult(func(_ controller.CommandResult, err error) {
+		if err != nil {
+			replay.Fail(err)
+		}
+	})
+
+	inputReader, inputWriter := io.Pipe()
+	console.writer = inputWriter
+
+	replayCtx, cancelReplay := context.WithCancel(ctx)
+	defer cancelReplay()
+	go func() {
+		err := replay.Run(replayCtx, inputWriter)
+		if err != nil && replayCtx.Err() == nil {
+			fmt.Printf("Error running replay: %v\n", err)
+		}
+	}()
+
+	go func() {
+		console.readInput(os.Stdin)
+		cancelReplay()
+		_ = inputWriter.Close()
+	}()
+
+	return c.Run(ctx, inputReader, os.Stdout)
+}
+
+// replayConsole shows the replay's progress in the terminal and forwards commands from stdin to the controller
+type replayConsole struct {
+	replay *controller.Replay
+	writer io.Writer
+
+	mu sync.Mutex
+	// alertDone is closed to continue after the current ALERT
+	alertDone chan struct{}
+	// confirm is the message for the current WAITFOR CONFIRM
+	confirm   string
+	currentID int
+	current   string
+}
+
+func (rc *replayConsole) notify(state controller.ReplayState) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.confirm = state.Confirm
+	switch {
+	case state.Running && state.Current != "" && (state.Current != rc.current || state.CurrentID != rc.currentID):
+		rc.current = state.Current
+		rc.currentID = state.CurrentID
+		fmt.Printf("\nReplay: %s\n", state.Current)
+		if state.Confirm != "" {
+			fmt.Printf("Confirm: %s (press Enter to continue)\n", state.Confirm)
+		}
+	case state.Cancelled && state.Error != "":
+		fmt.Printf("\nReplay stopped: %s. Manual control enabled.\n", state.Error)
+	case state.Cancelled:
+		fmt.Println("\nReplay cancelled. Manual control enabled.")
+	case state.Started && !state.Running && len(state.Queued) == 0:
+		fmt.Println("\nReplay complete. Manual control enabled.")
+	}
+}
+
+// alert prints the message and waits until Enter is pressed
+func (rc *replayConsole) alert(message string) {
+	done := make(chan struct{})
+	rc.mu.Lock()
+	rc.alertDone = done
+	rc.mu.Unlock()
+
+	fmt.Printf("\nALERT: %s (press Enter to continue)\n", message)
+	<-done
+}
+
+// readInput forwards each line to the controller until the reader is done. An empty line continues after an
+// ALERT or confirms a WAITFOR CONFIRM
+func (rc *replayConsole) readInput(r io.Reader) {
+	defer rc.acknowledge()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			if rc.acknowledge() || !rc.confirming() {
+				continue
+			}
+			line = "CONFIRM"
+		}
+
+		// commands sent manually can be anchors or events for the replay
+		rc.replay.ObserveCommand(line, time.Now())
+		if _, err := fmt.Fprintln(rc.writer, line); err != nil {
+			return
+		}
+	}
+}
+
+// acknowledge continues after the current ALERT. It returns false if there isn't one
+func (rc *replayConsole) acknowledge() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.alertDone == nil {
+		return false
+	}
+	close(rc.alertDone)
+	rc.alertDone = nil
+	return true
+}
+
+func (rc *replayConsole) confirming() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.confirm != ""
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/calvinmclean/autoroast/controller"
+)
+
+// runConvert converts a roast log or TWChart session into a replay file
+func runConvert(args []string) error {
+	var output, sessionID, twchartAddr string
+	var notesAsAlerts bool
+
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	fs.StringVar(&output, "o", "", "Write the replay file here instead of stdout")
+	fs.StringVar(&sessionID, "twchart-session", "", "Convert a TWChart session with this ID instead of a roast log")
+	fs.StringVar(&twchartAddr, "twchart-addr", os.Getenv("TWCHART_ADDR"), "TWChart server address. Default is TWCHART_ADDR")
+	fs.BoolVar(&notesAsAlerts, "alerts", false, "Write notes as ALERTs so they are shown when replaying")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: auto-roast convert [flags] [roast log]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	var roast controller.RecordedRoast
+	var err error
+	switch {
+	case sessionID != "" && fs.NArg() > 0:
+		return errors.New("use either a roast log or -twchart-session, not both")
+	case sessionID != "":
+		if twchartAddr == "" {
+			return errors.New("missing TWChart address")
+		}
+		roast, err = controller.FetchRecordedRoast(context.Background(), twchartAddr, sessionID)
+	case fs.NArg() == 1:
+		roast, err = controller.LoadRecordedRoast(fs.Arg(0))
+	default:
+		fs.Usage()
+		return errors.New("expected one roast log")
+	}
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("error creating replay file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return controller.WriteReplay(w, roast, controller.ConvertOptions{NotesAsAlerts: notesAsAlerts})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
 
 	"github.com/calvinmclean/autoroast/controller"
@@ -10,12 +11,34 @@ import (
 )
 
 func main() {
-	var sessionName, probesInput string
-	var showUI, debugUI bool
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		err := runConvert(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		err := runReplay(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var sessionName, probesInput, replayFile string
+	var showUI, debugUI, resume bool
+	replayVars := replayVarsFlag{}
 	flag.StringVar(&sessionName, "session", "", "Session name for TWChart")
 	flag.StringVar(&probesInput, "probes", "", "Set probe mapping in format \"1=Name,2=Name,...\". Default is 1=Ambient,2=Beans")
 	flag.BoolVar(&showUI, "ui", true, "Enable/disable the UI. Default true")
 	flag.BoolVar(&debugUI, "debug", false, "Run UI in debug mode with a terminal")
+	flag.BoolVar(&resume, "resume", false, "Resume the session saved in .current_session instead of creating a new one")
+	flag.StringVar(&replayFile, "replay", "", "Replay file to run. With the UI, it is selected in the configuration window instead of the saved one. Without the UI, it starts right away and commands can still be typed while it runs")
+	flag.Var(replayVars, "var", "Override a variable declared with SET in the replay file, like -var charge=250. Can be repeated")
 	flag.Parse()
 
 	cfg := controller.NewConfigFromEnv()
@@ -25,6 +48,20 @@ func main() {
 	if probesInput != "" {
 		cfg.ProbesInput = probesInput
 	}
+	cfg.Resume = resume
+	if len(replayVars) > 0 {
+		cfg.ReplayVars = replayVars
+	}
+	cfg.RoastFile = replayFile
+
+	if !showUI && cfg.RoastFile != "" {
+		err := runCLIReplay(cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	if !showUI {
 		runCLI(cfg)
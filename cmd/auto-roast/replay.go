@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/calvinmclean/autoroast/controller"
+)
+
+// runReplay checks a replay file without connecting to the roaster. "check" prints problems with the file and
+// "dry-run" also prints when each step would run
+func runReplay(args []string) error {
+	vars := replayVarsFlag{}
+
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Var(vars, "var", "Override a variable declared with SET in the replay file, like -var charge=250. Can be repeated")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: auto-roast replay [flags] check|dry-run [replay file]")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected a subcommand and one replay file")
+	}
+
+	var dryRun bool
+	switch fs.Arg(0) {
+	case "check":
+	case "dry-run":
+		dryRun = true
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown subcommand %q", fs.Arg(0))
+	}
+
+	actions, err := controller.LoadReplayWithVars(fs.Arg(1), vars)
+	if err != nil {
+		return err
+	}
+
+	issues := controller.CheckReplay(actions)
+	var errorCount int
+	for _, issue := range issues {
+		if !issue.Warning {
+			errorCount++
+		}
+		fmt.Println(issue)
+	}
+
+	if dryRun {
+		if len(issues) > 0 {
+			fmt.Println()
+		}
+		printTimeline(actions)
+	}
+
+	if errorCount > 0 {
+		return fmt.Errorf("found %d invalid command(s)", errorCount)
+	}
+	if !dryRun && len(issues) == 0 {
+		fmt.Println("OK")
+	}
+	return nil
+}
+
+// printTimeline prints each step with its offset from the start of the replay. Steps after a WAITFOR or AT FC are
+// marked with "+" because they run at least that long after the start, depending on when the event happens
+func printTimeline(actions []controller.ReplayAction) {
+	entries, total := controller.ReplayTimeline(actions)
+
+	var afterEvent bool
+	for _, entry := range entries {
+		marker := " "
+		if entry.AfterEvent {
+			marker = "+"
+			afterEvent = true
+		}
+		location := fmt.Sprintf("line %d", entry.Line)
+		if entry.File != "" {
+			location = fmt.Sprintf("%s line %d", entry.File, entry.Line)
+		}
+		fmt.Printf("%s%s  %-10s %s\n", formatOffset(entry.Offset), marker, location, entry.Text)
+	}
+
+	if afterEvent {
+		fmt.Printf("\nTotal: at least %s, depending on events\n", formatOffset(total))
+		return
+	}
+	fmt.Printf("\nTotal: %s\n", formatOffset(total))
+}
+
+// replayVarsFlag collects "name=value" overrides for replay variables from repeated flags
+type replayVarsFlag map[string]string
+
+func (f replayVarsFlag) String() string {
+	return ""
+}
+
+func (f replayVarsFlag) Set(input string) error {
+	name, value, err := controller.ParseReplayVar(input)
+	if err != nil {
+		return err
+	}
+	f[name] = value
+	return nil
+}
+
+func formatOffset(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -9,24 +10,103 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/calvinmclean/autoroast"
+	"github.com/calvinmclean/autoroast/firmware/commands"
+	"github.com/calvinmclean/autoroast/simulator"
 	"github.com/calvinmclean/autoroast/twchart"
 
 	"go.bug.st/serial"
 	"go.bug.st/serial/enumerator"
 )
 
-const SerialPortNone = "none"
+const (
+	SerialPortNone = "none"
+	// SerialPortSim runs the firmware's commands against a simulated roaster instead of using a serial port
+	SerialPortSim = "sim"
+)
+
+const (
+	reconnectAttempts = 30
+	reconnectDelay    = time.Second
 
-var ErrNoUSBSerial = errors.New("no USB serial ports found")
+	// responseTimeout is how long to wait for the firmware to respond to a request. It is long enough for the
+	// knob to turn all the way
+	responseTimeout = 10 * time.Second
+	// serialReadTimeout is how long a read from the serial port waits for input before the response timeout is
+	// checked
+	serialReadTimeout = 100 * time.Millisecond
+)
+
+var (
+	ErrNoUSBSerial = errors.New("no USB serial ports found")
+	// ErrSerialIO is returned when reading or writing the serial port fails, which usually means it was disconnected
+	ErrSerialIO = errors.New("serial I/O error")
+)
 
 type Controller struct {
 	twchartClient twchartClient
-	port          io.ReadWriteCloser
-	config        Config
-	done          bool
+	// twchartQueue is set when twchartClient sends events in the background
+	twchartQueue *twchartQueue
+	// roastLog is set when twchartClient writes a local roast log
+	roastLog *roastLog
+	port     io.ReadWriteCloser
+	// portMu makes sure that only one request is using the port at a time
+	portMu sync.Mutex
+	reader *bufio.Reader
+	seq    byte
+	// responseTimeout is how long passthroughCommand waits for a response. It is responseTimeout if not set
+	responseTimeout time.Duration
+	// openPort opens the serial port again after it is lost. Reconnecting is disabled if it is nil
+	openPort func() (io.ReadWriteCloser, error)
+	config   Config
+	done     bool
+	onResult func(CommandResult, error)
+
+	// fan, power, and startedAt are the last settings accepted by the firmware. They are restored after reconnecting.
+	// startedAt is zero if the roast is not started
+	fan       int
+	power     int
+	startedAt time.Time
+
+	session SessionState
+
+	// temperatureMu protects the last temperature reading and its subscribers since readings are collected in
+	// the background
+	temperatureMu             sync.Mutex
+	temperature               Temperature
+	onTemperature             func(Temperature)
+	temperatureSubscribers    map[int]func(Temperature)
+	nextTemperatureSubscriber int
+	// rateOfRise is created with the first reading
+	rateOfRise *RateOfRise
+
+	temperatureSources []TemperatureSource
+	onProbeReading     func(ProbeReading)
+	// temperatureUpload sends readings from the temperatureSources to TWChart while Run is running
+	temperatureUpload *temperatureUpload
+}
+
+// CommandResult is the firmware's response to a command
+type CommandResult struct {
+	Command string
+	Status  autoroast.Status
+	// Output is anything the firmware logged while running the command
+	Output  string
+	Payload []byte
+}
+
+// FirmwareError is returned when the firmware responds to a command with a non-OK status
+type FirmwareError struct {
+	Command string
+	Status  autoroast.Status
+	Message string
+}
+
+func (e *FirmwareError) Error() string {
+	return fmt.Sprintf("firmware responded %s to %q: %s", e.Status, e.Command, e.Message)
 }
 
 type Config struct {
@@ -38,6 +118,26 @@ type Config struct {
 	InitialFanSetting   int
 	InitialPowerSetting int
 	RoastFile           string
+	// ReplayVars overrides the variables declared with SET in RoastFile
+	ReplayVars map[string]string
+	// SessionFile is where the current session is saved. The session is not saved if it is empty
+	SessionFile string
+	// TWChartQueueFile is where TWChart events are saved until they are sent. Events are only kept in
+	// memory if it is empty
+	TWChartQueueFile string
+	// RoastLogDir is where a JSON-lines log of each roast is written. Roasts are not logged if it is empty
+	RoastLogDir string
+	// Resume continues the session saved in SessionFile instead of creating a new one
+	Resume bool
+	// TemperatureInterval is how often the bean temperature is read from the firmware during Run. The
+	// temperature is not read if it is zero
+	TemperatureInterval time.Duration
+	// ThermocoupleProbe is the name of the probe in ProbesInput that the bean temperatures are sent to
+	// TWChart as. They are not sent if it is empty
+	ThermocoupleProbe string
+	// RoRWindow is how far back bean temperatures are used to calculate the rate of rise. DefaultRoRWindow is
+	// used if it is zero
+	RoRWindow time.Duration
 }
 
 func GetSerialPorts() ([]string, error) {
@@ -66,6 +166,12 @@ func NewConfigFromEnv() Config {
 	twchartAddr := os.Getenv("TWCHART_ADDR")
 	sessionName := os.Getenv("SESSION_NAME")
 	probesInput := os.Getenv("PROBES_INPUT")
+	sessionFile := os.Getenv("SESSION_FILE")
+	twchartQueueFile := os.Getenv("TWCHART_QUEUE_FILE")
+	roastLogDir, roastLogDirSet := os.LookupEnv("ROAST_LOG_DIR")
+	temperatureInterval, _ := time.ParseDuration(os.Getenv("TEMPERATURE_INTERVAL"))
+	thermocoupleProbe, thermocoupleProbeSet := os.LookupEnv("THERMOCOUPLE_PROBE")
+	rorWindow, _ := time.ParseDuration(os.Getenv("ROR_WINDOW"))
 
 	if baudRate == "" {
 		baudRate = "115200"
@@ -75,6 +181,24 @@ func NewConfigFromEnv() Config {
 		probesInput = "1=Ambient,2=Beans"
 	}
 
+	if sessionFile == "" {
+		sessionFile = DefaultSessionFile
+	}
+
+	if twchartQueueFile == "" {
+		twchartQueueFile = DefaultTWChartQueueFile
+	}
+
+	// sending thermocouple readings to TWChart is disabled by setting THERMOCOUPLE_PROBE to an empty string
+	if !thermocoupleProbeSet {
+		thermocoupleProbe = "Beans"
+	}
+
+	// logs are disabled by setting ROAST_LOG_DIR to an empty string
+	if !roastLogDirSet {
+		roastLogDir = DefaultRoastLogDir
+	}
+
 	initialFanSetting := 0
 	if fanStr := os.Getenv("INITIAL_FAN_SETTING"); fanStr != "" {
 		if fan, err := strconv.Atoi(fanStr); err == nil && fan >= 1 && fan <= 9 {
@@ -97,43 +221,87 @@ func NewConfigFromEnv() Config {
 		ProbesInput:         probesInput,
 		InitialFanSetting:   initialFanSetting,
 		InitialPowerSetting: initialPowerSetting,
+		SessionFile:         sessionFile,
+		TWChartQueueFile:    twchartQueueFile,
+		RoastLogDir:         roastLogDir,
+		TemperatureInterval: max(temperatureInterval, 0),
+		ThermocoupleProbe:   thermocoupleProbe,
+		RoRWindow:           max(rorWindow, 0),
 	}
 }
 
-func NewFromEnv() (Controller, error) {
+// Status is the firmware's state as reported by the status command
+type Status struct {
+	autoroast.DeviceStatus
+	// StartTime is calculated from the elapsed duration reported by the firmware. It is zero if not started
+	StartTime time.Time
+}
+
+func NewFromEnv() (*Controller, error) {
 	return New(NewConfigFromEnv())
 }
 
-func New(cfg Config) (Controller, error) {
+func New(cfg Config) (*Controller, error) {
 	// Find default serial port if not set
 	if cfg.SerialPort == "" {
 		ports, err := GetSerialPorts()
 		if err != nil {
-			return Controller{}, fmt.Errorf("error getting serial ports: %w", err)
+			return nil, fmt.Errorf("error getting serial ports: %w", err)
 		}
 		cfg.SerialPort = ports[0]
 	}
 
 	baudRate, err := strconv.Atoi(cfg.BaudRate)
 	if err != nil {
-		return Controller{}, fmt.Errorf("invalid BaudRate: %w", err)
+		return nil, fmt.Errorf("invalid BaudRate: %w", err)
 	}
 	mode := &serial.Mode{
 		BaudRate: baudRate,
 	}
 
-	var port io.ReadWriteCloser
-	if cfg.SerialPort == SerialPortNone {
-		port = &mockPort{}
-	} else {
-		var err error
-		port, err = serial.Open(cfg.SerialPort, mode)
+	var session SessionState
+	if cfg.Resume {
+		session, err = LoadSessionState(cfg.SessionFile)
 		if err != nil {
-			return Controller{}, fmt.Errorf("unexpected error opening serial connection: %w", err)
+			return nil, fmt.Errorf("error loading session to resume: %w", err)
+		}
+		if cfg.SessionName == "" {
+			cfg.SessionName = session.Name
+		}
+		if session.Fan != 0 && session.Power != 0 {
+			cfg.InitialFanSetting = session.Fan
+			cfg.InitialPowerSetting = session.Power
 		}
 	}
 
-	controller := Controller{port: port, twchartClient: noopTWChartClient{}, config: cfg}
+	controller := &Controller{
+		twchartClient: noopTWChartClient{},
+		config:        cfg,
+		fan:           cfg.InitialFanSetting,
+		power:         cfg.InitialPowerSetting,
+		startedAt:     session.StartTime,
+		session:       session,
+	}
+
+	switch cfg.SerialPort {
+	case SerialPortNone:
+		controller.port = &mockPort{}
+	case SerialPortSim:
+		controller.port = simulator.New(simulator.Config{
+			Fan:   uint(cfg.InitialFanSetting),
+			Power: uint(cfg.InitialPowerSetting),
+		})
+	default:
+		var err error
+		controller.port, err = openSerialPort(cfg.SerialPort, mode)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected error opening serial connection: %w", err)
+		}
+		usbDetails := getUSBDetails(cfg.SerialPort)
+		controller.openPort = func() (io.ReadWriteCloser, error) {
+			return controller.reopenSerialPort(mode, usbDetails)
+		}
+	}
 
 	// Set initial fan and power values if they are non-zero
 	if cfg.InitialFanSetting != 0 && cfg.InitialPowerSetting != 0 {
@@ -144,67 +312,578 @@ func New(cfg Config) (Controller, error) {
 			if cfg.SerialPort == SerialPortNone {
 				fmt.Println(err)
 			} else {
-				return Controller{}, err
+				return nil, err
 			}
 		}
 	}
 
+	// the device may have been reset since the session was saved, so it is started again without losing the
+	// time that has already elapsed
+	if !controller.startedAt.IsZero() {
+		_, err := controller.passthroughCommand(resumeCommand(controller.startedAt, time.Now()))
+		if err != nil && cfg.SerialPort != SerialPortNone {
+			return nil, fmt.Errorf("error starting resumed session: %w", err)
+		}
+	}
+
 	if cfg.TWChartAddr != "mock" && cfg.TWChartAddr != "" {
-		controller.twchartClient = twchart.NewClient(cfg.TWChartAddr)
+		queue, err := newTWChartQueue(twchart.NewClient(cfg.TWChartAddr), cfg.TWChartQueueFile)
+		if err != nil {
+			controller.Close()
+			return nil, err
+		}
+		queue.start()
+		controller.twchartClient = queue
+		controller.twchartQueue = queue
+	}
+
+	if cfg.RoastLogDir != "" {
+		controller.roastLog = newRoastLog(cfg.RoastLogDir)
+		controller.roastLog.path = session.RoastLog
+		if controller.twchartQueue != nil {
+			controller.twchartClient = multiTWChartClient{controller.twchartQueue, controller.roastLog}
+		} else {
+			controller.twchartClient = controller.roastLog
+		}
 	}
 
 	return controller, nil
 }
 
-func (c Controller) Close() error {
+// Close stops sending TWChart events and closes the serial port. Unsent events are sent the next time the
+// controller is created
+func (c *Controller) Close() error {
+	if c.twchartQueue != nil {
+		c.twchartQueue.Close()
+	}
+	if c.roastLog != nil {
+		err := c.roastLog.Close()
+		if err != nil {
+			fmt.Printf("Error: error closing roast log: %v\n", err)
+		}
+	}
+
+	c.portMu.Lock()
+	defer c.portMu.Unlock()
+
 	if c.port == nil {
 		return nil
 	}
 	return c.port.Close()
 }
 
-func (c Controller) passthroughCommand(in []byte) (string, error) {
+// getUSBDetails returns the USB details for the named port so it can be found again if the name changes
+// after reconnecting. It returns nil if the port is not USB or can't be found
+func getUSBDetails(name string) *enumerator.PortDetails {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return nil
+	}
+	for _, p := range ports {
+		if p.Name == name && p.IsUSB {
+			return p
+		}
+	}
+	return nil
+}
+
+// reopenSerialPort opens the configured serial port. If that fails, it looks for a port with the same USB
+// VID/PID and serial number, since the device can be assigned a different name when it is plugged back in
+func (c *Controller) reopenSerialPort(mode *serial.Mode, usbDetails *enumerator.PortDetails) (io.ReadWriteCloser, error) {
+	port, err := openSerialPort(c.config.SerialPort, mode)
+	if err == nil || usbDetails == nil {
+		return port, err
+	}
+
+	ports, listErr := enumerator.GetDetailedPortsList()
+	if listErr != nil {
+		return nil, err
+	}
+	for _, p := range ports {
+		if !p.IsUSB || p.VID != usbDetails.VID || p.PID != usbDetails.PID || p.SerialNumber != usbDetails.SerialNumber {
+			continue
+		}
+
+		port, err = openSerialPort(p.Name, mode)
+		if err != nil {
+			return nil, err
+		}
+		c.config.SerialPort = p.Name
+		return port, nil
+	}
+
+	return nil, err
+}
+
+// openSerialPort opens the named serial port with a read timeout, so a firmware that stops responding doesn't block
+// reads forever
+func openSerialPort(name string, mode *serial.Mode) (io.ReadWriteCloser, error) {
+	port, err := serial.Open(name, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	err = port.SetReadTimeout(serialReadTimeout)
+	if err != nil {
+		_ = port.Close()
+		return nil, fmt.Errorf("error setting read timeout: %w", err)
+	}
+	return port, nil
+}
+
+// reconnect replaces a lost serial port and restores the last known fan, power, and start so the roast and
+// TWChart session can continue
+func (c *Controller) reconnect(ctx context.Context, writer io.Writer) error {
+	if c.openPort == nil {
+		return errors.New("reconnecting is not supported for this serial port")
+	}
+
+	lostAt := time.Now()
+	fmt.Fprintln(writer, "Serial connection lost. Reconnecting...")
+	c.addReconnectNote(ctx, writer, "Serial connection lost", lostAt)
+
+	var port io.ReadWriteCloser
+	for attempt := 1; ; attempt++ {
+		var err error
+		port, err = c.openPort()
+		if err == nil {
+			break
+		}
+		if attempt == reconnectAttempts {
+			return fmt.Errorf("error reconnecting after %d attempts: %w", attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reconnectDelay):
+		}
+	}
+
+	c.portMu.Lock()
+	_ = c.port.Close()
+	c.port = port
+	c.reader = nil
+	c.portMu.Unlock()
+
+	// The device resets when it is disconnected so its state is restored
+	if c.fan != 0 && c.power != 0 {
+		_, err := c.passthroughCommand(fmt.Appendf(nil, "I%d%d", c.fan, c.power))
+		if err != nil {
+			return fmt.Errorf("error restoring fan and power after reconnecting: %w", err)
+		}
+	}
+	if !c.startedAt.IsZero() {
+		_, err := c.passthroughCommand(resumeCommand(c.startedAt, time.Now()))
+		if err != nil {
+			return fmt.Errorf("error restoring start after reconnecting: %w", err)
+		}
+	}
+
+	fmt.Fprintf(writer, "Reconnected to %s after %s\n", c.config.SerialPort, time.Since(lostAt).Round(time.Millisecond))
+	c.addReconnectNote(ctx, writer, "Serial connection restored", time.Now())
+
+	return nil
+}
+
+func (c *Controller) addReconnectNote(ctx context.Context, writer io.Writer, note string, now time.Time) {
+	if c.done {
+		return
+	}
+	err := c.twchartClient.AddEvent(ctx, note, now)
+	if err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+	}
+}
+
+// trackSettings records fan, power, and start changes from a successful command so they can be restored.
+// It returns true if the command changed any of them
+func (c *Controller) trackSettings(command string, now time.Time) bool {
+	var changed bool
+	for _, fc := range parseFirmwareCommands(command) {
+		var ok bool
+		switch input := fc.input; fc.command {
+		case commands.SetFanCommand:
+			c.fan, ok = adjustLevel(c.fan, input)
+			changed = changed || ok
+		case commands.SetPowerCommand:
+			c.power, ok = adjustLevel(c.power, input)
+			changed = changed || ok
+		case commands.InitCommand:
+			c.fan = int(input[0] - '0')
+			c.power = int(input[1] - '0')
+			changed = true
+		case commands.StartCommand:
+			c.startedAt = now
+			changed = true
+		case commands.ResumeCommand:
+			seconds, err := strconv.Atoi(string(input))
+			if err == nil {
+				c.startedAt = now.Add(-time.Duration(seconds) * time.Second)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// firmwareCommand is a command in a line sent to the firmware and its input
+type firmwareCommand struct {
+	command *commands.Command
+	input   []byte
+}
+
+// parseFirmwareCommands splits a line into its firmware commands the same way that the firmware runs them. It stops
+// at the first unknown command or missing input
+func parseFirmwareCommands(line string) []firmwareCommand {
+	var result []firmwareCommand
+	in := []byte(line)
+	for i := 0; i < len(in); {
+		flag := in[i]
+		i++
+
+		switch flag {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+
+		cmd, ok := commands.Lookup(flag)
+		if !ok {
+			return result
+		}
+		end := i + int(cmd.InputSize)
+		if end > len(in) {
+			return result
+		}
+		result = append(result, firmwareCommand{command: cmd, input: in[i:end]})
+		i = end
+	}
+	return result
+}
+
+// adjustLevel returns the fan or power level after a firmware command's input. '-' and '+' are relative to the
+// current level, so they are ignored if it is unknown
+func adjustLevel(level int, input []byte) (int, bool) {
+	if len(input) == 0 {
+		return level, false
+	}
+	switch in := input[0]; {
+	case in == '-' && level != 0:
+		return max(level-1, 1), true
+	case in == '+' && level != 0:
+		return min(level+1, 9), true
+	case in >= '1' && in <= '9':
+		return int(in - '0'), true
+	}
+	return level, false
+}
+
+// resumeCommand returns the firmware command that starts the roast with the time that has elapsed since startedAt
+func resumeCommand(startedAt, now time.Time) []byte {
+	seconds := min(max(int(now.Sub(startedAt)/time.Second), 0), 99999)
+	return fmt.Appendf(nil, "E%05d", seconds)
+}
+
+// Session returns the current session's saved state
+func (c *Controller) Session() SessionState {
+	return c.session
+}
+
+// saveSession writes the current session to the SessionFile so it can be resumed
+func (c *Controller) saveSession() error {
+	if c.config.SessionFile == "" {
+		return nil
+	}
+	// the session is created in the background when TWChart events are queued, so the ID might not be known yet
+	if c.session.ID == "" && c.twchartQueue != nil {
+		c.session.ID = c.twchartQueue.SessionID()
+	}
+	if c.roastLog != nil {
+		c.session.RoastLog = c.roastLog.Path()
+	}
+	c.session.Fan = c.fan
+	c.session.Power = c.power
+	return saveSessionState(c.config.SessionFile, c.session)
+}
+
+// recordStage adds a stage to the session and saves it
+func (c *Controller) recordStage(name string, start time.Time) error {
+	c.session.Stages = append(c.session.Stages, SessionStage{Name: name, Start: start})
+	return c.saveSession()
+}
+
+// addStage adds the stage to TWChart and the saved session
+func (c *Controller) addStage(ctx context.Context, name string, now time.Time) error {
+	err := c.twchartClient.AddStage(ctx, name, now)
+	if err != nil {
+		return err
+	}
+	return c.recordStage(name, now)
+}
+
+// OnResult sets a function that is called with the result of each command sent to the firmware by Run
+func (c *Controller) OnResult(f func(CommandResult, error)) {
+	c.onResult = f
+}
+
+// Status queries the firmware for its current state
+func (c *Controller) Status(ctx context.Context) (Status, error) {
+	if err := ctx.Err(); err != nil {
+		return Status{}, err
+	}
+
+	result, err := c.passthroughCommand([]byte{'Q'})
+	if err != nil {
+		return Status{}, fmt.Errorf("error getting status: %w", err)
+	}
+
+	var status Status
+	err = status.UnmarshalText(result.Payload)
+	if err != nil {
+		return Status{}, fmt.Errorf("error parsing status: %w", err)
+	}
+	if status.Started {
+		status.StartTime = time.Now().Add(-status.Elapsed)
+	}
+
+	return status, nil
+}
+
+// Calibration reads all calibration values from the firmware. Durations are in milliseconds
+func (c *Controller) Calibration(ctx context.Context) (autoroast.CalibrationValues, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result, err := c.passthroughCommand([]byte{'k', byte(autoroast.CalibrationAll)})
+	if err != nil {
+		return nil, fmt.Errorf("error getting calibration: %w", err)
+	}
+
+	var values autoroast.CalibrationValues
+	err = values.UnmarshalText(result.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing calibration: %w", err)
+	}
+
+	return values, nil
+}
+
+// SetCalibration changes a calibration value on the firmware, which saves it to flash
+func (c *Controller) SetCalibration(ctx context.Context, field autoroast.CalibrationField, value float64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	encoded, err := autoroast.EncodeCalibrationValue(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", field, err)
+	}
+
+	cmd := append([]byte{'K', byte(field)}, encoded...)
+	_, err = c.passthroughCommand(cmd)
+	if err != nil {
+		return fmt.Errorf("error setting %s: %w", field, err)
+	}
+
+	return nil
+}
+
+// errNoInput is returned by a timeoutReader when its read timed out without any input
+var errNoInput = errors.New("no input")
+
+// timeoutReader reads from a port with a read timeout. A serial port returns no input and no error when its read
+// times out, so that is returned as errNoInput instead
+type timeoutReader struct {
+	port io.Reader
+}
+
+func (r timeoutReader) Read(p []byte) (int, error) {
+	n, err := r.port.Read(p)
+	if n == 0 && err == nil && len(p) > 0 {
+		return 0, errNoInput
+	}
+	return n, err
+}
+
+// passthroughCommand sends the input to the firmware in a request frame and waits for the matching response.
+// A *FirmwareError is returned if the firmware could not run the command. ErrSerialIO is returned if there is no
+// response before the responseTimeout
+func (c *Controller) passthroughCommand(in []byte) (CommandResult, error) {
+	result := CommandResult{Command: string(in)}
 	if c.port == nil {
-		return "", errors.New("no serial port")
+		return result, errors.New("no serial port")
+	}
+
+	c.portMu.Lock()
+	defer c.portMu.Unlock()
+
+	if c.reader == nil {
+		c.reader = bufio.NewReader(timeoutReader{c.port})
 	}
 
-	_, err := c.port.Write(in)
+	c.seq++
+	req, err := autoroast.Request{Seq: c.seq, Command: in}.Encode()
 	if err != nil {
-		return "", fmt.Errorf("unexpected error writing serial: %w", err)
+		return result, fmt.Errorf("error encoding request: %w", err)
+	}
+
+	_, err = c.port.Write(req)
+	if err != nil {
+		return result, fmt.Errorf("%w: unexpected error writing serial: %w", ErrSerialIO, err)
+	}
+
+	timeout := cmp.Or(c.responseTimeout, responseTimeout)
+	deadline := time.Now().Add(timeout)
+	readByte := func() (byte, error) {
+		for {
+			b, err := c.reader.ReadByte()
+			if !errors.Is(err, errNoInput) {
+				return b, err
+			}
+			if time.Now().After(deadline) {
+				return 0, fmt.Errorf("%w: no response after %s", ErrSerialIO, timeout)
+			}
+		}
+	}
+
+	var output strings.Builder
+	for {
+		b, err := readByte()
+		if errors.Is(err, ErrSerialIO) {
+			return result, err
+		}
+		if err != nil {
+			return result, fmt.Errorf("%w: unexpected error reading serial: %w", ErrSerialIO, err)
+		}
+		if b != autoroast.FrameStart {
+			if b != autoroast.TerminationChar {
+				output.WriteByte(b)
+			}
+			continue
+		}
+
+		resp, err := autoroast.DecodeResponse(readByte)
+		if errors.Is(err, ErrSerialIO) {
+			return result, err
+		}
+		if err != nil {
+			return result, fmt.Errorf("invalid response: %w", err)
+		}
+		// responses for earlier requests that timed out are discarded along with their output, but temperature
+		// readings are still kept
+		if resp.Seq != c.seq {
+			c.collectTemperatures(output.String())
+			output.Reset()
+			continue
+		}
+
+		result.Status = resp.Status
+		result.Output = strings.TrimSpace(c.collectTemperatures(output.String()))
+		result.Payload = resp.Payload
+		break
+	}
+
+	if result.Status != autoroast.StatusOK {
+		return result, &FirmwareError{
+			Command: result.Command,
+			Status:  result.Status,
+			Message: string(result.Payload),
+		}
+	}
+
+	return result, nil
+}
+
+// Probes returns the session's probes from the ProbesInput, or an Ambient and Beans probe if it is not set
+func (c *Controller) Probes() (twchart.Probes, error) {
+	if c.config.ProbesInput == "" {
+		return twchart.Probes{
+			{Name: AmbientProbe, Position: 1},
+			{Name: "Beans", Position: 2},
+		}, nil
 	}
 
-	reader := bufio.NewReader(c.port)
-	resp, err := reader.ReadString(autoroast.TerminationChar)
+	probes, err := twchart.ParseProbes(c.config.ProbesInput)
 	if err != nil {
-		return "", fmt.Errorf("unexpected error reading serial: %w", err)
+		return nil, fmt.Errorf("invalid input for probes: %w", err)
+	}
+	return probes, nil
+}
+
+// warnRoastLog writes errors opening the roast log as warnings, since the roast continues without it, and returns
+// any other errors
+func warnRoastLog(writer io.Writer, err error) error {
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+
+	var remaining []error
+	for _, err := range errs {
+		if errors.Is(err, errRoastLogUnavailable) {
+			fmt.Fprintf(writer, "Warning: %v\n", err)
+			continue
+		}
+		remaining = append(remaining, err)
 	}
-	return strings.TrimSpace(resp), nil
+	return errors.Join(remaining...)
 }
 
 func (c *Controller) Run(ctx context.Context, reader io.Reader, writer io.Writer) error {
-	if c.config.SessionName == "" {
+	if c.config.SessionName == "" && !c.config.Resume {
 		return errors.New("missing SessionName")
 	}
 
-	probes := twchart.Probes{
-		{Name: "Ambient", Position: 1},
-		{Name: "Beans", Position: 2},
+	probes, err := c.Probes()
+	if err != nil {
+		return err
 	}
-	if c.config.ProbesInput != "" {
-		var err error
-		probes, err = twchart.ParseProbes(c.config.ProbesInput)
+
+	if c.config.Resume {
+		err := warnRoastLog(writer, c.twchartClient.ResumeSession(ctx, c.session.ID))
+		if err != nil {
+			return fmt.Errorf("error resuming session: %w", err)
+		}
+	} else {
+		sessionID, err := c.twchartClient.CreateSession(ctx, c.config.SessionName, probes)
+		err = warnRoastLog(writer, err)
 		if err != nil {
-			return fmt.Errorf("invalid input for probes: %w", err)
+			return fmt.Errorf("error creating session: %w", err)
 		}
+		c.session = SessionState{ID: sessionID, Name: c.config.SessionName}
 	}
 
-	sessionID, err := c.twchartClient.CreateSession(ctx, c.config.SessionName, probes)
+	err = c.saveSession()
 	if err != nil {
-		return fmt.Errorf("error creating session: %w", err)
+		fmt.Fprintf(writer, "Error: %v\n", err)
 	}
 
-	// TODO: save session ID to text file (.current_session) so it can be resumed. defer file deletion
-	_ = sessionID
+	sources := c.temperatureSources
+	if c.config.TemperatureInterval > 0 {
+		// the first reading checks that the firmware has a working thermometer before it is polled
+		_, err := c.Temperature(ctx)
+		if err != nil {
+			fmt.Fprintf(writer, "Error: %v\n", err)
+		} else {
+			pollCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go c.pollTemperature(pollCtx)
+
+			if c.config.ThermocoupleProbe != "" {
+				position, err := c.thermocoupleProbe(probes)
+				if err != nil {
+					fmt.Fprintf(writer, "Error: %v\n", err)
+				} else {
+					sources = append(sources, newThermocoupleSource(c, position))
+				}
+			}
+		}
+	}
+	if len(sources) > 0 {
+		c.startTemperatureUpload(ctx, sources)
+		defer c.stopTemperatureUpload()
+	}
 
 	// Use bufio.Scanner for line-by-line input
 	scanner := bufio.NewScanner(reader)
@@ -234,49 +913,176 @@ func (c *Controller) Run(ctx context.Context, reader io.Reader, writer io.Writer
 			continue
 		}
 
-		if !c.done {
-			switch line[0] {
-			case 'F', 'P':
-				err = c.twchartClient.AddEvent(ctx, line, time.Now())
-			case 'S':
-				err = c.twchartClient.SetStartTime(ctx, time.Now())
+		now := time.Now()
+		result, err := c.passthroughCommand([]byte(line))
+		if errors.Is(err, ErrSerialIO) {
+			reconnectErr := c.reconnect(ctx, writer)
+			if reconnectErr != nil {
+				fmt.Fprintf(writer, "Error: %v\n", reconnectErr)
+			} else {
+				// the command might not have reached the device before it was reset, so it is sent again now that
+				// the previous settings are restored
+				now = time.Now()
+				result, err = c.passthroughCommand([]byte(line))
 			}
-			if err != nil {
-				fmt.Fprintf(writer, "Error: %v\n", err)
-				continue
+		}
+		// TWChart only gets events for commands that the firmware accepted
+		if err == nil {
+			c.addCommandEvent(ctx, writer, line, now)
+			if c.trackSettings(line, now) {
+				saveErr := c.saveSession()
+				if saveErr != nil {
+					fmt.Fprintf(writer, "Error: %v\n", saveErr)
+				}
 			}
 		}
+		if recorder, ok := c.twchartClient.(commandRecorder); ok {
+			recorder.RecordCommand(time.Now(), result, err)
+		}
+		if c.onResult != nil {
+			c.onResult(result, err)
+		}
 
-		resp, err := c.passthroughCommand([]byte(line))
-
+		if result.Output != "" {
+			fmt.Fprintln(writer, result.Output)
+		}
+		if err == nil && len(result.Payload) > 0 {
+			fmt.Fprintln(writer, string(result.Payload))
+		}
 		if err != nil {
 			fmt.Fprintf(writer, "Error: %v\n", err)
-		} else {
-			fmt.Fprintln(writer, resp)
 		}
 	}
 }
 
+// addCommandEvent adds a TWChart event for fan and power changes and sets the start time when the roast starts
+func (c *Controller) addCommandEvent(ctx context.Context, writer io.Writer, line string, now time.Time) {
+	if c.done {
+		return
+	}
+
+	var err error
+	switch line[0] {
+	case 'F', 'P':
+		err = c.twchartClient.AddEvent(ctx, c.settingEvent(line), now)
+	case 'S':
+		err = c.twchartClient.SetStartTime(ctx, now)
+		if err == nil {
+			c.session.StartTime = now
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(writer, "Error: %v\n", err)
+	}
+}
+
+// Stage names used by TWChart and the session. First Crack is an event in TWChart, but it is also tracked as a stage
+const (
+	StagePreheat    = "Preheat"
+	StageRoasting   = "Roasting"
+	StageFirstCrack = "First Crack"
+	StageCooling    = "Cooling"
+)
+
+// ExternalCommand is a command that is handled by the controller instead of being sent to the firmware
+type ExternalCommand struct {
+	Name string
+	// Stage is the stage that the command adds, if any
+	Stage string
+}
+
+// externalCommands are handled by the controller instead of being sent to the firmware. Lines starting with NOTE
+// are also handled by the controller. The first command for each stage is the one used to add it in converted roasts
+var externalCommands = []ExternalCommand{
+	{Name: "PREHEAT", Stage: StagePreheat},
+	{Name: "PH", Stage: StagePreheat},
+	{Name: "ROASTING", Stage: StageRoasting},
+	{Name: "ROAST", Stage: StageRoasting},
+	{Name: "FC", Stage: StageFirstCrack},
+	{Name: "CRACK", Stage: StageFirstCrack},
+	{Name: "COOL", Stage: StageCooling},
+	{Name: "DONE"},
+	{Name: "CONFIRM"},
+}
+
+// LookupExternalCommand returns the external command with the name. Unlike the firmware's commands, names are
+// case-insensitive, so fc in a replay is handled the same as FC
+func LookupExternalCommand(name string) (ExternalCommand, bool) {
+	for _, cmd := range externalCommands {
+		if strings.EqualFold(cmd.Name, name) {
+			return cmd, true
+		}
+	}
+	return ExternalCommand{}, false
+}
+
+// stageCommand returns the command that adds the stage, if any
+func stageCommand(stage string) string {
+	for _, cmd := range externalCommands {
+		if cmd.Stage == stage {
+			return cmd.Name
+		}
+	}
+	return ""
+}
+
+// isExternalCommand returns true if handleExternalCommands handles the line
+func isExternalCommand(line string) bool {
+	_, ok := LookupExternalCommand(line)
+	_, isNote := cutNote(line)
+	return ok || isNote
+}
+
+// cutNote returns the note from a NOTE command. Like external commands, NOTE is case-insensitive
+func cutNote(line string) (string, bool) {
+	if len(line) < len("NOTE") || !strings.EqualFold(line[:len("NOTE")], "NOTE") {
+		return "", false
+	}
+	return strings.TrimPrefix(line[len("NOTE"):], " "), true
+}
+
 // handleExternalCommands is responsible for commands that do not get sent to the firmware controller.
 // It returns 'true' if a command is matched.
 func (c *Controller) handleExternalCommands(ctx context.Context, line string) (bool, error) {
-	switch line {
-	case "PH", "PREHEAT":
-		// TODO: should start if not already started
-		return true, c.twchartClient.AddStage(ctx, "Preheat", time.Now())
-	case "ROAST", "ROASTING":
-		return true, c.twchartClient.AddStage(ctx, "Roasting", time.Now())
-	case "FC", "CRACK":
-		return true, c.twchartClient.AddEvent(ctx, "First Crack", time.Now())
-	case "COOL":
-		return true, c.twchartClient.AddStage(ctx, "Cooling", time.Now())
-	case "DONE":
+	if note, ok := cutNote(line); ok {
+		return true, c.twchartClient.AddEvent(ctx, note, time.Now())
+	}
+
+	cmd, ok := LookupExternalCommand(line)
+	if !ok {
+		return false, nil
+	}
+
+	switch {
+	case cmd.Stage == StageFirstCrack:
+		now := time.Now()
+		err := c.twchartClient.AddEvent(ctx, StageFirstCrack, now)
+		if err != nil {
+			return true, err
+		}
+		return true, c.recordStage(StageFirstCrack, now)
+	case cmd.Stage != "":
+		// TODO: Preheat should start if not already started
+		return true, c.addStage(ctx, cmd.Stage, time.Now())
+	case cmd.Name == "CONFIRM":
+		// only used to continue a replay that is waiting for confirmation, so nothing is sent to the firmware
+		return true, nil
+	case cmd.Name == "DONE":
 		c.done = true
-		return true, c.twchartClient.Done(ctx)
-	default:
-		if strings.HasPrefix(line, "NOTE") {
-			return true, c.twchartClient.AddEvent(ctx, strings.TrimPrefix(line, "NOTE "), time.Now())
+		// the last temperatures are sent before the session is done
+		c.stopTemperatureUpload()
+		err := c.twchartClient.Done(ctx, time.Now())
+		if err != nil {
+			return true, err
+		}
+		if c.config.SessionFile == "" {
+			return true, nil
+		}
+		err = os.Remove(c.config.SessionFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return true, fmt.Errorf("error removing session file: %w", err)
 		}
+		return true, nil
 	}
 
 	return false, nil
@@ -3,6 +3,8 @@ package controller
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io"
 	"strings"
 	"testing"
 	"time"
@@ -11,11 +13,20 @@ import (
 )
 
 type recordingTWChartClient struct {
-	events []string
+	events  []string
+	created []string
+	resumed []string
+	data    []twchart.Data
 }
 
 func (r *recordingTWChartClient) CreateSession(ctx context.Context, beanName string, probes twchart.Probes) (string, error) {
-	return "", nil
+	r.created = append(r.created, beanName)
+	return "session-id", nil
+}
+
+func (r *recordingTWChartClient) ResumeSession(ctx context.Context, id string) error {
+	r.resumed = append(r.resumed, id)
+	return nil
 }
 
 func (r *recordingTWChartClient) SetStartTime(ctx context.Context, startTime time.Time) error {
@@ -31,7 +42,12 @@ func (r *recordingTWChartClient) AddStage(ctx context.Context, name string, now
 	return nil
 }
 
-func (r *recordingTWChartClient) Done(ctx context.Context) error {
+func (r *recordingTWChartClient) AddData(ctx context.Context, data []twchart.Data) error {
+	r.data = append(r.data, data...)
+	return nil
+}
+
+func (r *recordingTWChartClient) Done(ctx context.Context, now time.Time) error {
 	return nil
 }
 
@@ -84,3 +100,212 @@ func TestControllerStillPassthroughsCommandsAfterDone(t *testing.T) {
 		t.Errorf("port commands = %q, want %q", port.commands, want)
 	}
 }
+
+func TestControllerDoesNotSendConfirm(t *testing.T) {
+	port := &mockPort{}
+	c := &Controller{
+		config:        Config{SessionName: "test"},
+		twchartClient: &recordingTWChartClient{},
+		port:          port,
+	}
+
+	var output bytes.Buffer
+	input := strings.NewReader("CONFIRM\nF6\n")
+	if err := c.Run(context.Background(), input, &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := []string{"F6"}
+	if !equalStrings(port.commands, want) {
+		t.Errorf("port commands = %q, want %q", port.commands, want)
+	}
+}
+
+type lostPort struct{}
+
+func (lostPort) Write([]byte) (int, error) { return 0, errors.New("device not configured") }
+func (lostPort) Read([]byte) (int, error)  { return 0, errors.New("device not configured") }
+func (lostPort) Close() error              { return nil }
+
+func TestControllerReconnectsAndRestoresSettings(t *testing.T) {
+	mock := &recordingTWChartClient{}
+	reconnectedPort := &mockPort{}
+	c := &Controller{
+		config: Config{
+			SessionName: "test",
+		},
+		twchartClient: mock,
+		port:          &mockPort{},
+		openPort: func() (io.ReadWriteCloser, error) {
+			return reconnectedPort, nil
+		},
+	}
+
+	var output bytes.Buffer
+	if err := c.Run(context.Background(), strings.NewReader("I43\nS\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// the cable is unplugged after starting
+	c.port = lostPort{}
+	c.reader = nil
+
+	if err := c.Run(context.Background(), strings.NewReader("F5\nP6\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// the roast continues from where it was instead of starting over, then the failed F5 is sent again
+	if got, want := reconnectedPort.commands, []string{"I43", "E00000", "F5", "P6"}; !equalStrings(got, want) {
+		t.Errorf("commands after reconnecting = %q, want %q", got, want)
+	}
+	if got, want := mock.events, []string{"Serial connection lost", "Serial connection restored", "F5", "P6"}; !equalStrings(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+	if strings.Contains(output.String(), "Error: serial I/O error") {
+		t.Errorf("output = %q, want no error after the command is sent again", output.String())
+	}
+	if c.fan != 5 || c.power != 6 {
+		t.Errorf("settings = F%d/P%d, want F5/P6", c.fan, c.power)
+	}
+}
+
+func TestControllerReconnectsWhenFirmwareStopsResponding(t *testing.T) {
+	mock := &recordingTWChartClient{}
+	reconnectedPort := &mockPort{}
+	c := &Controller{
+		config:          Config{SessionName: "test"},
+		twchartClient:   mock,
+		port:            &mockPort{unresponsive: true},
+		responseTimeout: 50 * time.Millisecond,
+		openPort: func() (io.ReadWriteCloser, error) {
+			return reconnectedPort, nil
+		},
+	}
+
+	_, err := c.passthroughCommand([]byte("F5"))
+	if !errors.Is(err, ErrSerialIO) {
+		t.Fatalf("passthroughCommand() error = %v, want ErrSerialIO", err)
+	}
+
+	var output bytes.Buffer
+	if err := c.Run(context.Background(), strings.NewReader("F5\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got, want := reconnectedPort.commands, []string{"F5"}; !equalStrings(got, want) {
+		t.Errorf("commands after reconnecting = %q, want %q", got, want)
+	}
+	if got, want := mock.events, []string{"Serial connection lost", "Serial connection restored", "F5"}; !equalStrings(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestControllerHandlesLowercaseExternalCommands(t *testing.T) {
+	mock := &recordingTWChartClient{}
+	port := &mockPort{}
+	c := &Controller{
+		config:        Config{SessionName: "test"},
+		twchartClient: mock,
+		port:          port,
+	}
+
+	var output bytes.Buffer
+	if err := c.Run(context.Background(), strings.NewReader("roasting\nfc\nnote first pops\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// like in replays, controller commands aren't sent to the firmware in any case
+	if len(port.commands) != 0 {
+		t.Errorf("port commands = %q, want none", port.commands)
+	}
+	if got, want := mock.events, []string{"First Crack", "first pops"}; !equalStrings(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+	var stages []string
+	for _, stage := range c.Session().Stages {
+		stages = append(stages, stage.Name)
+	}
+	if want := []string{StageRoasting, StageFirstCrack}; !equalStrings(stages, want) {
+		t.Errorf("session stages = %q, want %q", stages, want)
+	}
+}
+
+func TestControllerSkipsEventsForFailedCommands(t *testing.T) {
+	mock := &recordingTWChartClient{}
+	c := &Controller{
+		config:        Config{SessionName: "test"},
+		twchartClient: mock,
+		port:          &mockPort{failures: map[string]string{"F5": "stepper stuck"}},
+	}
+
+	var output bytes.Buffer
+	if err := c.Run(context.Background(), strings.NewReader("F5\nP6\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got, want := mock.events, []string{"P6"}; !equalStrings(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestTrackSettings(t *testing.T) {
+	now := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	c := &Controller{}
+
+	if c.trackSettings("F+P-", now) || c.fan != 0 || c.power != 0 {
+		t.Errorf("settings = F%d/P%d, want relative changes ignored while unknown", c.fan, c.power)
+	}
+	for _, tt := range []struct {
+		command    string
+		fan, power int
+	}{
+		{"I55", 5, 5},
+		{"F+", 6, 5},
+		{"P-P-", 6, 3},
+		{"F9F+", 9, 3},
+		{"P1 P-", 9, 1},
+	} {
+		c.trackSettings(tt.command, now)
+		if c.fan != tt.fan || c.power != tt.power {
+			t.Errorf("after %q settings = F%d/P%d, want F%d/P%d", tt.command, c.fan, c.power, tt.fan, tt.power)
+		}
+	}
+
+	if !c.trackSettings("E00090", now) || !c.startedAt.Equal(now.Add(-90*time.Second)) {
+		t.Errorf("startedAt = %s, want 90s before now", c.startedAt)
+	}
+	if got := string(resumeCommand(c.startedAt, now.Add(1500*time.Millisecond))); got != "E00091" {
+		t.Errorf("resumeCommand() = %q, want E00091", got)
+	}
+}
+
+func TestControllerWithSimulator(t *testing.T) {
+	c, err := New(Config{
+		SerialPort:          SerialPortSim,
+		BaudRate:            "115200",
+		SessionName:         "test",
+		InitialFanSetting:   5,
+		InitialPowerSetting: 5,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	var output bytes.Buffer
+	if err := c.Run(context.Background(), strings.NewReader("F7\nF0\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !strings.Contains(output.String(), "[-] F7") {
+		t.Errorf("output = %q, want simulator log for F7", output.String())
+	}
+	if !strings.Contains(output.String(), "Error: firmware responded Error to \"F0\"") {
+		t.Errorf("output = %q, want error for F0", output.String())
+	}
+
+	status, err := c.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if status.Fan != 7 || status.Power != 5 {
+		t.Errorf("status = F%d/P%d, want F7/P5", status.Fan, status.Power)
+	}
+}
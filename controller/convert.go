@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/calvinmclean/autoroast/twchart"
+)
+
+// RecordedRoast is a roast recorded by a roast log or TWChart that can be converted to a replay file
+type RecordedRoast struct {
+	Name  string
+	Date  time.Time
+	Steps []RecordedStep
+}
+
+// RecordedStep is a command or note and when it happened
+type RecordedStep struct {
+	Time    time.Time
+	Command string
+	Note    string
+}
+
+// ConvertOptions changes how a RecordedRoast is written as a replay file
+type ConvertOptions struct {
+	// NotesAsAlerts writes notes as ALERTs instead of NOTEs so they are shown when replaying
+	NotesAsAlerts bool
+}
+
+// LoadRecordedRoast reads a roast log file
+func LoadRecordedRoast(path string) (RecordedRoast, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return RecordedRoast{}, fmt.Errorf("open roast log: %w", err)
+	}
+	defer f.Close()
+
+	entries, err := ReadRoastLog(f)
+	if err != nil {
+		return RecordedRoast{}, err
+	}
+	return RecordedRoastFromLog(entries), nil
+}
+
+// FetchRecordedRoast gets a session from the TWChart server
+func FetchRecordedRoast(ctx context.Context, addr, sessionID string) (RecordedRoast, error) {
+	session, err := twchart.NewClient(addr).GetSession(ctx, sessionID)
+	if err != nil {
+		return RecordedRoast{}, fmt.Errorf("error getting TWChart session: %w", err)
+	}
+	return RecordedRoastFromTWChart(session), nil
+}
+
+// RecordedRoastFromLog uses the successful fan and power commands from a roast log, including relative ones like F+,
+// so settings that the firmware rejected are not replayed
+func RecordedRoastFromLog(entries []RoastLogEntry) RecordedRoast {
+	var roast RecordedRoast
+	for _, entry := range entries {
+		switch entry.Type {
+		case RoastLogSession:
+			roast.Name = entry.Name
+			roast.Date = entry.Time
+		case RoastLogStart:
+			roast.add(entry.Time, "S", "")
+		case RoastLogStage:
+			if command := stageCommand(entry.Name); command != "" {
+				roast.add(entry.Time, command, "")
+			}
+		case RoastLogEvent:
+			command := settingEventCommand(entry.Name)
+			if !isSettingCommand(command) && !isRelativeSettingCommand(command) {
+				roast.addEvent(entry.Time, entry.Name)
+			}
+		case RoastLogCommand:
+			if entry.Error == "" && (isSettingCommand(entry.Name) || isRelativeSettingCommand(entry.Name)) {
+				roast.add(entry.Time, entry.Name, "")
+			}
+		case RoastLogDone:
+			roast.add(entry.Time, "DONE", "")
+		}
+	}
+
+	roast.sort()
+	return roast
+}
+
+// RecordedRoastFromTWChart uses the stages and events from a TWChart session. TWChart does not know if a
+// command failed, so every fan and power change is used
+func RecordedRoastFromTWChart(session twchart.Session) RecordedRoast {
+	roast := RecordedRoast{Name: session.Name, Date: session.Date}
+	if !session.StartTime.IsZero() {
+		roast.add(session.StartTime, "S", "")
+	}
+	for _, stage := range session.Stages {
+		if command := stageCommand(stage.Name); command != "" {
+			roast.add(stage.Start, command, "")
+		}
+	}
+	for _, event := range session.Events {
+		if command := settingEventCommand(event.Note); isSettingCommand(command) || isRelativeSettingCommand(command) {
+			roast.add(event.Time, command, "")
+			continue
+		}
+		roast.addEvent(event.Time, event.Note)
+	}
+
+	roast.sort()
+	return roast
+}
+
+func (r *RecordedRoast) add(t time.Time, command, note string) {
+	r.Steps = append(r.Steps, RecordedStep{Time: t, Command: command, Note: note})
+}
+
+// addEvent adds First Crack as a command and other events as notes. Notes added by the controller
+// when the serial connection is lost are skipped
+func (r *RecordedRoast) addEvent(t time.Time, note string) {
+	switch {
+	case note == "First Crack":
+		r.add(t, "FC", "")
+	case strings.HasPrefix(note, "Serial connection "):
+	default:
+		r.add(t, "", note)
+	}
+}
+
+func (r *RecordedRoast) sort() {
+	slices.SortStableFunc(r.Steps, func(a, b RecordedStep) int {
+		return a.Time.Compare(b.Time)
+	})
+}
+
+// WriteReplay writes the roast in the format read by ParseReplay, with WAITs for the time between each step
+func WriteReplay(w io.Writer, roast RecordedRoast, opts ConvertOptions) error {
+	var b strings.Builder
+
+	name := roast.Name
+	if name == "" {
+		name = "Unnamed roast"
+	}
+	fmt.Fprintf(&b, "# %s\n", name)
+	if !roast.Date.IsZero() {
+		fmt.Fprintf(&b, "# Roasted %s\n", roast.Date.Format("2006-01-02 15:04"))
+	}
+
+	for i, step := range roast.Steps {
+		if i > 0 {
+			wait := step.Time.Sub(roast.Steps[i-1].Time).Round(time.Second)
+			if wait >= time.Second {
+				fmt.Fprintf(&b, "WAIT %s\n", formatWait(wait))
+			}
+		}
+
+		switch {
+		case step.Command != "":
+			b.WriteString(step.Command + "\n")
+		case opts.NotesAsAlerts:
+			b.WriteString("ALERT " + step.Note + "\n")
+		default:
+			b.WriteString("NOTE " + step.Note + "\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatWait removes zero units from the end of a duration, like 3m instead of 3m0s
+func formatWait(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// isSettingCommand returns true for commands that change the fan or power, like F5, P6, or I55
+func isSettingCommand(command string) bool {
+	isLevel := func(b byte) bool {
+		return b >= '1' && b <= '9'
+	}
+
+	switch {
+	case len(command) == 2 && (command[0] == 'F' || command[0] == 'P'):
+		return isLevel(command[1])
+	case len(command) == 3 && command[0] == 'I':
+		return isLevel(command[1]) && isLevel(command[2])
+	default:
+		return false
+	}
+}
+
+// isRelativeSettingCommand returns true for commands that turn the fan or power up or down by one, like F+ or P-
+func isRelativeSettingCommand(command string) bool {
+	return len(command) == 2 && (command[0] == 'F' || command[0] == 'P') && (command[1] == '+' || command[1] == '-')
+}
@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/calvinmclean/autoroast/twchart"
+)
+
+func TestWriteReplayFromLog(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time {
+		return start.Add(d)
+	}
+
+	entries := []RoastLogEntry{
+		{Time: at(0), Type: RoastLogSession, Name: "Ethiopia Guji"},
+		{Time: at(time.Second), Type: RoastLogCommand, Name: "I55", Status: "OK"},
+		{Time: at(2 * time.Second), Type: RoastLogStart},
+		{Time: at(2 * time.Second), Type: RoastLogCommand, Name: "S", Status: "OK"},
+		{Time: at(2 * time.Second), Type: RoastLogStage, Name: "Preheat"},
+		{Time: at(47 * time.Second), Type: RoastLogStage, Name: "Roasting"},
+		{Time: at(3*time.Minute + 47*time.Second), Type: RoastLogEvent, Name: "F6"},
+		{Time: at(3*time.Minute + 47*time.Second), Type: RoastLogCommand, Name: "F6", Status: "OK"},
+		{Time: at(4 * time.Minute), Type: RoastLogEvent, Name: "P9"},
+		{Time: at(4 * time.Minute), Type: RoastLogCommand, Name: "P9", Status: "Error", Error: "invalid input"},
+		{Time: at(4*time.Minute + 10*time.Second), Type: RoastLogCommand, Name: "Q", Status: "OK"},
+		{Time: at(4*time.Minute + 30*time.Second), Type: RoastLogEvent, Name: "smells like bread"},
+		{Time: at(5 * time.Minute), Type: RoastLogEvent, Name: "Serial connection lost"},
+		{Time: at(5*time.Minute + 47*time.Second), Type: RoastLogEvent, Name: "First Crack"},
+		{Time: at(6*time.Minute + 47*time.Second), Type: RoastLogStage, Name: "Cooling"},
+		{Time: at(9*time.Minute + 47*time.Second), Type: RoastLogDone},
+	}
+
+	var out bytes.Buffer
+	err := WriteReplay(&out, RecordedRoastFromLog(entries), ConvertOptions{NotesAsAlerts: true})
+	if err != nil {
+		t.Fatalf("WriteReplay() error = %v", err)
+	}
+
+	want := `# Ethiopia Guji
+# Roasted 2025-06-01 10:00
+I55
+WAIT 1s
+S
+PREHEAT
+WAIT 45s
+ROASTING
+WAIT 3m
+F6
+WAIT 43s
+ALERT smells like bread
+WAIT 1m17s
+FC
+WAIT 1m
+COOL
+WAIT 3m
+DONE
+`
+	if out.String() != want {
+		t.Errorf("WriteReplay() =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	actions, err := ParseReplay(&out)
+	if err != nil {
+		t.Fatalf("ParseReplay() error = %v", err)
+	}
+	if len(actions) != 16 {
+		t.Errorf("ParseReplay() returned %d actions, want 16", len(actions))
+	}
+}
+
+func TestRecordedRoastFromTWChart(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	session := twchart.Session{
+		Name:      "Colombia",
+		Date:      start,
+		StartTime: start,
+		Stages: []twchart.Stage{
+			{Name: "Roasting", Start: start.Add(time.Minute)},
+			{Name: "Cooling", Start: start.Add(8 * time.Minute)},
+		},
+		Events: []twchart.Event{
+			{Note: "P7", Time: start.Add(2 * time.Minute)},
+			{Note: "First Crack", Time: start.Add(6 * time.Minute)},
+			{Note: "dark spots", Time: start.Add(7 * time.Minute)},
+		},
+	}
+
+	var out bytes.Buffer
+	err := WriteReplay(&out, RecordedRoastFromTWChart(session), ConvertOptions{})
+	if err != nil {
+		t.Fatalf("WriteReplay() error = %v", err)
+	}
+
+	want := `# Colombia
+# Roasted 2025-06-01 10:00
+S
+WAIT 1m
+ROASTING
+WAIT 1m
+P7
+WAIT 4m
+FC
+WAIT 1m
+NOTE dark spots
+WAIT 1m
+COOL
+`
+	if out.String() != want {
+		t.Errorf("WriteReplay() =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestRecordedRoastWithRelativeSettings(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time {
+		return start.Add(d)
+	}
+
+	entries := []RoastLogEntry{
+		{Time: at(0), Type: RoastLogCommand, Name: "I55", Status: "OK"},
+		{Time: at(time.Second), Type: RoastLogStart},
+		{Time: at(time.Second), Type: RoastLogCommand, Name: "S", Status: "OK"},
+		{Time: at(time.Minute), Type: RoastLogEvent, Name: "F+"},
+		{Time: at(time.Minute), Type: RoastLogCommand, Name: "F+", Status: "OK"},
+		{Time: at(2 * time.Minute), Type: RoastLogEvent, Name: "P-"},
+		{Time: at(2 * time.Minute), Type: RoastLogCommand, Name: "P-", Status: "OK"},
+		{Time: at(2*time.Minute + 30*time.Second), Type: RoastLogEvent, Name: "F+"},
+		{Time: at(2*time.Minute + 30*time.Second), Type: RoastLogCommand, Name: "F+", Status: "Error", Error: "fan is at max"},
+		{Time: at(3 * time.Minute), Type: RoastLogEvent, Name: "F6"},
+		{Time: at(3 * time.Minute), Type: RoastLogCommand, Name: "F6", Status: "OK"},
+		{Time: at(4 * time.Minute), Type: RoastLogDone},
+	}
+
+	var out bytes.Buffer
+	if err := WriteReplay(&out, RecordedRoastFromLog(entries), ConvertOptions{}); err != nil {
+		t.Fatalf("WriteReplay() error = %v", err)
+	}
+	want := `# Unnamed roast
+I55
+WAIT 1s
+S
+WAIT 59s
+F+
+WAIT 1m
+P-
+WAIT 1m
+F6
+WAIT 1m
+DONE
+`
+	if out.String() != want {
+		t.Errorf("WriteReplay() =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	session := twchart.Session{
+		StartTime: start,
+		Events: []twchart.Event{
+			{Note: "F+", Time: at(time.Minute)},
+			{Note: "P-", Time: at(2 * time.Minute)},
+		},
+	}
+	roast := RecordedRoastFromTWChart(session)
+	var commands []string
+	for _, step := range roast.Steps {
+		commands = append(commands, step.Command)
+	}
+	if want := []string{"S", "F+", "P-"}; !equalStrings(commands, want) {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+}
+
+func TestRecordedRoastWithRateOfRise(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time {
+		return start.Add(d)
+	}
+
+	// the rate of rise is removed from the setting's event, but notes that only look like it are kept
+	entries := []RoastLogEntry{
+		{Time: at(0), Type: RoastLogStart},
+		{Time: at(time.Minute), Type: RoastLogEvent, Name: "F6 (RoR 15.0°F/min)"},
+		{Time: at(time.Minute), Type: RoastLogCommand, Name: "F6", Status: "OK"},
+		{Time: at(2 * time.Minute), Type: RoastLogEvent, Name: "P7 (RoR -3.0°F/min)"},
+		{Time: at(2 * time.Minute), Type: RoastLogCommand, Name: "P7", Status: "OK"},
+		{Time: at(3 * time.Minute), Type: RoastLogEvent, Name: "RoR too fast"},
+	}
+	session := twchart.Session{
+		StartTime: start,
+		Events: []twchart.Event{
+			{Note: "F6 (RoR 15.0°F/min)", Time: at(time.Minute)},
+			{Note: "P7 (RoR -3.0°F/min)", Time: at(2 * time.Minute)},
+			{Note: "RoR too fast", Time: at(3 * time.Minute)},
+		},
+	}
+
+	want := `# Unnamed roast
+S
+WAIT 1m
+F6
+WAIT 1m
+P7
+WAIT 1m
+NOTE RoR too fast
+`
+	for name, roast := range map[string]RecordedRoast{
+		"Log":     RecordedRoastFromLog(entries),
+		"TWChart": RecordedRoastFromTWChart(session),
+	} {
+		var out bytes.Buffer
+		if err := WriteReplay(&out, roast, ConvertOptions{}); err != nil {
+			t.Fatalf("%s: WriteReplay() error = %v", name, err)
+		}
+		if out.String() != want {
+			t.Errorf("%s: WriteReplay() =\n%s\nwant\n%s", name, out.String(), want)
+		}
+	}
+}
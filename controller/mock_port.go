@@ -10,15 +10,53 @@ import (
 type mockPort struct {
 	commands  []string
 	responses bytes.Buffer
+	// failures maps commands to an error message that is returned instead of a successful response
+	failures map[string]string
+	// payloads maps commands to the payload of their successful response
+	payloads map[string]string
+	// outputs maps commands to extra output that is written before their response
+	outputs map[string]string
+	// unresponsive makes the firmware stop responding, so reads time out like a serial port's
+	unresponsive bool
 }
 
 func (p *mockPort) Write(command []byte) (int, error) {
-	p.commands = append(p.commands, string(command))
-	fmt.Fprintf(&p.responses, "[mock firmware] received %s%c", command, autoroast.TerminationChar)
+	reader := bytes.NewReader(command)
+	if start, err := reader.ReadByte(); err != nil || start != autoroast.FrameStart {
+		return 0, fmt.Errorf("expected request frame: %q", command)
+	}
+
+	req, err := autoroast.DecodeRequest(reader.ReadByte)
+	if err != nil {
+		p.responses.Write(autoroast.Response{
+			Seq:     req.Seq,
+			Status:  autoroast.StatusInvalidFrame,
+			Payload: []byte(err.Error()),
+		}.Encode())
+		return len(command), nil
+	}
+
+	p.commands = append(p.commands, string(req.Command))
+	if p.unresponsive {
+		return len(command), nil
+	}
+	fmt.Fprintf(&p.responses, "[mock firmware] received %s\r\n", req.Command)
+	p.responses.WriteString(p.outputs[string(req.Command)])
+
+	resp := autoroast.Response{Seq: req.Seq, Status: autoroast.StatusOK, Payload: []byte(p.payloads[string(req.Command)])}
+	if msg, ok := p.failures[string(req.Command)]; ok {
+		resp.Status = autoroast.StatusError
+		resp.Payload = []byte(msg)
+	}
+	p.responses.Write(resp.Encode())
+
 	return len(command), nil
 }
 
 func (p *mockPort) Read(out []byte) (int, error) {
+	if p.unresponsive && p.responses.Len() == 0 {
+		return 0, nil
+	}
 	return p.responses.Read(out)
 }
 
@@ -3,9 +3,10 @@ package controller
 import (
 	"bytes"
 	"context"
-	"fmt"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/calvinmclean/autoroast"
 )
@@ -46,11 +47,94 @@ func TestRunWithNoSerialLogsMockFirmwareCommands(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Run() error = %v", err)
 	}
-	if got, want := output.String(), fmt.Sprintf("[mock firmware] received F5%c\n", autoroast.TerminationChar); got != want {
+	if got, want := output.String(), "[mock firmware] received F5\n"; got != want {
 		t.Errorf("output = %q, want %q", got, want)
 	}
 }
 
+func TestPassthroughCommandReturnsFirmwareError(t *testing.T) {
+	port := &mockPort{failures: map[string]string{"F0": "invalid input: 0"}}
+	c := &Controller{port: port}
+
+	result, err := c.passthroughCommand([]byte("F5"))
+	if err != nil {
+		t.Fatalf("passthroughCommand() error = %v", err)
+	}
+	if result.Status != autoroast.StatusOK || result.Output != "[mock firmware] received F5" {
+		t.Errorf("result = %#v, want OK status with mock output", result)
+	}
+
+	result, err = c.passthroughCommand([]byte("F0"))
+	var firmwareErr *FirmwareError
+	if !errors.As(err, &firmwareErr) {
+		t.Fatalf("passthroughCommand() error = %v, want *FirmwareError", err)
+	}
+	if firmwareErr.Status != autoroast.StatusError || firmwareErr.Message != "invalid input: 0" {
+		t.Errorf("error = %#v, want StatusError with firmware message", firmwareErr)
+	}
+	if result.Command != "F0" {
+		t.Errorf("result.Command = %q, want %q", result.Command, "F0")
+	}
+}
+
+func TestPassthroughCommandSkipsStaleResponses(t *testing.T) {
+	port := &mockPort{}
+	c := &Controller{port: port}
+
+	// a response to an earlier request arrives late
+	port.responses.WriteString("stale output\r\n")
+	port.responses.Write(autoroast.Response{Seq: 200, Status: autoroast.StatusError}.Encode())
+
+	result, err := c.passthroughCommand([]byte("P4"))
+	if err != nil {
+		t.Fatalf("passthroughCommand() error = %v", err)
+	}
+	if got, want := result.Output, "[mock firmware] received P4"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestControllerStatus(t *testing.T) {
+	port := &mockPort{payloads: map[string]string{
+		"Q": "fan=5 power=6 mode=Power started=1 elapsed_ms=60000 remainder=0.000 verbose=0",
+	}}
+	c := &Controller{port: port}
+
+	status, err := c.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if status.Fan != 5 || status.Power != 6 || status.ControlMode != autoroast.ControlModePower {
+		t.Errorf("status = %#v, want F5/P6 in Power mode", status)
+	}
+	if elapsed := time.Since(status.StartTime); elapsed < time.Minute || elapsed > time.Minute+time.Second {
+		t.Errorf("StartTime = %v, want about one minute ago", status.StartTime)
+	}
+}
+
+func TestControllerCalibration(t *testing.T) {
+	port := &mockPort{payloads: map[string]string{
+		"k*": "ServoBasePosition=30 StepsPerIncrement=68.5",
+	}}
+	c := &Controller{port: port}
+
+	values, err := c.Calibration(context.Background())
+	if err != nil {
+		t.Fatalf("Calibration() error = %v", err)
+	}
+	if got := values[autoroast.CalibrationStepsPerIncrement]; got != 68.5 {
+		t.Errorf("StepsPerIncrement = %v, want 68.5", got)
+	}
+
+	err = c.SetCalibration(context.Background(), autoroast.CalibrationServoPressDelay, 200)
+	if err != nil {
+		t.Fatalf("SetCalibration() error = %v", err)
+	}
+	if got, want := port.commands, []string{"k*", "Kp200.00"}; !equalStrings(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
 func equalStrings(got, want []string) bool {
 	if len(got) != len(want) {
 		return false
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RoastRecorder keeps the commands sent during a roast so it can be written as a replay file with WriteReplay.
+// Only commands that change the roast are kept: fan and power settings, S, stages, DONE, and notes
+type RoastRecorder struct {
+	mu    sync.Mutex
+	roast RecordedRoast
+}
+
+func NewRoastRecorder(name string) *RoastRecorder {
+	return &RoastRecorder{roast: RecordedRoast{Name: name}}
+}
+
+// Record adds the command if it is part of the roast. Stage aliases like PH are recorded as the full command
+func (r *RoastRecorder) Record(command string, t time.Time) {
+	command = strings.TrimSpace(command)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.roast.Date.IsZero() {
+		r.roast.Date = t
+	}
+
+	switch {
+	case isSettingCommand(command), isRelativeSettingCommand(command), command == "S", command == "DONE":
+		r.roast.add(t, command, "")
+	case stageForCommand(command) != "":
+		r.roast.add(t, stageCommand(stageForCommand(command)), "")
+	case strings.HasPrefix(command, "NOTE "):
+		if note := strings.TrimSpace(strings.TrimPrefix(command, "NOTE ")); note != "" {
+			r.roast.add(t, "", note)
+		}
+	}
+}
+
+// Reject removes the last time that the command was recorded because the firmware didn't accept it
+func (r *RoastRecorder) Reject(command string) {
+	command = strings.TrimSpace(command)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := len(r.roast.Steps) - 1; i >= 0; i-- {
+		if r.roast.Steps[i].Command == command {
+			r.roast.Steps = slices.Delete(r.roast.Steps, i, i+1)
+			return
+		}
+	}
+}
+
+// Roast returns a copy of everything recorded so far
+func (r *RoastRecorder) Roast() RecordedRoast {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	roast := r.roast
+	roast.Steps = slices.Clone(r.roast.Steps)
+	return roast
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoastRecorder(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	recorder := NewRoastRecorder("Ethiopia")
+	recorder.Record("I55", at(0))
+	recorder.Record("PH", at(5*time.Second))
+	recorder.Record("C", at(10*time.Second))
+	recorder.Record("S", at(10*time.Second))
+	recorder.Record("ROAST", at(40*time.Second))
+	recorder.Record("F6", at(time.Minute))
+	recorder.Record("P0", at(90*time.Second))
+	recorder.Record("P8", at(2*time.Minute))
+	recorder.Record("P-", at(150*time.Second))
+	recorder.Record("P=", at(150*time.Second))
+	recorder.Record("NOTE smells like bread", at(3*time.Minute))
+	recorder.Record("CRACK", at(4*time.Minute))
+	recorder.Record("F8", at(4*time.Minute+30*time.Second))
+	recorder.Record("F8", at(5*time.Minute))
+	recorder.Record("COOL", at(5*time.Minute))
+	recorder.Record("CONFIRM", at(6*time.Minute))
+	recorder.Record("DONE", at(8*time.Minute))
+
+	// only the last F8 is removed
+	recorder.Reject("F8")
+	recorder.Reject("P9")
+
+	var b bytes.Buffer
+	if err := WriteReplay(&b, recorder.Roast(), ConvertOptions{}); err != nil {
+		t.Fatalf("WriteReplay() error = %v", err)
+	}
+	want := `# Ethiopia
+# Roasted 2025-06-01 10:00
+I55
+WAIT 5s
+PREHEAT
+WAIT 5s
+S
+WAIT 30s
+ROASTING
+WAIT 20s
+F6
+WAIT 1m
+P8
+WAIT 30s
+P-
+WAIT 30s
+NOTE smells like bread
+WAIT 1m
+FC
+WAIT 30s
+F8
+WAIT 30s
+COOL
+WAIT 3m
+DONE
+`
+	if got := b.String(); got != want {
+		t.Errorf("replay =\n%s\nwant\n%s", got, want)
+	}
+
+	// the exported roast can be replayed
+	actions, err := ParseReplay(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatalf("ParseReplay() error = %v", err)
+	}
+	if issues := CheckReplay(actions); len(issues) != 0 {
+		t.Errorf("CheckReplay() = %v, want no issues", issues)
+	}
+}
+
+func TestRoastRecorderCopiesSteps(t *testing.T) {
+	recorder := NewRoastRecorder("")
+	recorder.Record("F5", time.Now())
+
+	roast := recorder.Roast()
+	recorder.Record("P5", time.Now())
+	if len(roast.Steps) != 1 {
+		t.Errorf("steps = %v, want the copy to keep only F5", roast.Steps)
+	}
+}
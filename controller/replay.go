@@ -7,35 +7,157 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/calvinmclean/autoroast/firmware/commands"
+)
+
+// ReplayAnchor is a point in the roast that AT actions are scheduled from
+type ReplayAnchor int
+
+const (
+	ReplayAnchorNone ReplayAnchor = iota
+	// ReplayAnchorStart is when the roaster is started with S
+	ReplayAnchorStart
+	// ReplayAnchorFirstCrack is when FC or CRACK is sent
+	ReplayAnchorFirstCrack
 )
 
+// anchorForCommand returns the anchor that the command marks, if any. Controller commands are case-insensitive
+// in replays, but firmware commands are not, so s+1 doesn't mark the start. Firmware commands that are chained
+// with S, like I55S, also mark the start
+func anchorForCommand(command string) ReplayAnchor {
+	command = strings.TrimSpace(command)
+	if stageForCommand(command) == StageFirstCrack {
+		return ReplayAnchorFirstCrack
+	}
+	if isExternalCommand(command) {
+		return ReplayAnchorNone
+	}
+	for _, fc := range parseFirmwareCommands(command) {
+		if fc.command == commands.StartCommand {
+			return ReplayAnchorStart
+		}
+	}
+	return ReplayAnchorNone
+}
+
+// stageForCommand returns the name of the stage that the command adds, if any. It is case-insensitive
+func stageForCommand(command string) string {
+	cmd, _ := LookupExternalCommand(strings.TrimSpace(command))
+	return cmd.Stage
+}
+
 type ReplayAction struct {
-	line    int
+	line int
+	// file is the fragment that the action was included from. It is empty for actions in the replay file
+	file    string
 	command string
 	wait    time.Duration
 	alert   string
+	// anchor is set for AT actions, which wait until at has passed since the anchor
+	anchor ReplayAnchor
+	at     time.Duration
+	// waitStage or confirm is set for WAITFOR actions, which hold the queue until the stage is added or CONFIRM
+	// is sent. They stop waiting after the timeout, if it is set
+	waitStage string
+	confirm   string
+	timeout   time.Duration
+	// ramp is F or P for RAMP actions, which change the setting one level at a time from rampFrom to rampTo
+	// with the steps spread evenly over rampOver
+	ramp     byte
+	rampFrom int
+	rampTo   int
+	rampOver time.Duration
+}
+
+// rampSteps returns the number of single-level changes in a RAMP
+func (a ReplayAction) rampSteps() int {
+	if a.rampTo > a.rampFrom {
+		return a.rampTo - a.rampFrom
+	}
+	return a.rampFrom - a.rampTo
+}
+
+// rampCommand returns the command that sets the RAMP's setting after the number of steps
+func (a ReplayAction) rampCommand(step int) string {
+	level := a.rampFrom + step
+	if a.rampTo < a.rampFrom {
+		level = a.rampFrom - step
+	}
+	return fmt.Sprintf("%c%d", a.ramp, level)
 }
 
 func (a ReplayAction) String() string {
 	if a.wait > 0 {
 		return fmt.Sprintf("WAIT %s", a.wait)
 	}
+	switch a.anchor {
+	case ReplayAnchorStart:
+		return fmt.Sprintf("AT %s", a.at)
+	case ReplayAnchorFirstCrack:
+		if a.at == 0 {
+			return "AT FC"
+		}
+		return fmt.Sprintf("AT FC+%s", a.at)
+	}
+	if a.ramp != 0 {
+		return fmt.Sprintf("RAMP %c %d..%d OVER %s", a.ramp, a.rampFrom, a.rampTo, a.rampOver)
+	}
+	if a.waitsForEvent() {
+		var text string
+		switch {
+		case a.confirm != "":
+			text = "WAITFOR CONFIRM " + strconv.Quote(a.confirm)
+		case a.waitStage == "First Crack":
+			text = "WAITFOR FC"
+		default:
+			text = "WAITFOR STAGE " + a.waitStage
+		}
+		if a.timeout > 0 {
+			text += fmt.Sprintf(" TIMEOUT %s", a.timeout)
+		}
+		return text
+	}
 	if a.alert != "" {
 		return fmt.Sprintf("ALERT %s", a.alert)
 	}
 	return a.command
 }
 
+func (a ReplayAction) waitsForEvent() bool {
+	return a.waitStage != "" || a.confirm != ""
+}
+
 type ReplayState struct {
-	Current   string
+	Current string
+	// CurrentID is the ID of the current action. It is only set when Current is set, so it can be used to tell
+	// when a new action starts even if it has the same text as the last one
+	CurrentID int
 	Queued    []ReplayQueuedAction
 	Started   bool
 	Running   bool
 	Cancelled bool
 	WaitUntil time.Time
+	// Waiting is true when the current action is a WAIT, AT, or WAITFOR that can be skipped. WaitUntil is zero
+	// while an AT action is waiting for its anchor or a WAITFOR action doesn't have a timeout
+	Waiting bool
+	// Confirm is the message for the current WAITFOR CONFIRM action
+	Confirm string
+	// Ramping is true when the current action is a RAMP. Progress is how much of it is done, from 0 to 1
+	Ramping  bool
+	Progress float64
+	// Paused is true while the replay is paused. Remaining is how much of the current wait was left when it was
+	// paused, and WaitUntil is zero until it resumes
+	Paused    bool
+	Remaining time.Duration
+	// Error is set when the replay was stopped by Fail
+	Error string
 }
 
 type ReplayQueuedAction struct {
@@ -55,27 +177,47 @@ type Replay struct {
 	started   bool
 	running   bool
 	cancelled bool
+	paused    bool
+	pausedAt  time.Time
+	err       error
 	waitUntil time.Time
-	notify    func(ReplayState)
-	onAlert   func(message string)
-	skip      chan struct{}
+	anchors   map[ReplayAnchor]time.Time
+	stages    map[string]time.Time
+	// confirms counts CONFIRM commands so a WAITFOR CONFIRM only accepts ones sent after it started
+	confirms       int
+	currentConfirm int
+	notify         func(ReplayState)
+	onAlert        func(message string)
+	skip           chan struct{}
+	stop           chan struct{}
+	observed       chan struct{}
+	pause          chan struct{}
+	resume         chan struct{}
+	// rampStep is the number of steps that the current RAMP action has completed
+	rampStep int
+	// nextID is the ID of the next queue item. IDs aren't reused so a removed item's ID never refers to a new one
+	nextID int
 }
 
 func NewReplay(actions []ReplayAction, notify func(ReplayState), onAlert func(message string)) *Replay {
 	r := &Replay{
-		notify:  notify,
-		onAlert: onAlert,
-		skip:    make(chan struct{}, 1),
-	}
-	for id, action := range actions {
-		r.queued = append(r.queued, replayItem{id: id, action: action})
+		notify:   notify,
+		onAlert:  onAlert,
+		anchors:  map[ReplayAnchor]time.Time{},
+		stages:   map[string]time.Time{},
+		skip:     make(chan struct{}, 1),
+		stop:     make(chan struct{}, 1),
+		observed: make(chan struct{}, 1),
+		pause:    make(chan struct{}, 1),
+		resume:   make(chan struct{}, 1),
 	}
+	r.queued = r.newItemsLocked(actions)
 	return r
 }
 
 func (r *Replay) Skip() bool {
 	r.mu.Lock()
-	if !r.running || r.current == nil || r.current.action.wait == 0 {
+	if !r.running || r.paused || r.current == nil || !r.current.action.waits() {
 		r.mu.Unlock()
 		return false
 	}
@@ -87,6 +229,158 @@ func (r *Replay) Skip() bool {
 	return true
 }
 
+// Pause stops the replay from sending commands until Resume is called. The remaining time of the current WAIT,
+// RAMP, or WAITFOR TIMEOUT is kept, but AT actions are still scheduled from their anchor. It returns false if the
+// replay is not running or is already paused
+func (r *Replay) Pause() bool {
+	r.mu.Lock()
+	if !r.running || r.paused {
+		r.mu.Unlock()
+		return false
+	}
+	r.paused = true
+	r.pausedAt = time.Now()
+	state := r.stateLocked()
+	r.mu.Unlock()
+
+	select {
+	case r.pause <- struct{}{}:
+	default:
+	}
+	r.notify(state)
+	return true
+}
+
+// Resume continues a paused replay. It returns false if the replay is not paused
+func (r *Replay) Resume() bool {
+	r.mu.Lock()
+	if !r.paused {
+		r.mu.Unlock()
+		return false
+	}
+	r.paused = false
+	if r.current != nil && r.current.action.anchor == ReplayAnchorNone && !r.waitUntil.IsZero() {
+		r.waitUntil = r.waitUntil.Add(time.Since(r.pausedAt))
+	}
+	r.pausedAt = time.Time{}
+	state := r.stateLocked()
+	r.mu.Unlock()
+
+	select {
+	case r.resume <- struct{}{}:
+	default:
+	}
+	r.notify(state)
+	return true
+}
+
+// waitForResume holds the replay while it is paused. It returns false if the replay is stopped first
+func (r *Replay) waitForResume(ctx context.Context) bool {
+	for {
+		r.mu.Lock()
+		paused := r.paused
+		r.mu.Unlock()
+		if !paused {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-r.stop:
+			return false
+		case <-r.resume:
+		}
+	}
+}
+
+// Fail stops a running replay because a command that it sent was not successful. It returns false if the
+// replay is not running
+func (r *Replay) Fail(err error) bool {
+	r.mu.Lock()
+	if !r.running {
+		r.mu.Unlock()
+		return false
+	}
+	r.err = err
+	r.mu.Unlock()
+	select {
+	case r.stop <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// SetAnchor records when the anchor happened so AT actions can be scheduled from it. Only the first time is
+// kept so restarting the roaster doesn't shift the schedule
+func (r *Replay) SetAnchor(anchor ReplayAnchor, t time.Time) {
+	if anchor == ReplayAnchorNone || t.IsZero() {
+		return
+	}
+
+	r.mu.Lock()
+	if _, ok := r.anchors[anchor]; ok {
+		r.mu.Unlock()
+		return
+	}
+	r.anchors[anchor] = t
+	r.mu.Unlock()
+
+	r.signalObserved()
+}
+
+// ObserveCommand sets the anchor, stage, or confirmation for a command that was sent to the controller.
+// Commands sent by the replay are observed automatically, so this is only needed for commands sent by
+// something else
+func (r *Replay) ObserveCommand(command string, t time.Time) {
+	r.SetAnchor(anchorForCommand(command), t)
+
+	r.mu.Lock()
+	if stage := stageForCommand(command); stage != "" {
+		if _, ok := r.stages[stage]; !ok {
+			r.stages[stage] = t
+		}
+	}
+	if strings.EqualFold(strings.TrimSpace(command), "CONFIRM") {
+		r.confirms++
+	}
+	r.mu.Unlock()
+
+	r.signalObserved()
+}
+
+// ResumeSession sets the anchors and stages from a resumed session, so AT and WAITFOR actions are scheduled from
+// when they happened before the restart instead of waiting for them again
+func (r *Replay) ResumeSession(session SessionState) {
+	r.SetAnchor(ReplayAnchorStart, session.StartTime)
+	for _, stage := range session.Stages {
+		if stage.Name == StageFirstCrack {
+			r.SetAnchor(ReplayAnchorFirstCrack, stage.Start)
+		}
+	}
+
+	r.mu.Lock()
+	for _, stage := range session.Stages {
+		if _, ok := r.stages[stage.Name]; !ok && !stage.Start.IsZero() {
+			r.stages[stage.Name] = stage.Start
+		}
+	}
+	r.mu.Unlock()
+
+	r.signalObserved()
+}
+
+func (r *Replay) signalObserved() {
+	select {
+	case r.observed <- struct{}{}:
+	default:
+	}
+}
+
+func (a ReplayAction) waits() bool {
+	return a.wait > 0 || a.anchor != ReplayAnchorNone || a.waitsForEvent() || a.ramp != 0
+}
+
 func (r *Replay) clearSkip() {
 	select {
 	case <-r.skip:
@@ -115,26 +409,102 @@ func (r *Replay) AddQueued(input string) error {
 		return err
 	}
 	if len(actions) != 1 {
-		return errors.New("add action requires exactly one command or WAIT")
+		return errors.New("add action requires exactly one command, WAIT, AT, WAITFOR, or RAMP")
 	}
 
 	r.mu.Lock()
-	nextID := 0
-	if r.current != nil {
-		nextID = r.current.id + 1
+	r.queued = append(r.queued, r.newItemsLocked(actions)...)
+	state := r.stateLocked()
+	r.mu.Unlock()
+	r.notify(state)
+	return nil
+}
+
+// InsertQueued parses the input like a replay file and inserts its actions into the queue at the index, so 0
+// runs them next and the length of the queue runs them last
+func (r *Replay) InsertQueued(index int, input string) error {
+	actions, err := parseQueuedInput(input)
+	if err != nil {
+		return err
 	}
-	for _, item := range r.queued {
-		if item.id >= nextID {
-			nextID = item.id + 1
-		}
+
+	r.mu.Lock()
+	if index < 0 || index > len(r.queued) {
+		r.mu.Unlock()
+		return fmt.Errorf("invalid queue position %d", index)
+	}
+	r.queued = slices.Insert(r.queued, index, r.newItemsLocked(actions)...)
+	state := r.stateLocked()
+	r.mu.Unlock()
+	r.notify(state)
+	return nil
+}
+
+// InsertQueuedAfter parses the input like InsertQueued and inserts its actions after the queued action with the ID.
+// The ID is looked up when inserting, so it is still correct if the queue changed since it was shown
+func (r *Replay) InsertQueuedAfter(id int, input string) error {
+	actions, err := parseQueuedInput(input)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	index := slices.IndexFunc(r.queued, func(item replayItem) bool { return item.id == id })
+	if index < 0 {
+		r.mu.Unlock()
+		return fmt.Errorf("action %d is not queued", id)
 	}
-	r.queued = append(r.queued, replayItem{id: nextID, action: actions[0]})
+	r.queued = slices.Insert(r.queued, index+1, r.newItemsLocked(actions)...)
 	state := r.stateLocked()
 	r.mu.Unlock()
 	r.notify(state)
 	return nil
 }
 
+// UpdateQueued replaces the queued action with the actions parsed from the input. The first one keeps the ID
+// so it stays selected in the UI
+func (r *Replay) UpdateQueued(id int, input string) error {
+	actions, err := parseQueuedInput(input)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	index := slices.IndexFunc(r.queued, func(item replayItem) bool { return item.id == id })
+	if index < 0 {
+		r.mu.Unlock()
+		return fmt.Errorf("action %d is not queued", id)
+	}
+	items := append([]replayItem{{id: id, action: actions[0]}}, r.newItemsLocked(actions[1:])...)
+	r.queued = slices.Replace(r.queued, index, index+1, items...)
+	state := r.stateLocked()
+	r.mu.Unlock()
+	r.notify(state)
+	return nil
+}
+
+// parseQueuedInput parses actions to add to the queue. It is an error if there aren't any
+func parseQueuedInput(input string) ([]ReplayAction, error) {
+	actions, err := ParseReplay(strings.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	if len(actions) == 0 {
+		return nil, errors.New("no actions to queue")
+	}
+	return actions, nil
+}
+
+// newItemsLocked creates queue items for the actions with IDs that haven't been used before
+func (r *Replay) newItemsLocked(actions []ReplayAction) []replayItem {
+	items := make([]replayItem, 0, len(actions))
+	for _, action := range actions {
+		items = append(items, replayItem{id: r.nextID, action: action})
+		r.nextID++
+	}
+	return items
+}
+
 func (r *Replay) MoveQueued(id, offset int) bool {
 	r.mu.Lock()
 	index := -1
@@ -183,8 +553,27 @@ func (r *Replay) State() ReplayState {
 
 func (r *Replay) stateLocked() ReplayState {
 	state := ReplayState{Started: r.started, Running: r.running, Cancelled: r.cancelled, WaitUntil: r.waitUntil}
+	if r.paused {
+		state.Paused = true
+		state.WaitUntil = time.Time{}
+		if !r.waitUntil.IsZero() {
+			state.Remaining = max(r.waitUntil.Sub(r.pausedAt), 0)
+		}
+	}
+	if r.err != nil {
+		state.Error = r.err.Error()
+	}
 	if r.current != nil {
 		state.Current = r.current.action.String()
+		state.CurrentID = r.current.id
+		state.Waiting = r.running && r.current.action.waits()
+		if r.running {
+			state.Confirm = r.current.action.confirm
+		}
+		if r.current.action.ramp != 0 {
+			state.Ramping = true
+			state.Progress = float64(r.rampStep) / float64(r.current.action.rampSteps())
+		}
 	}
 	for _, item := range r.queued {
 		state.Queued = append(state.Queued, ReplayQueuedAction{ID: item.id, Text: item.action.String()})
@@ -197,18 +586,117 @@ func (r *Replay) emit() {
 }
 
 func LoadReplay(path string) ([]ReplayAction, error) {
+	return LoadReplayWithVars(path, nil)
+}
+
+// LoadReplayWithVars loads a replay file and overrides the variables that it declares with SET. Files
+// included with INCLUDE are loaded relative to it
+func LoadReplayWithVars(path string, vars map[string]string) ([]ReplayAction, error) {
+	p := newReplayParser(vars)
+	actions, err := p.load(path, "")
+	if err != nil {
+		return nil, err
+	}
+	return actions, p.vars.checkOverrides()
+}
+
+// maxReplayActions limits how many actions REPEAT blocks can expand to
+const maxReplayActions = 10000
+
+// replayBlock collects the actions in a REPEAT block until its END
+type replayBlock struct {
+	line    int
+	count   int
+	actions []ReplayAction
+}
+
+// ParseReplay reads replay actions, one per line. REPEAT blocks are expanded so each repeated action keeps the
+// line number it was written on. Variables declared with SET are substituted for ${name} in the lines after them.
+// Since there is no replay file to load them relative to, only absolute paths can be included with INCLUDE
+func ParseReplay(r io.Reader) ([]ReplayAction, error) {
+	return ParseReplayWithVars(r, nil)
+}
+
+// ParseReplayWithVars reads replay actions like ParseReplay, but uses the values in vars instead of the ones
+// declared with SET in the file. It is an error to override a variable that is not declared
+func ParseReplayWithVars(r io.Reader, vars map[string]string) ([]ReplayAction, error) {
+	p := newReplayParser(vars)
+	actions, err := p.parse(r, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return actions, p.vars.checkOverrides()
+}
+
+// replayParser parses a replay file and the fragments that it includes, which share its variables
+type replayParser struct {
+	vars *replayVars
+	// including is the absolute path of each file that is being parsed, to find INCLUDE cycles
+	including []string
+}
+
+func newReplayParser(overrides map[string]string) *replayParser {
+	return &replayParser{vars: newReplayVars(overrides)}
+}
+
+// load parses the replay file at path. file is the path shown for actions and errors in an included fragment,
+// and is empty for the replay file
+func (p *replayParser) load(path, file string) ([]ReplayAction, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("open replay file: %w", err)
 	}
 	defer f.Close()
 
-	return ParseReplay(f)
+	return p.parse(f, path, file)
 }
 
-func ParseReplay(r io.Reader) ([]ReplayAction, error) {
+// include parses the fragment for an INCLUDE directive. The fragment is relative to the file that includes it
+func (p *replayParser) include(path, target string) ([]ReplayAction, error) {
+	if target == "" {
+		return nil, errors.New("INCLUDE requires a file")
+	}
+
+	fragment := target
+	if !filepath.IsAbs(fragment) {
+		// without a replay file, a relative path would depend on the working directory
+		if path == "" {
+			return nil, fmt.Errorf("INCLUDE %s: relative paths can only be included from a replay file", target)
+		}
+		fragment = filepath.Join(filepath.Dir(path), fragment)
+	}
+	abs, err := filepath.Abs(fragment)
+	if err != nil {
+		return nil, fmt.Errorf("INCLUDE %s: %w", target, err)
+	}
+	if i := slices.Index(p.including, abs); i >= 0 {
+		cycle := make([]string, 0, len(p.including)-i+1)
+		for _, included := range p.including[i:] {
+			cycle = append(cycle, filepath.Base(included))
+		}
+		cycle = append(cycle, filepath.Base(abs))
+		return nil, fmt.Errorf("INCLUDE %s: cycle %s", target, strings.Join(cycle, " -> "))
+	}
+
+	actions, err := p.load(fragment, fragment)
+	if err != nil {
+		return nil, fmt.Errorf("INCLUDE %s: %w", target, err)
+	}
+	return actions, nil
+}
+
+func (p *replayParser) parse(r io.Reader, path, file string) ([]ReplayAction, error) {
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("open replay file: %w", err)
+		}
+		p.including = append(p.including, abs)
+		defer func() { p.including = p.including[:len(p.including)-1] }()
+	}
+
 	scanner := bufio.NewScanner(r)
-	var actions []ReplayAction
+	blocks := []*replayBlock{{}}
 	lineNumber := 0
 	for scanner.Scan() {
 		lineNumber++
@@ -218,36 +706,263 @@ func ParseReplay(r io.Reader) ([]ReplayAction, error) {
 		}
 
 		fields := strings.Fields(line)
+		if strings.EqualFold(fields[0], "SET") {
+			if err := p.vars.set(file, lineNumber, line[len(fields[0]):]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			continue
+		}
+
+		line, err := p.vars.substitute(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		fields = strings.Fields(line)
 		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: line is empty after substituting variables", lineNumber)
+		}
+		block := blocks[len(blocks)-1]
+
+		if strings.EqualFold(fields[0], "INCLUDE") {
+			actions, err := p.include(path, strings.TrimSpace(line[len(fields[0]):]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			if len(block.actions)+len(actions) > maxReplayActions {
+				return nil, fmt.Errorf("line %d: INCLUDE expands to more than %d actions", lineNumber, maxReplayActions)
+			}
+			block.actions = append(block.actions, actions...)
 			continue
 		}
-		if strings.EqualFold(fields[0], "WAIT") {
+
+		if strings.EqualFold(fields[0], "REPEAT") {
 			if len(fields) != 2 {
-				return nil, fmt.Errorf("line %d: WAIT requires exactly one duration", lineNumber)
+				return nil, fmt.Errorf("line %d: REPEAT requires exactly one count", lineNumber)
 			}
-			duration, err := time.ParseDuration(fields[1])
-			if err != nil || duration <= 0 {
-				return nil, fmt.Errorf("line %d: invalid WAIT duration %q", lineNumber, fields[1])
+			count, err := strconv.Atoi(fields[1])
+			if err != nil || count <= 0 {
+				return nil, fmt.Errorf("line %d: invalid REPEAT count %q", lineNumber, fields[1])
 			}
-			actions = append(actions, ReplayAction{line: lineNumber, wait: duration})
+			blocks = append(blocks, &replayBlock{line: lineNumber, count: count})
 			continue
 		}
 
-		if strings.EqualFold(fields[0], "ALERT") {
-			message := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
-			if message == "" {
-				return nil, fmt.Errorf("line %d: ALERT requires a message", lineNumber)
+		if strings.EqualFold(fields[0], "END") {
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("line %d: END does not take any arguments", lineNumber)
+			}
+			if len(blocks) == 1 {
+				return nil, fmt.Errorf("line %d: END without REPEAT", lineNumber)
+			}
+			blocks = blocks[:len(blocks)-1]
+			parent := blocks[len(blocks)-1]
+			if len(parent.actions)+block.count*len(block.actions) > maxReplayActions {
+				return nil, fmt.Errorf("line %d: REPEAT expands to more than %d actions", block.line, maxReplayActions)
+			}
+			for range block.count {
+				parent.actions = append(parent.actions, block.actions...)
 			}
-			actions = append(actions, ReplayAction{line: lineNumber, alert: message})
 			continue
 		}
 
-		actions = append(actions, ReplayAction{line: lineNumber, command: line})
+		action, err := parseReplayAction(lineNumber, line, fields)
+		if err != nil {
+			return nil, err
+		}
+		action.file = file
+		block.actions = append(block.actions, action)
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("read replay file: %w", err)
 	}
-	return actions, nil
+	if len(blocks) > 1 {
+		return nil, fmt.Errorf("line %d: REPEAT without END", blocks[len(blocks)-1].line)
+	}
+	return blocks[0].actions, nil
+}
+
+func parseReplayAction(lineNumber int, line string, fields []string) (ReplayAction, error) {
+	if strings.EqualFold(fields[0], "WAIT") {
+		if len(fields) != 2 {
+			return ReplayAction{}, fmt.Errorf("line %d: WAIT requires exactly one duration", lineNumber)
+		}
+		duration, err := time.ParseDuration(fields[1])
+		if err != nil || duration <= 0 {
+			return ReplayAction{}, fmt.Errorf("line %d: invalid WAIT duration %q", lineNumber, fields[1])
+		}
+		return ReplayAction{line: lineNumber, wait: duration}, nil
+	}
+
+	if strings.EqualFold(fields[0], "AT") {
+		if len(fields) != 2 {
+			return ReplayAction{}, fmt.Errorf("line %d: AT requires exactly one time", lineNumber)
+		}
+		anchor, at, err := parseReplayAt(fields[1])
+		if err != nil {
+			return ReplayAction{}, fmt.Errorf("line %d: invalid AT time %q: %w", lineNumber, fields[1], err)
+		}
+		return ReplayAction{line: lineNumber, anchor: anchor, at: at}, nil
+	}
+
+	if strings.EqualFold(fields[0], "WAITFOR") {
+		action, err := parseReplayWaitFor(strings.TrimSpace(line[len(fields[0]):]))
+		if err != nil {
+			return ReplayAction{}, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		action.line = lineNumber
+		return action, nil
+	}
+
+	if strings.EqualFold(fields[0], "RAMP") {
+		action, err := parseReplayRamp(fields[1:])
+		if err != nil {
+			return ReplayAction{}, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		action.line = lineNumber
+		return action, nil
+	}
+
+	if strings.EqualFold(fields[0], "ALERT") {
+		message := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		if message == "" {
+			return ReplayAction{}, fmt.Errorf("line %d: ALERT requires a message", lineNumber)
+		}
+		return ReplayAction{line: lineNumber, alert: message}, nil
+	}
+
+	return ReplayAction{line: lineNumber, command: line}, nil
+}
+
+// parseReplayWaitFor parses the event and optional TIMEOUT for a WAITFOR action
+func parseReplayWaitFor(input string) (ReplayAction, error) {
+	var action ReplayAction
+	var event, rest string
+	if fields := strings.Fields(input); len(fields) > 0 {
+		event = fields[0]
+		rest = strings.TrimSpace(input[len(event):])
+	}
+
+	switch strings.ToUpper(event) {
+	case "FC", "CRACK":
+		action.waitStage = "First Crack"
+	case "STAGE":
+		name, timeout, _ := cutTimeout(rest)
+		for _, cmd := range externalCommands {
+			if cmd.Stage != "" && strings.EqualFold(cmd.Stage, name) {
+				action.waitStage = cmd.Stage
+			}
+		}
+		if action.waitStage == "" {
+			return ReplayAction{}, fmt.Errorf("unknown WAITFOR stage %q", name)
+		}
+		rest = timeout
+	case "CONFIRM":
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return ReplayAction{}, errors.New("WAITFOR CONFIRM requires a quoted message")
+		}
+		action.confirm, _ = strconv.Unquote(quoted)
+		if strings.TrimSpace(action.confirm) == "" {
+			return ReplayAction{}, errors.New("WAITFOR CONFIRM requires a message")
+		}
+		rest = strings.TrimSpace(rest[len(quoted):])
+	case "":
+		return ReplayAction{}, errors.New("WAITFOR requires FC, STAGE, or CONFIRM")
+	default:
+		return ReplayAction{}, fmt.Errorf("unknown WAITFOR event %q", event)
+	}
+
+	fields := strings.Fields(rest)
+	switch {
+	case len(fields) == 0:
+	case len(fields) == 2 && strings.EqualFold(fields[0], "TIMEOUT"):
+		timeout, err := time.ParseDuration(fields[1])
+		if err != nil || timeout <= 0 {
+			return ReplayAction{}, fmt.Errorf("invalid WAITFOR timeout %q", fields[1])
+		}
+		action.timeout = timeout
+	default:
+		return ReplayAction{}, fmt.Errorf("unexpected input after WAITFOR event: %q", rest)
+	}
+
+	return action, nil
+}
+
+// cutTimeout splits the input before a TIMEOUT keyword
+func cutTimeout(input string) (string, string, bool) {
+	fields := strings.Fields(input)
+	for i, field := range fields {
+		if strings.EqualFold(field, "TIMEOUT") {
+			return strings.Join(fields[:i], " "), strings.Join(fields[i:], " "), true
+		}
+	}
+	return strings.Join(fields, " "), "", false
+}
+
+// parseReplayRamp parses the setting, levels, and duration for a RAMP action, like P 4..8 OVER 2m
+func parseReplayRamp(fields []string) (ReplayAction, error) {
+	if len(fields) != 4 || !strings.EqualFold(fields[2], "OVER") {
+		return ReplayAction{}, errors.New("RAMP requires a setting, levels, and duration like RAMP P 4..8 OVER 2m")
+	}
+
+	var action ReplayAction
+	switch strings.ToUpper(fields[0]) {
+	case "F":
+		action.ramp = 'F'
+	case "P":
+		action.ramp = 'P'
+	default:
+		return ReplayAction{}, fmt.Errorf("invalid RAMP setting %q: must be F or P", fields[0])
+	}
+
+	from, to, ok := strings.Cut(fields[1], "..")
+	if !ok {
+		return ReplayAction{}, fmt.Errorf("invalid RAMP levels %q", fields[1])
+	}
+	var err error
+	action.rampFrom, err = strconv.Atoi(from)
+	if err != nil || action.rampFrom < 1 || action.rampFrom > 9 {
+		return ReplayAction{}, fmt.Errorf("invalid RAMP level %q: must be 1-9", from)
+	}
+	action.rampTo, err = strconv.Atoi(to)
+	if err != nil || action.rampTo < 1 || action.rampTo > 9 {
+		return ReplayAction{}, fmt.Errorf("invalid RAMP level %q: must be 1-9", to)
+	}
+	if action.rampFrom == action.rampTo {
+		return ReplayAction{}, fmt.Errorf("RAMP levels must be different: %q", fields[1])
+	}
+
+	action.rampOver, err = time.ParseDuration(fields[3])
+	if err != nil || action.rampOver <= 0 {
+		return ReplayAction{}, fmt.Errorf("invalid RAMP duration %q", fields[3])
+	}
+
+	return action, nil
+}
+
+// parseReplayAt parses the time for an AT action. It is either a duration since the roast started, like 3m30s,
+// or since first crack, like FC+1m
+func parseReplayAt(input string) (ReplayAnchor, time.Duration, error) {
+	anchor := ReplayAnchorStart
+	if prefix, offset, ok := strings.Cut(input, "+"); ok || strings.EqualFold(input, "FC") {
+		if !strings.EqualFold(prefix, "FC") {
+			return ReplayAnchorNone, 0, fmt.Errorf("unknown anchor %q", prefix)
+		}
+		if !ok {
+			return ReplayAnchorFirstCrack, 0, nil
+		}
+		anchor = ReplayAnchorFirstCrack
+		input = offset
+	}
+
+	at, err := time.ParseDuration(input)
+	if err != nil {
+		return ReplayAnchorNone, 0, err
+	}
+	if at < 0 {
+		return ReplayAnchorNone, 0, errors.New("time must not be negative")
+	}
+	return anchor, at, nil
 }
 
 func RunReplay(ctx context.Context, actions []ReplayAction, writer io.Writer, notify func(ReplayState)) error {
@@ -259,20 +974,38 @@ func (r *Replay) Run(ctx context.Context, writer io.Writer) error {
 	r.started = true
 	r.running = true
 	r.cancelled = false
+	r.paused = false
+	r.err = nil
 	r.mu.Unlock()
+	select {
+	case <-r.stop:
+	default:
+	}
+	select {
+	case <-r.pause:
+	default:
+	}
 	r.emit()
 
 	for {
-		if ctx.Err() != nil {
+		r.mu.Lock()
+		failed := r.err != nil
+		r.mu.Unlock()
+		if ctx.Err() != nil || failed {
 			r.mu.Lock()
 			r.running = false
 			r.cancelled = true
+			r.paused = false
 			r.waitUntil = time.Time{}
 			r.mu.Unlock()
 			r.emit()
 			return nil
 		}
 
+		if !r.waitForResume(ctx) {
+			continue
+		}
+
 		r.mu.Lock()
 		if len(r.queued) == 0 {
 			r.current = nil
@@ -285,31 +1018,38 @@ func (r *Replay) Run(ctx context.Context, writer io.Writer) error {
 		item := r.queued[0]
 		r.queued = r.queued[1:]
 		r.current = &item
-		if item.action.wait > 0 {
-			r.waitUntil = time.Now().Add(item.action.wait)
-		} else {
-			r.waitUntil = time.Time{}
-		}
+		r.currentConfirm = r.confirms
+		r.rampStep = 0
+		r.waitUntil = r.waitUntilLocked(item.action)
 		waitUntil := r.waitUntil
 		r.mu.Unlock()
 		r.clearSkip()
 		r.emit()
 
-		if item.action.wait > 0 {
-			timer := time.NewTimer(time.Until(waitUntil))
-			select {
-			case <-ctx.Done():
-				if !timer.Stop() {
-					<-timer.C
-				}
-				continue
-			case <-r.skip:
-				if !timer.Stop() {
-					<-timer.C
-				}
+		if item.action.anchor != ReplayAnchorNone && waitUntil.IsZero() {
+			var ok bool
+			waitUntil, ok = r.waitForAnchor(ctx, item.action)
+			if !ok {
 				continue
-			case <-timer.C:
 			}
+			r.emit()
+		}
+
+		if item.action.waitsForEvent() {
+			r.waitForEvent(ctx, item.action, waitUntil)
+			continue
+		}
+
+		if item.action.ramp != 0 {
+			err := r.runRamp(ctx, writer, item.action)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if item.action.waits() {
+			r.sleepUntil(ctx, waitUntil)
 			continue
 		}
 
@@ -323,5 +1063,172 @@ func (r *Replay) Run(ctx context.Context, writer io.Writer) error {
 		if _, err := fmt.Fprintln(writer, item.action.command); err != nil {
 			return fmt.Errorf("send replay command from line %d: %w", item.action.line, err)
 		}
+		r.ObserveCommand(item.action.command, time.Now())
+	}
+}
+
+// waitUntilLocked returns when a WAIT or AT action is done waiting. It is zero for other actions and for AT
+// actions whose anchor hasn't happened yet
+func (r *Replay) waitUntilLocked(action ReplayAction) time.Time {
+	if action.wait > 0 {
+		return time.Now().Add(action.wait)
+	}
+	if action.waitsForEvent() && action.timeout > 0 {
+		return time.Now().Add(action.timeout)
+	}
+	if anchorTime, ok := r.anchors[action.anchor]; ok && action.anchor != ReplayAnchorNone {
+		return anchorTime.Add(action.at)
+	}
+	return time.Time{}
+}
+
+// waitForAnchor waits until the AT action's anchor happens and returns when the action is done waiting. It
+// returns false if the wait was skipped or the replay was stopped first
+func (r *Replay) waitForAnchor(ctx context.Context, action ReplayAction) (time.Time, bool) {
+	for {
+		r.mu.Lock()
+		waitUntil := r.waitUntilLocked(action)
+		r.waitUntil = waitUntil
+		r.mu.Unlock()
+		if !waitUntil.IsZero() {
+			return waitUntil, true
+		}
+
+		select {
+		case <-ctx.Done():
+			return time.Time{}, false
+		case <-r.skip:
+			return time.Time{}, false
+		case <-r.stop:
+			return time.Time{}, false
+		case <-r.pause:
+			if !r.waitForResume(ctx) {
+				return time.Time{}, false
+			}
+		case <-r.observed:
+		}
+	}
+}
+
+// waitForEvent holds the queue until the WAITFOR action's stage is added or CONFIRM is sent. It stops waiting
+// at waitUntil if it is set
+func (r *Replay) waitForEvent(ctx context.Context, action ReplayAction, waitUntil time.Time) {
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	if !waitUntil.IsZero() {
+		timer = time.NewTimer(time.Until(waitUntil))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		r.mu.Lock()
+		observed := r.eventObservedLocked(action)
+		r.mu.Unlock()
+		if observed {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.skip:
+			return
+		case <-r.stop:
+			return
+		case <-timeout:
+			return
+		case <-r.pause:
+			if !r.waitForResume(ctx) {
+				return
+			}
+			// the timeout is extended by the time spent paused
+			if timer != nil {
+				r.mu.Lock()
+				waitUntil = r.waitUntil
+				r.mu.Unlock()
+				timer.Reset(time.Until(waitUntil))
+			}
+		case <-r.observed:
+		}
+	}
+}
+
+// eventObservedLocked returns true if the WAITFOR action's stage has been added, or CONFIRM was sent since the
+// action started
+func (r *Replay) eventObservedLocked(action ReplayAction) bool {
+	if action.confirm != "" {
+		return r.confirms > r.currentConfirm
+	}
+	_, ok := r.stages[action.waitStage]
+	return ok
+}
+
+// sleepUntil waits until the time unless the wait is skipped or the replay is stopped. If the replay is paused,
+// it waits until it is resumed and uses the new time from Resume. It returns the time that it waited until, and
+// false if the replay is stopped
+func (r *Replay) sleepUntil(ctx context.Context, waitUntil time.Time) (time.Time, bool) {
+	timer := time.NewTimer(time.Until(waitUntil))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return waitUntil, false
+		case <-r.stop:
+			return waitUntil, false
+		case <-r.skip:
+			return waitUntil, true
+		case <-timer.C:
+			return waitUntil, true
+		case <-r.pause:
+			if !r.waitForResume(ctx) {
+				return waitUntil, false
+			}
+			r.mu.Lock()
+			waitUntil = r.waitUntil
+			r.mu.Unlock()
+			timer.Reset(time.Until(waitUntil))
+		}
 	}
 }
+
+// runRamp sends the RAMP's starting level and then changes it by one level at a time, evenly spaced so the last
+// level is sent when the duration has passed. Skipping sends the next level immediately
+func (r *Replay) runRamp(ctx context.Context, writer io.Writer, action ReplayAction) error {
+	steps := action.rampSteps()
+	interval := action.rampOver / time.Duration(steps)
+	next := time.Now()
+
+	for step := 0; step <= steps; step++ {
+		if step > 0 {
+			next = next.Add(interval)
+			r.mu.Lock()
+			r.waitUntil = next
+			r.mu.Unlock()
+			r.emit()
+
+			// pausing moves the rest of the ramp later
+			var ok bool
+			next, ok = r.sleepUntil(ctx, next)
+			if !ok {
+				return nil
+			}
+		}
+
+		command := action.rampCommand(step)
+		if _, err := fmt.Fprintln(writer, command); err != nil {
+			return fmt.Errorf("send replay command from line %d: %w", action.line, err)
+		}
+		r.ObserveCommand(command, time.Now())
+
+		r.mu.Lock()
+		r.rampStep = step
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	r.waitUntil = time.Time{}
+	r.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/calvinmclean/autoroast"
+	"github.com/calvinmclean/autoroast/firmware/commands"
+)
+
+// ReplayIssue is a problem found in a replay file by CheckReplay
+type ReplayIssue struct {
+	// File is the fragment that the issue is in. It is empty for issues in the replay file
+	File string
+	// Line is zero for issues with the whole file
+	Line    int
+	Message string
+	// Warning is true for things that are suspicious but still run. Other issues are commands that the
+	// firmware rejects
+	Warning bool
+}
+
+func (i ReplayIssue) String() string {
+	level := "error"
+	if i.Warning {
+		level = "warning"
+	}
+	if i.Line == 0 {
+		return fmt.Sprintf("%s: %s", level, i.Message)
+	}
+	if i.File != "" {
+		return fmt.Sprintf("%s: line %d: %s: %s", i.File, i.Line, level, i.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", i.Line, level, i.Message)
+}
+
+// CheckReplay validates the commands in the replay against the firmware's commands and warns about steps that
+// probably aren't intended, like changing the fan before starting the roaster or sending commands after DONE
+func CheckReplay(actions []ReplayAction) []ReplayIssue {
+	var issues []ReplayIssue
+	warn := func(action ReplayAction, format string, args ...any) {
+		issues = append(issues, ReplayIssue{
+			File:    action.file,
+			Line:    action.line,
+			Message: fmt.Sprintf(format, args...),
+			Warning: true,
+		})
+	}
+
+	var started, cooled, firstCrack bool
+	var done *ReplayAction
+	var afterDone bool
+	for _, action := range actions {
+		if done != nil && !afterDone {
+			warn(action, "%s after DONE on %s is not added to TWChart", action, done.location())
+			// only the first step after DONE is reported
+			afterDone = true
+		}
+
+		switch {
+		case action.ramp != 0 && !started:
+			warn(action, "%s before S changes the roaster before it is started", action)
+		case action.anchor == ReplayAnchorStart && !started:
+			warn(action, "%s before S waits until the roaster is started manually", action)
+		case action.anchor == ReplayAnchorFirstCrack && !firstCrack && !hasFirstCrack(actions):
+			warn(action, "%s waits for first crack, but FC is never sent", action)
+		}
+		if action.command == "" {
+			continue
+		}
+
+		if !isExternalCommand(action.command) {
+			err := checkFirmwareCommand(action.command)
+			if err != nil {
+				issues = append(issues, ReplayIssue{File: action.file, Line: action.line, Message: err.Error()})
+				continue
+			}
+			if !started && (action.command[0] == 'F' || action.command[0] == 'P') {
+				warn(action, "%s before S changes the roaster before it is started", action.command)
+			}
+		}
+
+		if action.command == "DONE" && done == nil {
+			done = &action
+		}
+		if anchorForCommand(action.command) == ReplayAnchorStart {
+			started = true
+		}
+		switch stageForCommand(action.command) {
+		case "Cooling":
+			cooled = true
+		case "First Crack":
+			firstCrack = true
+		}
+	}
+
+	if !cooled {
+		warn(ReplayAction{}, "COOL is never sent, so the beans won't be cooled")
+	}
+	return issues
+}
+
+// location is the line of the action, and the fragment if it was included from one
+func (a ReplayAction) location() string {
+	if a.file != "" {
+		return fmt.Sprintf("%s line %d", a.file, a.line)
+	}
+	return fmt.Sprintf("line %d", a.line)
+}
+
+// hasFirstCrack returns true if the actions send FC or wait for it to be marked
+func hasFirstCrack(actions []ReplayAction) bool {
+	return slices.ContainsFunc(actions, func(a ReplayAction) bool {
+		return a.waitStage == "First Crack" || stageForCommand(a.command) == "First Crack"
+	})
+}
+
+// checkFirmwareCommand returns an error if the firmware can't run every command in the line
+func checkFirmwareCommand(line string) error {
+	in := []byte(line)
+	for i := 0; i < len(in); {
+		flag := in[i]
+		i++
+
+		switch flag {
+		case ' ', '\t':
+			continue
+		}
+
+		cmd, ok := commands.Lookup(flag)
+		if !ok {
+			return fmt.Errorf("unknown command %q in %q", flag, line)
+		}
+
+		end := i + int(cmd.InputSize)
+		if end > len(in) {
+			return fmt.Errorf("missing input for command %q in %q", flag, line)
+		}
+		err := checkFirmwareInput(flag, in[i:end])
+		if err != nil {
+			return fmt.Errorf("invalid input for command %q in %q: %w", flag, line, err)
+		}
+		i = end
+	}
+	return nil
+}
+
+// checkFirmwareInput checks the input for commands that reject or ignore invalid input
+func checkFirmwareInput(flag byte, input []byte) error {
+	isLevel := func(b byte) bool {
+		return b >= '1' && b <= '9'
+	}
+
+	switch flag {
+	case 'F', 'P':
+		if input[0] != '-' && input[0] != '+' && !isLevel(input[0]) {
+			return fmt.Errorf("%q must be '-', '+', or 1-9", input)
+		}
+	case 'f', 'p':
+		if !isLevel(input[0]) {
+			return fmt.Errorf("%q must be 1-9", input)
+		}
+	case 'I':
+		if !isLevel(input[0]) || !isLevel(input[1]) {
+			return fmt.Errorf("%q must be a fan and power of 1-9", input)
+		}
+	case 'M':
+		if !strings.ContainsRune("FPT", rune(input[0])) {
+			return fmt.Errorf("%q must be F, P, or T", input)
+		}
+	case 'b':
+		if input[0] < '0' || input[0] > '9' {
+			return fmt.Errorf("%q must be 0-9", input)
+		}
+	case 's':
+		if (input[0] != '-' && input[0] != '+') || !isLevel(input[1]) {
+			return fmt.Errorf("%q must be '+' or '-' and 1-9", input)
+		}
+	case 'K':
+		if !slices.Contains(autoroast.CalibrationFields, autoroast.CalibrationField(input[0])) {
+			return fmt.Errorf("unknown calibration field %q", input[0])
+		}
+		_, err := autoroast.ParseCalibrationValue(input[1:])
+		return err
+	}
+	return nil
+}
+
+// ReplayTimelineEntry is a step in a replay with when it runs
+type ReplayTimelineEntry struct {
+	// Offset is how long after the replay starts that the step runs
+	Offset time.Duration
+	// File is the fragment that the step was included from. It is empty for steps in the replay file
+	File string
+	Line int
+	Text string
+	// AfterEvent is true if the step is after a WAITFOR or AT FC that waits for something to happen, so Offset
+	// is the earliest that it can run
+	AfterEvent bool
+}
+
+// ReplayTimeline simulates the replay without waiting to find when each step runs. RAMPs are shown as each of
+// the commands that they send. It also returns the total duration
+func ReplayTimeline(actions []ReplayAction) ([]ReplayTimelineEntry, time.Duration) {
+	var entries []ReplayTimelineEntry
+	var offset time.Duration
+	var afterEvent bool
+	anchors := map[ReplayAnchor]time.Duration{}
+	stages := map[string]bool{}
+	add := func(action ReplayAction, text string, at time.Duration) {
+		entries = append(entries, ReplayTimelineEntry{
+			Offset:     at,
+			File:       action.file,
+			Line:       action.line,
+			Text:       text,
+			AfterEvent: afterEvent,
+		})
+	}
+
+	for _, action := range actions {
+		switch {
+		case action.ramp != 0:
+			interval := action.rampOver / time.Duration(action.rampSteps())
+			for step := 0; step <= action.rampSteps(); step++ {
+				add(action, action.rampCommand(step), offset+time.Duration(step)*interval)
+			}
+			offset += action.rampOver
+		case action.wait > 0:
+			add(action, action.String(), offset)
+			offset += action.wait
+		case action.anchor != ReplayAnchorNone:
+			add(action, action.String(), offset)
+			anchor, ok := anchors[action.anchor]
+			if !ok {
+				// the anchor happens manually at some point after this
+				afterEvent = true
+				anchors[action.anchor] = offset
+				anchor = offset
+			}
+			offset = max(offset, anchor+action.at)
+		case action.waitsForEvent():
+			add(action, action.String(), offset)
+			if action.confirm != "" || !stages[action.waitStage] {
+				afterEvent = true
+			}
+			if action.waitStage == "First Crack" {
+				if _, ok := anchors[ReplayAnchorFirstCrack]; !ok {
+					anchors[ReplayAnchorFirstCrack] = offset
+				}
+			}
+		default:
+			add(action, action.String(), offset)
+			if anchor := anchorForCommand(action.command); anchor != ReplayAnchorNone {
+				if _, ok := anchors[anchor]; !ok {
+					anchors[anchor] = offset
+				}
+			}
+			if stage := stageForCommand(action.command); stage != "" {
+				stages[stage] = true
+			}
+		}
+	}
+
+	return entries, offset
+}
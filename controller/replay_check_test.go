@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckReplay(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			// controller commands are case-insensitive, so fc adds first crack like FC
+			name:  "Valid",
+			input: "I55\nS\nPREHEAT\nF5 P6\nF+\nMT\nROASTING\nNOTE smells good\nfc\nCOOL\nDONE",
+		},
+		{
+			name:  "InvalidCommands",
+			input: "S\nF0\nP\nX\nI5\nMX\nK b30.000\nKq30.000\nbx\nCOOL",
+			want: []string{
+				`line 2: error: invalid input for command 'F' in "F0": "0" must be '-', '+', or 1-9`,
+				`line 3: error: missing input for command 'P' in "P"`,
+				`line 4: error: unknown command 'X' in "X"`,
+				`line 5: error: missing input for command 'I' in "I5"`,
+				`line 6: error: invalid input for command 'M' in "MX": "X" must be F, P, or T`,
+				`line 7: error: invalid input for command 'K' in "K b30.000": unknown calibration field ' '`,
+				`line 8: error: invalid input for command 'K' in "Kq30.000": unknown calibration field 'q'`,
+				`line 9: error: invalid input for command 'b' in "bx": "x" must be 0-9`,
+			},
+		},
+		{
+			name:  "Suspicious",
+			input: "F5\nRAMP P 4..6 OVER 1m\nAT 1m\nAT FC+1m\nS\nFC\nDONE\nF9\nP9",
+			want: []string{
+				"line 1: warning: F5 before S changes the roaster before it is started",
+				"line 2: warning: RAMP P 4..6 OVER 1m0s before S changes the roaster before it is started",
+				"line 3: warning: AT 1m0s before S waits until the roaster is started manually",
+				"line 8: warning: F9 after DONE on line 7 is not added to TWChart",
+				"warning: COOL is never sent, so the beans won't be cooled",
+			},
+		},
+		{
+			name:  "FirstCrackNeverSent",
+			input: "S\nAT FC+1m\nCOOL",
+			want:  []string{"line 2: warning: AT FC+1m0s waits for first crack, but FC is never sent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, err := ParseReplay(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("ParseReplay() error = %v", err)
+			}
+
+			var got []string
+			for _, issue := range CheckReplay(actions) {
+				got = append(got, issue.String())
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("CheckReplay() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCheckReplayExamples(t *testing.T) {
+	actions, err := LoadReplay(filepath.Join("testdata", "classic.roast"))
+	if err != nil {
+		t.Fatalf("LoadReplay() error = %v", err)
+	}
+	if issues := CheckReplay(actions); len(issues) != 0 {
+		t.Errorf("CheckReplay() = %v, want no issues", issues)
+	}
+}
+
+func TestReplayTimeline(t *testing.T) {
+	input := `S
+WAIT 1m
+RAMP P 4..6 OVER 1m
+AT 3m
+F5
+WAIT 30s
+WAITFOR FC
+AT FC+1m
+COOL`
+	actions, err := ParseReplay(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseReplay() error = %v", err)
+	}
+
+	entries, total := ReplayTimeline(actions)
+	want := []ReplayTimelineEntry{
+		{Offset: 0, Line: 1, Text: "S"},
+		{Offset: 0, Line: 2, Text: "WAIT 1m0s"},
+		{Offset: time.Minute, Line: 3, Text: "P4"},
+		{Offset: 90 * time.Second, Line: 3, Text: "P5"},
+		{Offset: 2 * time.Minute, Line: 3, Text: "P6"},
+		{Offset: 2 * time.Minute, Line: 4, Text: "AT 3m0s"},
+		{Offset: 3 * time.Minute, Line: 5, Text: "F5"},
+		{Offset: 3 * time.Minute, Line: 6, Text: "WAIT 30s"},
+		{Offset: 210 * time.Second, Line: 7, Text: "WAITFOR FC"},
+		{Offset: 210 * time.Second, Line: 8, Text: "AT FC+1m0s", AfterEvent: true},
+		{Offset: 270 * time.Second, Line: 9, Text: "COOL", AfterEvent: true},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("entries = %+v, want %+v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+	if total != 270*time.Second {
+		t.Errorf("total = %s, want 4m30s", total)
+	}
+}
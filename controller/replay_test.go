@@ -3,8 +3,13 @@ package controller
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -97,6 +102,166 @@ func TestLoadReplayInvalidWait(t *testing.T) {
 	}
 }
 
+func TestLoadReplayWithRepeat(t *testing.T) {
+	actions, err := LoadReplay(filepath.Join("testdata", "repeat.roast"))
+	if err != nil {
+		t.Fatalf("LoadReplay() error = %v", err)
+	}
+
+	var got []string
+	for _, action := range actions {
+		got = append(got, action.String())
+	}
+	want := []string{
+		"S", "F6", "P6",
+		"F5", "WAIT 20s", "F4", "WAIT 20s",
+		"F5", "WAIT 20s", "F4", "WAIT 20s",
+		"F5", "WAIT 20s", "F4", "WAIT 20s",
+		"P7", "WAIT 15s", "WAIT 15s", "P8", "WAIT 30s",
+		"P7", "WAIT 15s", "WAIT 15s", "P8", "WAIT 30s",
+		"DONE",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("actions = %v, want %v", got, want)
+	}
+
+	// repeated actions keep the line they were written on
+	if actions[7].line != 6 || actions[21].line != 15 {
+		t.Errorf("lines = %d and %d, want 6 and 15", actions[7].line, actions[21].line)
+	}
+
+	replay := NewReplay(actions, func(ReplayState) {}, nil)
+	if got := len(replay.State().Queued); got != len(want) {
+		t.Errorf("queued = %d, want %d expanded actions", got, len(want))
+	}
+}
+
+func TestParseReplayInvalidRepeat(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"MissingCount", "S\nREPEAT\nF5\nEND", "line 2: REPEAT requires exactly one count"},
+		{"InvalidCount", "REPEAT 0\nF5\nEND", "line 1: invalid REPEAT count"},
+		{"NotANumber", "REPEAT two\nF5\nEND", "line 1: invalid REPEAT count"},
+		{"MissingEnd", "S\nREPEAT 2\n  F5\n  REPEAT 2\n    F4\n  END", "line 2: REPEAT without END"},
+		{"EndWithoutRepeat", "S\n\nEND", "line 3: END without REPEAT"},
+		{"EndWithArguments", "REPEAT 2\nF5\nEND REPEAT", "line 3: END does not take any arguments"},
+		{"InvalidActionInBlock", "S\nREPEAT 2\n  F5\n  WAIT nope\nEND", "line 4: invalid WAIT duration"},
+		{"TooManyActions", "REPEAT 1000\nREPEAT 1000\nF5\nEND\nEND", "line 1: REPEAT expands to more than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseReplay(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseReplay() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadReplayWithInclude(t *testing.T) {
+	actions, err := LoadReplay(filepath.Join("testdata", "include.roast"))
+	if err != nil {
+		t.Fatalf("LoadReplay() error = %v", err)
+	}
+	want := []string{"F7", "P9", "S", "PREHEAT", "WAIT 30s", "ROASTING", "P7", "WAITFOR FC", "COOL", "WAIT 3m0s", "DONE"}
+	assertReplayActions(t, actions, want)
+
+	// included actions keep the fragment and line they were written on
+	preheat := filepath.Join("testdata", "fragments", "preheat.roast")
+	if actions[0].file != preheat || actions[0].line != 2 || actions[5].file != "" || actions[5].line != 5 {
+		t.Errorf("locations = %s and %s, want %s line 2 and line 5", actions[0].location(), actions[5].location(), preheat)
+	}
+
+	issues := CheckReplay(actions)
+	if len(issues) == 0 || issues[0].String() != preheat+": line 2: warning: F7 before S changes the roaster before it is started" {
+		t.Errorf("CheckReplay() = %v, want a warning in the fragment", issues)
+	}
+}
+
+func TestLoadReplayInvalidInclude(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("error writing %s: %v", name, err)
+		}
+		return path
+	}
+	write("bad.roast", "F5\nWAIT soon\n")
+	write("nested.roast", "S\nINCLUDE bad.roast\n")
+	write("set.roast", "SET fan = 5\n")
+
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"MissingFile", "INCLUDE", "line 1: INCLUDE requires a file"},
+		{"NotFound", "S\nINCLUDE missing.roast", "line 2: INCLUDE missing.roast: open replay file:"},
+		{"InvalidFragment", "INCLUDE bad.roast", `line 1: INCLUDE bad.roast: line 2: invalid WAIT duration "soon"`},
+		{"Nested", "F5\nINCLUDE nested.roast", `line 2: INCLUDE nested.roast: line 2: INCLUDE bad.roast: line 2: invalid WAIT duration "soon"`},
+		{"Self", "INCLUDE main.roast", "line 1: INCLUDE main.roast: cycle main.roast -> main.roast"},
+		{"AlreadySet", "INCLUDE set.roast\nSET fan = 6", "line 2: fan is already set in " + filepath.Join(dir, "set.roast") + " on line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := write("main.roast", tt.input)
+			_, err := LoadReplay(path)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("LoadReplay() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	t.Run("Cycle", func(t *testing.T) {
+		_, err := LoadReplay(filepath.Join("testdata", "fragments", "cycle_a.roast"))
+		want := "line 2: INCLUDE cycle_b.roast: line 1: INCLUDE cycle_a.roast: cycle cycle_a.roast -> cycle_b.roast -> cycle_a.roast"
+		if err == nil || err.Error() != want {
+			t.Errorf("LoadReplay() error = %v, want %q", err, want)
+		}
+	})
+
+	t.Run("IncludedTwice", func(t *testing.T) {
+		path := write("twice.roast", "INCLUDE bad.roast\n")
+		write("bad.roast", "F5\n")
+		write("main.roast", "INCLUDE twice.roast\nINCLUDE twice.roast\n")
+		actions, err := LoadReplay(filepath.Join(dir, "main.roast"))
+		if err != nil || len(actions) != 2 {
+			t.Errorf("LoadReplay() = %v, %v, want F5 twice from %s", actions, err, path)
+		}
+	})
+
+	t.Run("SetIncludedTwice", func(t *testing.T) {
+		write("set.roast", "SET fan = 5\nF${fan}\n")
+		write("main.roast", "INCLUDE set.roast\nINCLUDE set.roast\n")
+		actions, err := LoadReplay(filepath.Join(dir, "main.roast"))
+		if err != nil {
+			t.Fatalf("LoadReplay() error = %v", err)
+		}
+		assertReplayActions(t, actions, []string{"F5", "F5"})
+	})
+
+	t.Run("WithoutReplayFile", func(t *testing.T) {
+		want := "line 2: INCLUDE bad.roast: relative paths can only be included from a replay file"
+		_, err := ParseReplay(strings.NewReader("S\nINCLUDE bad.roast"))
+		if err == nil || err.Error() != want {
+			t.Errorf("ParseReplay() error = %v, want %q", err, want)
+		}
+
+		write("bad.roast", "F5\n")
+		actions, err := ParseReplay(strings.NewReader("INCLUDE " + filepath.Join(dir, "bad.roast")))
+		if err != nil {
+			t.Fatalf("ParseReplay() error = %v", err)
+		}
+		assertReplayActions(t, actions, []string{"F5"})
+	})
+}
+
 func TestRunReplayWritesCommandsInOrder(t *testing.T) {
 	actions := []ReplayAction{
 		{line: 1, command: "S"},
@@ -190,6 +355,152 @@ func TestReplayAddAndMoveQueued(t *testing.T) {
 	}
 }
 
+func TestReplayInsertQueued(t *testing.T) {
+	replay := NewReplay([]ReplayAction{
+		{line: 1, command: "F5"},
+		{line: 2, command: "P5"},
+	}, func(ReplayState) {}, nil)
+
+	if err := replay.InsertQueued(1, "WAIT 30s\n# comments are ignored\nREPEAT 2\n  P6\nEND"); err != nil {
+		t.Fatalf("InsertQueued() error = %v", err)
+	}
+	if err := replay.InsertQueued(0, "S"); err != nil {
+		t.Fatalf("InsertQueued() error = %v", err)
+	}
+	if err := replay.InsertQueued(6, "COOL"); err != nil {
+		t.Fatalf("InsertQueued() error = %v", err)
+	}
+
+	var got []string
+	ids := map[int]bool{}
+	for _, item := range replay.State().Queued {
+		got = append(got, item.Text)
+		ids[item.ID] = true
+	}
+	if want := []string{"S", "F5", "WAIT 30s", "P6", "P6", "P5", "COOL"}; !equalStrings(got, want) {
+		t.Errorf("queued = %q, want %q", got, want)
+	}
+	if len(ids) != len(got) {
+		t.Errorf("IDs = %v, want a unique ID for each action", ids)
+	}
+
+	for _, tt := range []struct {
+		index int
+		input string
+		err   string
+	}{
+		{-1, "F5", "invalid queue position -1"},
+		{8, "F5", "invalid queue position 8"},
+		{0, "# nothing", "no actions to queue"},
+		{0, "F5\nWAIT never", `line 2: invalid WAIT duration "never"`},
+	} {
+		if err := replay.InsertQueued(tt.index, tt.input); err == nil || err.Error() != tt.err {
+			t.Errorf("InsertQueued(%d, %q) error = %v, want %q", tt.index, tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestReplayInsertQueuedAfter(t *testing.T) {
+	replay := NewReplay([]ReplayAction{
+		{line: 1, command: "F5"},
+		{line: 2, command: "P5"},
+	}, func(ReplayState) {}, nil)
+	queued := replay.State().Queued
+
+	// the queue changes after it was shown, but the actions are still inserted after the same one
+	if err := replay.InsertQueued(0, "S"); err != nil {
+		t.Fatalf("InsertQueued() error = %v", err)
+	}
+	if err := replay.InsertQueuedAfter(queued[0].ID, "WAIT 30s\nP6"); err != nil {
+		t.Fatalf("InsertQueuedAfter() error = %v", err)
+	}
+
+	var got []string
+	for _, item := range replay.State().Queued {
+		got = append(got, item.Text)
+	}
+	if want := []string{"S", "F5", "WAIT 30s", "P6", "P5"}; !equalStrings(got, want) {
+		t.Errorf("queued = %q, want %q", got, want)
+	}
+
+	if !replay.RemoveQueued(queued[1].ID) {
+		t.Fatal("RemoveQueued() = false, want true")
+	}
+	want := fmt.Sprintf("action %d is not queued", queued[1].ID)
+	if err := replay.InsertQueuedAfter(queued[1].ID, "F6"); err == nil || err.Error() != want {
+		t.Errorf("InsertQueuedAfter() error = %v, want %q", err, want)
+	}
+	if err := replay.InsertQueuedAfter(queued[0].ID, "WAIT never"); err == nil {
+		t.Error("InsertQueuedAfter() error = nil for invalid input, want error")
+	}
+}
+
+func TestReplayDoesNotReuseRemovedIDs(t *testing.T) {
+	replay := NewReplay([]ReplayAction{
+		{line: 1, command: "F5"},
+		{line: 2, command: "P5"},
+	}, func(ReplayState) {}, nil)
+	removed := replay.State().Queued[1].ID
+
+	// a dialog that is still open on the removed action must not change the one added after it
+	if !replay.RemoveQueued(removed) {
+		t.Fatal("RemoveQueued() = false, want true")
+	}
+	if err := replay.AddQueued("P6"); err != nil {
+		t.Fatalf("AddQueued() error = %v", err)
+	}
+	if got := replay.State().Queued[1].ID; got == removed {
+		t.Errorf("added ID = %d, want a new ID", got)
+	}
+	if err := replay.UpdateQueued(removed, "P7"); err == nil {
+		t.Error("UpdateQueued() error = nil for a removed action, want error")
+	}
+	if err := replay.InsertQueuedAfter(removed, "P7"); err == nil {
+		t.Error("InsertQueuedAfter() error = nil for a removed action, want error")
+	}
+}
+
+func TestReplayUpdateQueued(t *testing.T) {
+	states := make(chan ReplayState, 8)
+	replay := NewReplay([]ReplayAction{
+		{line: 1, wait: time.Hour},
+		{line: 2, command: "F5"},
+		{line: 3, command: "P5"},
+	}, func(state ReplayState) { states <- state }, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+
+	go func() { done <- replay.Run(ctx, &bytes.Buffer{}) }()
+	<-states // initial queue
+	<-states // active wait
+
+	if err := replay.UpdateQueued(1, "F6"); err != nil {
+		t.Fatalf("UpdateQueued() error = %v", err)
+	}
+	if err := replay.UpdateQueued(2, "P6\nWAIT 30s"); err != nil {
+		t.Fatalf("UpdateQueued() error = %v", err)
+	}
+	state := replay.State()
+	if len(state.Queued) != 3 || state.Queued[0] != (ReplayQueuedAction{ID: 1, Text: "F6"}) ||
+		state.Queued[1] != (ReplayQueuedAction{ID: 2, Text: "P6"}) || state.Queued[2].Text != "WAIT 30s" {
+		t.Errorf("queued = %v, want F6, P6, and WAIT 30s with the updated IDs kept", state.Queued)
+	}
+
+	if err := replay.UpdateQueued(0, "F4"); err == nil {
+		t.Error("UpdateQueued() error = nil for the current action, want error")
+	}
+	if err := replay.UpdateQueued(1, "WAIT never"); err == nil {
+		t.Error("UpdateQueued() error = nil for invalid input, want error")
+	}
+	if got := replay.State().Queued[0].Text; got != "F6" {
+		t.Errorf("action = %q after invalid update, want F6", got)
+	}
+
+	cancel()
+	<-done
+}
+
 func TestReplayMoveQueuedTo(t *testing.T) {
 	replay := NewReplay([]ReplayAction{
 		{line: 1, command: "F5"},
@@ -370,3 +681,659 @@ func TestReplayAlertBlocksUntilDismissed(t *testing.T) {
 		t.Errorf("output = %q, want %q", got, want)
 	}
 }
+
+func TestReplayFailStopsWait(t *testing.T) {
+	var output bytes.Buffer
+	states := make(chan ReplayState, 4)
+	replay := NewReplay([]ReplayAction{
+		{line: 1, wait: time.Hour},
+		{line: 2, command: "F5"},
+	}, func(state ReplayState) { states <- state }, nil)
+	done := make(chan error, 1)
+
+	go func() { done <- replay.Run(context.Background(), &output) }()
+	<-states // initial queue
+	<-states // active wait
+	if !replay.Fail(errors.New("firmware error")) {
+		t.Fatal("Fail() = false, want true")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	final := <-states
+	if !final.Cancelled || final.Running || final.Error != "firmware error" {
+		t.Errorf("final state = %#v, want cancelled state with error", final)
+	}
+	if got := output.String(); got != "" {
+		t.Errorf("output = %q, want no commands after failure", got)
+	}
+	if replay.Fail(errors.New("again")) {
+		t.Error("Fail() = true after replay stopped, want false")
+	}
+}
+
+func TestParseReplayAt(t *testing.T) {
+	tests := []struct {
+		input  string
+		anchor ReplayAnchor
+		at     time.Duration
+		text   string
+	}{
+		{"AT 3m30s", ReplayAnchorStart, 3*time.Minute + 30*time.Second, "AT 3m30s"},
+		{"at 0s", ReplayAnchorStart, 0, "AT 0s"},
+		{"AT FC", ReplayAnchorFirstCrack, 0, "AT FC"},
+		{"AT fc+1m", ReplayAnchorFirstCrack, time.Minute, "AT FC+1m0s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			actions, err := ParseReplay(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("ParseReplay() error = %v", err)
+			}
+			if actions[0].anchor != tt.anchor || actions[0].at != tt.at {
+				t.Errorf("action = %+v, want anchor %d at %s", actions[0], tt.anchor, tt.at)
+			}
+			if got := actions[0].String(); got != tt.text {
+				t.Errorf("String() = %q, want %q", got, tt.text)
+			}
+		})
+	}
+}
+
+func TestParseReplayInvalidAt(t *testing.T) {
+	for _, input := range []string{"AT", "AT 1m 2m", "AT -1m", "AT nope", "AT PH+1m", "AT FC+", "AT FC+-1m"} {
+		t.Run(input, func(t *testing.T) {
+			_, err := ParseReplay(strings.NewReader("S\n" + input))
+			if err == nil || !strings.Contains(err.Error(), "line 2") {
+				t.Errorf("ParseReplay() error = %v, want line-numbered error", err)
+			}
+		})
+	}
+}
+
+func TestAnchorForCommand(t *testing.T) {
+	for command, want := range map[string]ReplayAnchor{
+		"S":       ReplayAnchorStart,
+		" I55S ":  ReplayAnchorStart,
+		"F5 S":    ReplayAnchorStart,
+		"s+1":     ReplayAnchorNone,
+		"FC":      ReplayAnchorFirstCrack,
+		"CRACK":   ReplayAnchorFirstCrack,
+		" fc ":    ReplayAnchorFirstCrack,
+		"Crack":   ReplayAnchorFirstCrack,
+		"ph":      ReplayAnchorNone,
+		"F5":      ReplayAnchorNone,
+		"NOTE S":  ReplayAnchorNone,
+		"PREHEAT": ReplayAnchorNone,
+	} {
+		if got := anchorForCommand(command); got != want {
+			t.Errorf("anchorForCommand(%q) = %d, want %d", command, got, want)
+		}
+	}
+}
+
+func TestStageForCommand(t *testing.T) {
+	for command, want := range map[string]string{
+		"PH":       StagePreheat,
+		"preheat":  StagePreheat,
+		" Roast ":  StageRoasting,
+		"ROASTING": StageRoasting,
+		"crack":    StageFirstCrack,
+		"COOL":     StageCooling,
+		"DONE":     "",
+		"F5":       "",
+	} {
+		if got := stageForCommand(command); got != want {
+			t.Errorf("stageForCommand(%q) = %q, want %q", command, got, want)
+		}
+	}
+}
+
+func TestReplayAtSchedulesFromStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	states := make(chan ReplayState, 8)
+	replay := NewReplay([]ReplayAction{
+		{line: 1, command: "S"},
+		{line: 2, anchor: ReplayAnchorStart, at: time.Hour},
+		{line: 3, command: "F5"},
+	}, func(state ReplayState) { states <- state }, nil)
+	done := make(chan error, 1)
+
+	start := time.Now()
+	go func() { done <- replay.Run(ctx, &bytes.Buffer{}) }()
+	<-states // initial queue
+	<-states // active command S
+	active := <-states
+	if active.Current != "AT 1h0m0s" || !active.Waiting {
+		t.Fatalf("state = %#v, want waiting for AT", active)
+	}
+	if want := start.Add(time.Hour); active.WaitUntil.Before(want) || active.WaitUntil.After(want.Add(time.Second)) {
+		t.Errorf("WaitUntil = %v, want one hour after start", active.WaitUntil)
+	}
+	cancel()
+	<-done
+}
+
+func TestReplayAtAlreadyPassed(t *testing.T) {
+	var output bytes.Buffer
+	replay := NewReplay([]ReplayAction{
+		{line: 1, anchor: ReplayAnchorStart, at: 30 * time.Minute},
+		{line: 2, command: "F5"},
+	}, func(ReplayState) {}, nil)
+
+	// the roast started an hour ago, so the AT has already passed and doesn't add any delay
+	replay.ObserveCommand("S", time.Now().Add(-time.Hour))
+	// the first start is kept if the roaster is started again
+	replay.ObserveCommand("S", time.Now())
+
+	if err := replay.Run(context.Background(), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := output.String(); got != "F5\n" {
+		t.Errorf("output = %q, want %q", got, "F5\n")
+	}
+}
+
+func TestReplayAtWaitsForFirstCrack(t *testing.T) {
+	var output bytes.Buffer
+	states := make(chan ReplayState, 8)
+	replay := NewReplay([]ReplayAction{
+		{line: 1, anchor: ReplayAnchorFirstCrack, at: time.Millisecond},
+		{line: 2, command: "F6"},
+	}, func(state ReplayState) { states <- state }, nil)
+	done := make(chan error, 1)
+
+	go func() { done <- replay.Run(context.Background(), &output) }()
+	<-states // initial queue
+	active := <-states
+	if active.Current != "AT FC+1ms" || !active.Waiting || !active.WaitUntil.IsZero() {
+		t.Fatalf("state = %#v, want waiting for first crack", active)
+	}
+
+	replay.ObserveCommand("F5", time.Now())
+	replay.ObserveCommand("FC", time.Now())
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := output.String(); got != "F6\n" {
+		t.Errorf("output = %q, want %q", got, "F6\n")
+	}
+}
+
+func TestReplayResumeSession(t *testing.T) {
+	var output bytes.Buffer
+	actions, err := ParseReplay(strings.NewReader("AT 30m\nF5\nWAITFOR STAGE Roasting\nAT FC+1m\nCOOL"))
+	if err != nil {
+		t.Fatalf("ParseReplay() error = %v", err)
+	}
+	replay := NewReplay(actions, func(ReplayState) {}, nil)
+
+	// the resumed roast has passed all of the anchors and stages, so nothing waits
+	start := time.Now().Add(-time.Hour)
+	replay.ResumeSession(SessionState{
+		StartTime: start,
+		Stages: []SessionStage{
+			{Name: StageRoasting, Start: start.Add(5 * time.Minute)},
+			{Name: StageFirstCrack, Start: start.Add(10 * time.Minute)},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := replay.Run(ctx, &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := output.String(); got != "F5\nCOOL\n" {
+		t.Errorf("output = %q, want %q", got, "F5\nCOOL\n")
+	}
+}
+
+func TestReplaySkipAtWaitingForAnchor(t *testing.T) {
+	var output bytes.Buffer
+	states := make(chan ReplayState, 8)
+	replay := NewReplay([]ReplayAction{
+		{line: 1, anchor: ReplayAnchorFirstCrack, at: time.Minute},
+		{line: 2, command: "COOL"},
+	}, func(state ReplayState) { states <- state }, nil)
+	done := make(chan error, 1)
+
+	go func() { done <- replay.Run(context.Background(), &output) }()
+	<-states // initial queue
+	<-states // waiting for first crack
+	if !replay.Skip() {
+		t.Fatal("Skip() = false, want true")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := output.String(); got != "COOL\n" {
+		t.Errorf("output = %q, want %q", got, "COOL\n")
+	}
+}
+
+func TestParseReplayWaitFor(t *testing.T) {
+	tests := []struct {
+		input   string
+		stage   string
+		confirm string
+		timeout time.Duration
+		text    string
+	}{
+		{input: "WAITFOR FC", stage: "First Crack", text: "WAITFOR FC"},
+		{input: "waitfor crack timeout 12m", stage: "First Crack", timeout: 12 * time.Minute, text: "WAITFOR FC TIMEOUT 12m0s"},
+		{input: "WAITFOR STAGE Roasting", stage: "Roasting", text: "WAITFOR STAGE Roasting"},
+		{input: "WAITFOR STAGE first crack TIMEOUT 1m", stage: "First Crack", timeout: time.Minute, text: "WAITFOR FC TIMEOUT 1m0s"},
+		{input: `WAITFOR CONFIRM "load the beans"`, confirm: "load the beans", text: `WAITFOR CONFIRM "load the beans"`},
+		{input: `WAITFOR CONFIRM "say \"TIMEOUT 1m\"" TIMEOUT 30s`, confirm: `say "TIMEOUT 1m"`, timeout: 30 * time.Second, text: `WAITFOR CONFIRM "say \"TIMEOUT 1m\"" TIMEOUT 30s`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			actions, err := ParseReplay(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("ParseReplay() error = %v", err)
+			}
+			action := actions[0]
+			if action.waitStage != tt.stage || action.confirm != tt.confirm || action.timeout != tt.timeout {
+				t.Errorf("action = %+v, want stage %q, confirm %q, timeout %s", action, tt.stage, tt.confirm, tt.timeout)
+			}
+			if got := action.String(); got != tt.text {
+				t.Errorf("String() = %q, want %q", got, tt.text)
+			}
+		})
+	}
+}
+
+func TestParseReplayInvalidWaitFor(t *testing.T) {
+	for _, input := range []string{
+		"WAITFOR",
+		"WAITFOR BEANS",
+		"WAITFOR STAGE",
+		"WAITFOR STAGE Drying",
+		"WAITFOR CONFIRM",
+		"WAITFOR CONFIRM load beans",
+		`WAITFOR CONFIRM ""`,
+		"WAITFOR FC TIMEOUT",
+		"WAITFOR FC TIMEOUT 0s",
+		"WAITFOR FC 10m",
+		`WAITFOR CONFIRM "ok" extra`,
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := ParseReplay(strings.NewReader("S\n" + input))
+			if err == nil || !strings.Contains(err.Error(), "line 2") {
+				t.Errorf("ParseReplay() error = %v, want line-numbered error", err)
+			}
+		})
+	}
+}
+
+func TestReplayWaitForStage(t *testing.T) {
+	var output bytes.Buffer
+	states := make(chan ReplayState, 8)
+	replay := NewReplay([]ReplayAction{
+		{line: 1, waitStage: "Roasting"},
+		{line: 2, command: "F6"},
+	}, func(state ReplayState) { states <- state }, nil)
+	done := make(chan error, 1)
+
+	// stages that were already added don't need to be sent again
+	replay.ObserveCommand("PREHEAT", time.Now())
+
+	go func() { done <- replay.Run(context.Background(), &output) }()
+	<-states // initial queue
+	active := <-states
+	if active.Current != "WAITFOR STAGE Roasting" || !active.Waiting || !active.WaitUntil.IsZero() {
+		t.Fatalf("state = %#v, want waiting for Roasting", active)
+	}
+
+	replay.ObserveCommand("F5", time.Now())
+	replay.ObserveCommand("ROAST", time.Now())
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := output.String(); got != "F6\n" {
+		t.Errorf("output = %q, want %q", got, "F6\n")
+	}
+}
+
+func TestReplayWaitForStageAlreadyAdded(t *testing.T) {
+	var output bytes.Buffer
+	replay := NewReplay([]ReplayAction{
+		{line: 1, command: "FC"},
+		{line: 2, waitStage: "First Crack"},
+		{line: 3, command: "COOL"},
+	}, func(ReplayState) {}, nil)
+
+	if err := replay.Run(context.Background(), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := output.String(); got != "FC\nCOOL\n" {
+		t.Errorf("output = %q, want %q", got, "FC\nCOOL\n")
+	}
+}
+
+func TestReplayWaitForConfirm(t *testing.T) {
+	var output bytes.Buffer
+	states := make(chan ReplayState, 8)
+	replay := NewReplay([]ReplayAction{
+		{line: 1, confirm: "load the beans"},
+		{line: 2, command: "S"},
+	}, func(state ReplayState) { states <- state }, nil)
+	done := make(chan error, 1)
+
+	// confirmations from before the action started are ignored
+	replay.ObserveCommand("CONFIRM", time.Now())
+
+	go func() { done <- replay.Run(context.Background(), &output) }()
+	<-states // initial queue
+	active := <-states
+	if active.Confirm != "load the beans" || !active.Waiting {
+		t.Fatalf("state = %#v, want waiting for confirmation", active)
+	}
+	select {
+	case <-done:
+		t.Fatal("Run() returned before CONFIRM was sent")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	replay.ObserveCommand(" confirm ", time.Now())
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := output.String(); got != "S\n" {
+		t.Errorf("output = %q, want %q", got, "S\n")
+	}
+	if final := replay.State(); final.Confirm != "" {
+		t.Errorf("Confirm = %q after finishing, want empty", final.Confirm)
+	}
+}
+
+func TestReplayWaitForTimeout(t *testing.T) {
+	var output bytes.Buffer
+	states := make(chan ReplayState, 8)
+	replay := NewReplay([]ReplayAction{
+		{line: 1, waitStage: "First Crack", timeout: 10 * time.Millisecond},
+		{line: 2, command: "COOL"},
+	}, func(state ReplayState) { states <- state }, nil)
+
+	start := time.Now()
+	if err := replay.Run(context.Background(), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	<-states // initial queue
+	if active := <-states; active.WaitUntil.IsZero() {
+		t.Errorf("WaitUntil is zero, want the timeout")
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("finished after %s, want to wait for the timeout", elapsed)
+	}
+	if got := output.String(); got != "COOL\n" {
+		t.Errorf("output = %q, want %q", got, "COOL\n")
+	}
+}
+
+func TestParseReplayRamp(t *testing.T) {
+	actions, err := ParseReplay(strings.NewReader("RAMP P 4..8 OVER 2m\nramp f 6..3 over 30s"))
+	if err != nil {
+		t.Fatalf("ParseReplay() error = %v", err)
+	}
+	if len(actions) != 2 {
+		t.Fatalf("len(actions) = %d, want a single action for each RAMP", len(actions))
+	}
+	if got, want := actions[0].String(), "RAMP P 4..8 OVER 2m0s"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got, want := actions[1].String(), "RAMP F 6..3 OVER 30s"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestParseReplayInvalidRamp(t *testing.T) {
+	for _, input := range []string{
+		"RAMP",
+		"RAMP P 4..8",
+		"RAMP P 4..8 FOR 2m",
+		"RAMP T 4..8 OVER 2m",
+		"RAMP P 4-8 OVER 2m",
+		"RAMP P 0..8 OVER 2m",
+		"RAMP P 4..10 OVER 2m",
+		"RAMP P 4..4 OVER 2m",
+		"RAMP P 4..8 OVER 0s",
+		"RAMP P 4..8 OVER soon",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := ParseReplay(strings.NewReader("S\n" + input))
+			if err == nil || !strings.Contains(err.Error(), "line 2") {
+				t.Errorf("ParseReplay() error = %v, want line-numbered error", err)
+			}
+		})
+	}
+}
+
+func TestReplayRamp(t *testing.T) {
+	var output bytes.Buffer
+	var states []ReplayState
+	replay := NewReplay([]ReplayAction{
+		{line: 1, ramp: 'P', rampFrom: 8, rampTo: 5, rampOver: 30 * time.Millisecond},
+		{line: 2, command: "F4"},
+	}, func(state ReplayState) { states = append(states, state) }, nil)
+
+	if got := replay.State().Queued; len(got) != 2 || got[0].Text != "RAMP P 8..5 OVER 30ms" {
+		t.Fatalf("queued = %v, want a single RAMP item", got)
+	}
+
+	start := time.Now()
+	if err := replay.Run(context.Background(), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("finished after %s, want the ramp to take 30ms", elapsed)
+	}
+	if got, want := output.String(), "P8\nP7\nP6\nP5\nF4\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	var progress []float64
+	for _, state := range states {
+		if state.Ramping != (state.Current == "RAMP P 8..5 OVER 30ms") {
+			t.Errorf("state = %+v, want Ramping only for the RAMP action", state)
+		}
+		if state.Ramping {
+			progress = append(progress, state.Progress)
+		}
+	}
+	if len(progress) != 4 || progress[0] != 0 || progress[3] != 2.0/3 {
+		t.Errorf("progress = %v, want [0 0 1/3 2/3]", progress)
+	}
+}
+
+func TestReplaySkipRampStep(t *testing.T) {
+	var output bytes.Buffer
+	states := make(chan ReplayState, 8)
+	replay := NewReplay([]ReplayAction{
+		{line: 1, ramp: 'F', rampFrom: 4, rampTo: 5, rampOver: time.Hour},
+	}, func(state ReplayState) { states <- state }, nil)
+	done := make(chan error, 1)
+
+	go func() { done <- replay.Run(context.Background(), &output) }()
+	<-states // initial queue
+	<-states // ramp started
+	waiting := <-states
+	if !waiting.Waiting || waiting.WaitUntil.IsZero() {
+		t.Fatalf("state = %#v, want waiting for the next step", waiting)
+	}
+	if !replay.Skip() {
+		t.Fatal("Skip() = false, want true")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got, want := output.String(), "F4\nF5\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestReplayFailStopsRamp(t *testing.T) {
+	var output bytes.Buffer
+	states := make(chan ReplayState, 8)
+	replay := NewReplay([]ReplayAction{
+		{line: 1, ramp: 'P', rampFrom: 4, rampTo: 8, rampOver: time.Hour},
+		{line: 2, command: "F4"},
+	}, func(state ReplayState) { states <- state }, nil)
+	done := make(chan error, 1)
+
+	go func() { done <- replay.Run(context.Background(), &output) }()
+	<-states // initial queue
+	<-states // ramp started
+	<-states // waiting for the next step
+	if !replay.Fail(errors.New("invalid input")) {
+		t.Fatal("Fail() = false, want true")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got, want := output.String(), "P4\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if final := replay.State(); !final.Cancelled || final.Error != "invalid input" {
+		t.Errorf("final state = %#v, want stopped with error", final)
+	}
+}
+
+func TestReplayPauseKeepsRemainingWait(t *testing.T) {
+	var output syncBuffer
+	states := make(chan ReplayState, 8)
+	replay := NewReplay([]ReplayAction{
+		{line: 1, wait: 50 * time.Millisecond},
+		{line: 2, command: "F5"},
+	}, func(state ReplayState) { states <- state }, nil)
+	done := make(chan error, 1)
+
+	go func() { done <- replay.Run(context.Background(), &output) }()
+	<-states // initial queue
+	<-states // active wait
+	if !replay.Pause() {
+		t.Fatal("Pause() = false, want true")
+	}
+	paused := <-states
+	if !paused.Paused || !paused.WaitUntil.IsZero() || paused.Remaining <= 0 || paused.Remaining > 50*time.Millisecond {
+		t.Fatalf("state = %#v, want paused with the remaining wait", paused)
+	}
+	if replay.Pause() {
+		t.Error("Pause() = true when already paused, want false")
+	}
+	if replay.Skip() {
+		t.Error("Skip() = true while paused, want false")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if got := output.String(); got != "" {
+		t.Fatalf("output = %q while paused, want nothing", got)
+	}
+
+	resumed := time.Now()
+	if !replay.Resume() {
+		t.Fatal("Resume() = false, want true")
+	}
+	if state := <-states; state.Paused || state.WaitUntil.Before(resumed.Add(paused.Remaining)) {
+		t.Errorf("state = %#v, want the wait to continue with its remaining time", state)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if elapsed := time.Since(resumed); elapsed < paused.Remaining {
+		t.Errorf("finished %s after resuming, want at least %s", elapsed, paused.Remaining)
+	}
+	if got := output.String(); got != "F5\n" {
+		t.Errorf("output = %q, want %q", got, "F5\n")
+	}
+}
+
+func TestReplayPauseBetweenActions(t *testing.T) {
+	if replay := NewReplay([]ReplayAction{{line: 1, command: "S"}}, func(ReplayState) {}, nil); replay.Pause() {
+		t.Error("Pause() = true when not running, want false")
+	}
+
+	var output syncBuffer
+	var replay *Replay
+	paused := make(chan struct{})
+	var pausing atomic.Bool
+	replay = NewReplay([]ReplayAction{
+		{line: 1, command: "S"},
+		{line: 2, command: "F5"},
+	}, func(state ReplayState) {
+		// pause as soon as S starts so F5 is held in the queue
+		if state.Current == "S" && pausing.CompareAndSwap(false, true) {
+			replay.Pause()
+			close(paused)
+		}
+	}, nil)
+	done := make(chan error, 1)
+
+	go func() { done <- replay.Run(context.Background(), &output) }()
+	<-paused
+	time.Sleep(20 * time.Millisecond)
+	if got := output.String(); got != "S\n" {
+		t.Fatalf("output = %q while paused, want only S", got)
+	}
+	if state := replay.State(); !state.Paused || len(state.Queued) != 1 {
+		t.Errorf("state = %#v, want paused with F5 queued", state)
+	}
+
+	if !replay.Resume() {
+		t.Fatal("Resume() = false, want true")
+	}
+	if replay.Resume() {
+		t.Error("Resume() = true when not paused, want false")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := output.String(); got != "S\nF5\n" {
+		t.Errorf("output = %q, want %q", got, "S\nF5\n")
+	}
+}
+
+func TestReplayCancelWhilePaused(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	states := make(chan ReplayState, 8)
+	replay := NewReplay([]ReplayAction{
+		{line: 1, wait: time.Hour},
+		{line: 2, command: "F5"},
+	}, func(state ReplayState) { states <- state }, nil)
+	done := make(chan error, 1)
+
+	go func() { done <- replay.Run(ctx, &bytes.Buffer{}) }()
+	<-states // initial queue
+	<-states // active wait
+	replay.Pause()
+	<-states // paused
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if final := <-states; !final.Cancelled || final.Paused {
+		t.Errorf("final state = %#v, want cancelled and not paused", final)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be read while the replay is writing to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ReplayVar is a variable declared with SET in a replay file
+type ReplayVar struct {
+	Name string
+	// Value is the value in the file, before it is overridden
+	Value string
+	// File is the fragment that declares the variable. It is empty for variables in the replay file
+	File string
+	Line int
+}
+
+// ParseReplayVar parses a "name=value" override for a replay variable, like the -var flag
+func ParseReplayVar(input string) (string, string, error) {
+	name, value, ok := strings.Cut(input, "=")
+	if !ok {
+		return "", "", fmt.Errorf("invalid variable %q: expected name=value", input)
+	}
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+	if !validReplayVarName(name) {
+		return "", "", fmt.Errorf("invalid variable name %q", name)
+	}
+	if value == "" {
+		return "", "", fmt.Errorf("missing value for variable %q", name)
+	}
+	return name, value, nil
+}
+
+// LoadReplayVars returns the variables declared in a replay file with their values from the file
+func LoadReplayVars(path string) ([]ReplayVar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open replay file: %w", err)
+	}
+	defer f.Close()
+
+	p := newReplayParser(nil)
+	if _, err := p.parse(f, path, ""); err != nil {
+		return nil, err
+	}
+	return p.vars.declared, nil
+}
+
+// replayVars keeps the values of variables while a replay file is parsed. Overrides replace the value from the
+// file when the variable is declared
+type replayVars struct {
+	overrides map[string]string
+	values    map[string]string
+	declared  []ReplayVar
+}
+
+func newReplayVars(overrides map[string]string) *replayVars {
+	return &replayVars{overrides: overrides, values: map[string]string{}}
+}
+
+// set parses a SET declaration, like "SET charge = 250", after the SET
+func (v *replayVars) set(file string, lineNumber int, input string) error {
+	name, value, ok := strings.Cut(input, "=")
+	if !ok {
+		return errors.New("SET requires a name = value")
+	}
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+	if !validReplayVarName(name) {
+		return fmt.Errorf("invalid variable name %q", name)
+	}
+	if value == "" {
+		return fmt.Errorf("missing value for %s", name)
+	}
+
+	value, err := v.substitute(value)
+	if err != nil {
+		return err
+	}
+
+	if i := slices.IndexFunc(v.declared, func(d ReplayVar) bool { return d.Name == name }); i >= 0 {
+		declared := v.declared[i]
+		// a fragment that is included more than once sets its variables again, which is fine if nothing changed
+		if file != "" && declared.File == file && declared.Line == lineNumber && declared.Value == value {
+			return nil
+		}
+		if declared.File != "" {
+			return fmt.Errorf("%s is already set in %s on line %d", name, declared.File, declared.Line)
+		}
+		return fmt.Errorf("%s is already set on line %d", name, declared.Line)
+	}
+	v.declared = append(v.declared, ReplayVar{Name: name, Value: value, File: file, Line: lineNumber})
+
+	if override, ok := v.overrides[name]; ok {
+		value = override
+	}
+	v.values[name] = value
+	return nil
+}
+
+// substitute replaces each ${name} with the variable's value
+func (v *replayVars) substitute(input string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(input, "${")
+		if start < 0 {
+			b.WriteString(input)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(input[start:], '}')
+		if end < 0 {
+			return "", errors.New("missing } after ${")
+		}
+
+		name := input[start+2 : start+end]
+		value, ok := v.values[name]
+		if !ok {
+			return "", fmt.Errorf("undefined variable %q", name)
+		}
+		b.WriteString(input[:start])
+		b.WriteString(value)
+		input = input[start+end+1:]
+	}
+}
+
+// checkOverrides returns an error for overrides that aren't declared in the file, since they are probably typos
+func (v *replayVars) checkOverrides() error {
+	return CheckReplayVarOverrides(v.declared, v.overrides)
+}
+
+// CheckReplayVarOverrides returns an error for overrides that aren't in the declared variables, since they are
+// probably typos
+func CheckReplayVarOverrides(declared []ReplayVar, overrides map[string]string) error {
+	var unknown []string
+	for name := range overrides {
+		if !slices.ContainsFunc(declared, func(v ReplayVar) bool { return v.Name == name }) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	slices.Sort(unknown)
+	return fmt.Errorf("unknown variable %s: it is not SET in the replay file", strings.Join(unknown, ", "))
+}
+
+func validReplayVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadReplayWithVars(t *testing.T) {
+	path := filepath.Join("testdata", "vars.roast")
+
+	t.Run("Defaults", func(t *testing.T) {
+		actions, err := LoadReplay(path)
+		if err != nil {
+			t.Fatalf("LoadReplay() error = %v", err)
+		}
+		want := []string{"S", "F6", "WAIT 45s", "ROASTING", "RAMP P 5..8 OVER 2m0s", "WAITFOR FC", "NOTE first crack at P8", "COOL"}
+		assertReplayActions(t, actions, want)
+		if actions[1].line != 8 {
+			t.Errorf("line = %d, want 8", actions[1].line)
+		}
+	})
+
+	t.Run("Overrides", func(t *testing.T) {
+		actions, err := LoadReplayWithVars(path, map[string]string{"preheat": "1m", "power": "9"})
+		if err != nil {
+			t.Fatalf("LoadReplayWithVars() error = %v", err)
+		}
+		if actions[2].wait != time.Minute {
+			t.Errorf("wait = %s, want 1m", actions[2].wait)
+		}
+		// high_power is set from power, so it uses the override too
+		if actions[4].rampTo != 9 || actions[6].command != "NOTE first crack at P9" {
+			t.Errorf("actions = %v, want the power override", actions)
+		}
+	})
+
+	t.Run("InvalidOverride", func(t *testing.T) {
+		_, err := LoadReplayWithVars(path, map[string]string{"preheat": "soon"})
+		if err == nil || err.Error() != `line 9: invalid WAIT duration "soon"` {
+			t.Errorf("LoadReplayWithVars() error = %v, want invalid WAIT on line 9", err)
+		}
+	})
+
+	t.Run("UnknownOverride", func(t *testing.T) {
+		_, err := LoadReplayWithVars(path, map[string]string{"powr": "9"})
+		if err == nil || err.Error() != "unknown variable powr: it is not SET in the replay file" {
+			t.Errorf("LoadReplayWithVars() error = %v, want unknown variable", err)
+		}
+	})
+}
+
+func TestLoadReplayVars(t *testing.T) {
+	vars, err := LoadReplayVars(filepath.Join("testdata", "vars.roast"))
+	if err != nil {
+		t.Fatalf("LoadReplayVars() error = %v", err)
+	}
+	want := []ReplayVar{
+		{Name: "preheat", Value: "45s", Line: 2},
+		{Name: "fan", Value: "6", Line: 3},
+		{Name: "power", Value: "8", Line: 4},
+		{Name: "high_power", Value: "8", Line: 5},
+	}
+	if len(vars) != len(want) {
+		t.Fatalf("vars = %v, want %v", vars, want)
+	}
+	for i := range want {
+		if vars[i] != want[i] {
+			t.Errorf("var %d = %v, want %v", i, vars[i], want[i])
+		}
+	}
+
+	if err := CheckReplayVarOverrides(vars, map[string]string{"fan": "7"}); err != nil {
+		t.Errorf("CheckReplayVarOverrides() error = %v", err)
+	}
+	err = CheckReplayVarOverrides(vars, map[string]string{"fan": "7", "pwer": "9", "chrage": "1m"})
+	if err == nil || err.Error() != "unknown variable chrage, pwer: it is not SET in the replay file" {
+		t.Errorf("CheckReplayVarOverrides() error = %v, want unknown chrage and pwer", err)
+	}
+}
+
+func TestParseReplayInvalidVars(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "MissingEquals", input: "SET fan 5", want: "line 1: SET requires a name = value"},
+		{name: "MissingValue", input: "SET fan =", want: "line 1: missing value for fan"},
+		{name: "InvalidName", input: "SET 1fan = 5", want: `line 1: invalid variable name "1fan"`},
+		{name: "AlreadySet", input: "SET fan = 5\nSET fan = 6", want: "line 2: fan is already set on line 1"},
+		{name: "Undefined", input: "F${fan}", want: `line 1: undefined variable "fan"`},
+		{name: "UsedBeforeSet", input: "F${fan}\nSET fan = 5", want: `line 1: undefined variable "fan"`},
+		{name: "Unclosed", input: "SET fan = 5\nF${fan", want: "line 2: missing } after ${"},
+		{name: "InvalidDuration", input: "SET t = soon\nWAIT ${t}", want: `line 2: invalid WAIT duration "soon"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseReplay(strings.NewReader(tt.input))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("ParseReplay() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReplayVar(t *testing.T) {
+	name, value, err := ParseReplayVar(" charge = 250g ")
+	if err != nil || name != "charge" || value != "250g" {
+		t.Errorf("ParseReplayVar() = %q, %q, %v, want charge, 250g", name, value, err)
+	}
+
+	for _, input := range []string{"charge", "=250", "charge=", "char-ge=250"} {
+		if _, _, err := ParseReplayVar(input); err == nil {
+			t.Errorf("ParseReplayVar(%q) error = nil, want error", input)
+		}
+	}
+}
+
+func assertReplayActions(t *testing.T, actions []ReplayAction, want []string) {
+	t.Helper()
+
+	var got []string
+	for _, action := range actions {
+		got = append(got, action.String())
+	}
+	if !equalStrings(got, want) {
+		t.Errorf("actions = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/calvinmclean/autoroast/twchart"
+)
+
+// AmbientProbe is the name of the probe that a RoastCurve uses for the ambient temperature
+const AmbientProbe = "Ambient"
+
+// CurveMarkerKind is what a RoastCurve's marker shows
+type CurveMarkerKind int
+
+const (
+	// CurveMarkerStage is a stage like Preheat or First Crack, or Done
+	CurveMarkerStage CurveMarkerKind = iota
+	// CurveMarkerSetting is a fan or power change like F5
+	CurveMarkerSetting
+	CurveMarkerNote
+)
+
+// CurvePoint is a value on a RoastCurve
+type CurvePoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// CurveMarker is something that happened during the roast, labeled with the stage, command, or note
+type CurveMarker struct {
+	Time  time.Time
+	Kind  CurveMarkerKind
+	Label string
+}
+
+// RoastCurve is the temperatures, rate of rise, and events of a roast so they can be charted. A live roast's curve
+// is built from its probe readings and commands, and a previous roast's is read from its roast log. It is not safe
+// for concurrent use
+type RoastCurve struct {
+	Name string
+	// Start is when the roast was started with S. It is zero if the roast hasn't been started
+	Start time.Time
+	// Bean and Ambient are temperatures in degrees Fahrenheit
+	Bean    []CurvePoint
+	Ambient []CurvePoint
+	// RoR is the bean temperature's rate of rise in degrees Fahrenheit per minute
+	RoR     []CurvePoint
+	Markers []CurveMarker
+
+	probes    twchart.Probes
+	beanProbe string
+	// rateOfRise calculates the rate of rise from the bean temperatures. It is nil for a live roast, where the
+	// Controller's rate of rise is added with AddRoR
+	rateOfRise *RateOfRise
+}
+
+// NewRoastCurve creates an empty curve. Readings from the probe named beanProbe are the bean temperature and
+// readings from AmbientProbe are the ambient temperature
+func NewRoastCurve(name string, probes twchart.Probes, beanProbe string) *RoastCurve {
+	return &RoastCurve{
+		Name:      name,
+		probes:    probes,
+		beanProbe: beanProbe,
+	}
+}
+
+// AddReading adds a reading from the bean or ambient probe. Readings from other probes are ignored
+func (c *RoastCurve) AddReading(reading ProbeReading) {
+	for _, probe := range c.probes {
+		if probe.Position != reading.Position {
+			continue
+		}
+		switch {
+		case strings.EqualFold(probe.Name, c.beanProbe):
+			c.AddBean(reading.Time, reading.Temperature)
+		case strings.EqualFold(probe.Name, AmbientProbe):
+			c.Ambient = append(c.Ambient, CurvePoint{Time: reading.Time, Value: reading.Temperature})
+		}
+		return
+	}
+}
+
+// AddBean adds a bean temperature. A curve from a roast log also adds the rate of rise once there are enough
+// readings to calculate it
+func (c *RoastCurve) AddBean(t time.Time, temperature float64) {
+	c.Bean = append(c.Bean, CurvePoint{Time: t, Value: temperature})
+
+	if c.rateOfRise == nil {
+		return
+	}
+	c.rateOfRise.Add(t, temperature)
+	if ror, ok := c.rateOfRise.Value(); ok {
+		c.AddRoR(t, ror)
+	}
+}
+
+// AddRoR adds a rate of rise, like the one from Controller.RateOfRise
+func (c *RoastCurve) AddRoR(t time.Time, ror float64) {
+	c.RoR = append(c.RoR, CurvePoint{Time: t, Value: ror})
+}
+
+// AddData adds a row of probe temperatures like the ones sent to TWChart. Probes without a reading are -1
+func (c *RoastCurve) AddData(data twchart.Data) {
+	for i, temperature := range data.ProbeData {
+		if temperature <= 0 {
+			continue
+		}
+		c.AddReading(ProbeReading{
+			Position:    twchart.ProbePosition(i + 1),
+			Time:        data.Time,
+			Temperature: temperature,
+		})
+	}
+}
+
+// AddMarker adds a marker at t
+func (c *RoastCurve) AddMarker(kind CurveMarkerKind, t time.Time, label string) {
+	c.Markers = append(c.Markers, CurveMarker{Time: t, Kind: kind, Label: label})
+}
+
+// AddCommand adds a marker for commands that change the roast: fan and power settings, stages, DONE, and notes.
+// S sets the Start if the roast hasn't already been started
+func (c *RoastCurve) AddCommand(command string, t time.Time) {
+	command = strings.TrimSpace(command)
+
+	switch {
+	case command == "S":
+		if c.Start.IsZero() {
+			c.Start = t
+		}
+	case isSettingCommand(command), isRelativeSettingCommand(command):
+		c.AddMarker(CurveMarkerSetting, t, command)
+	case stageForCommand(command) != "":
+		c.AddMarker(CurveMarkerStage, t, stageForCommand(command))
+	case command == "DONE":
+		c.AddMarker(CurveMarkerStage, t, "Done")
+	case strings.HasPrefix(command, "NOTE "):
+		if note := strings.TrimSpace(strings.TrimPrefix(command, "NOTE ")); note != "" {
+			c.AddMarker(CurveMarkerNote, t, note)
+		}
+	}
+}
+
+// Origin returns the time that the curve is charted from. It is the Start, or the first reading or marker if the
+// roast hasn't been started. It is zero if the curve is empty
+func (c *RoastCurve) Origin() time.Time {
+	if !c.Start.IsZero() {
+		return c.Start
+	}
+
+	var origin time.Time
+	earliest := func(t time.Time) {
+		if origin.IsZero() || t.Before(origin) {
+			origin = t
+		}
+	}
+	for _, points := range [][]CurvePoint{c.Bean, c.Ambient} {
+		if len(points) > 0 {
+			earliest(points[0].Time)
+		}
+	}
+	for _, marker := range c.Markers {
+		earliest(marker.Time)
+	}
+	return origin
+}
+
+// LoadRoastCurve reads a roast log file so a previous roast can be charted
+func LoadRoastCurve(path, beanProbe string, rorWindow time.Duration) (*RoastCurve, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open roast log: %w", err)
+	}
+	defer f.Close()
+
+	entries, err := ReadRoastLog(f)
+	if err != nil {
+		return nil, err
+	}
+	return RoastCurveFromLog(entries, beanProbe, rorWindow), nil
+}
+
+// RoastCurveFromLog uses the probe temperatures, stages, and events from a roast log. Notes added by the
+// controller when the serial connection is lost are skipped
+func RoastCurveFromLog(entries []RoastLogEntry, beanProbe string, rorWindow time.Duration) *RoastCurve {
+	c := NewRoastCurve("", nil, beanProbe)
+	c.rateOfRise = NewRateOfRise(rorWindow)
+	for _, entry := range entries {
+		switch entry.Type {
+		case RoastLogSession:
+			c.Name = entry.Name
+			c.probes = entry.Probes
+		case RoastLogStart:
+			if c.Start.IsZero() {
+				c.Start = entry.Time
+			}
+		case RoastLogStage:
+			c.AddMarker(CurveMarkerStage, entry.Time, entry.Name)
+		case RoastLogEvent:
+			command := settingEventCommand(entry.Name)
+			switch {
+			case entry.Name == "First Crack":
+				c.AddMarker(CurveMarkerStage, entry.Time, entry.Name)
+			case isSettingCommand(command), isRelativeSettingCommand(command):
+				c.AddMarker(CurveMarkerSetting, entry.Time, command)
+			case strings.HasPrefix(entry.Name, "Serial connection "):
+			default:
+				c.AddMarker(CurveMarkerNote, entry.Time, entry.Name)
+			}
+		case RoastLogData:
+			for _, data := range entry.Data {
+				c.AddData(data)
+			}
+		case RoastLogDone:
+			c.AddMarker(CurveMarkerStage, entry.Time, "Done")
+		}
+	}
+	return c
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/calvinmclean/autoroast/twchart"
+)
+
+func TestRoastCurveFromLog(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time {
+		return start.Add(d)
+	}
+
+	var data []twchart.Data
+	for s := 0; s <= 60; s += 2 {
+		data = append(data, twchart.Data{
+			Time:      at(time.Duration(s) * time.Second),
+			ProbeData: []float64{75, 200 + float64(s)/4, 500},
+		})
+	}
+	// the bean probe is missing in the last row
+	data = append(data, twchart.Data{Time: at(62 * time.Second), ProbeData: []float64{76, -1}})
+
+	probes := twchart.Probes{{Name: "Ambient", Position: 1}, {Name: "Beans", Position: 2}, {Name: "Exhaust", Position: 3}}
+	entries := []RoastLogEntry{
+		{Time: at(-time.Minute), Type: RoastLogSession, Name: "Ethiopia Guji", Probes: probes},
+		{Time: at(0), Type: RoastLogStart},
+		{Time: at(0), Type: RoastLogStage, Name: "Preheat"},
+		{Time: at(0), Type: RoastLogData, Data: data[:10]},
+		{Time: at(20 * time.Second), Type: RoastLogEvent, Name: "F6 (RoR 15.0°F/min)"},
+		{Time: at(20 * time.Second), Type: RoastLogCommand, Name: "F6", Status: "OK"},
+		{Time: at(30 * time.Second), Type: RoastLogEvent, Name: "Serial connection lost"},
+		{Time: at(40 * time.Second), Type: RoastLogEvent, Name: "smells like bread"},
+		{Time: at(45 * time.Second), Type: RoastLogEvent, Name: "P-"},
+		{Time: at(45 * time.Second), Type: RoastLogCommand, Name: "P-", Status: "OK"},
+		{Time: at(50 * time.Second), Type: RoastLogData, Data: data[10:]},
+		{Time: at(55 * time.Second), Type: RoastLogEvent, Name: "First Crack"},
+		{Time: at(70 * time.Second), Type: RoastLogDone},
+	}
+
+	curve := RoastCurveFromLog(entries, "beans", 30*time.Second)
+	if curve.Name != "Ethiopia Guji" || !curve.Start.Equal(start) || !curve.Origin().Equal(start) {
+		t.Errorf("curve = %q started at %s, want Ethiopia Guji started at %s", curve.Name, curve.Start, start)
+	}
+	if len(curve.Bean) != 31 || len(curve.Ambient) != 32 {
+		t.Errorf("got %d bean and %d ambient temperatures, want 31 and 32", len(curve.Bean), len(curve.Ambient))
+	}
+	if len(curve.RoR) == 0 || math.Abs(curve.RoR[len(curve.RoR)-1].Value-15) > 0.001 {
+		t.Errorf("RoR = %+v, want it to end at 15", curve.RoR)
+	}
+
+	var labels []string
+	for _, marker := range curve.Markers {
+		labels = append(labels, marker.Label)
+	}
+	want := []string{"Preheat", "F6", "smells like bread", "P-", "First Crack", "Done"}
+	if !slices.Equal(labels, want) {
+		t.Errorf("markers = %v, want %v", labels, want)
+	}
+	kinds := []CurveMarkerKind{CurveMarkerStage, CurveMarkerSetting, CurveMarkerNote, CurveMarkerSetting, CurveMarkerStage, CurveMarkerStage}
+	for i, marker := range curve.Markers {
+		if i < len(kinds) && marker.Kind != kinds[i] {
+			t.Errorf("marker %q kind = %d, want %d", marker.Label, marker.Kind, kinds[i])
+		}
+	}
+}
+
+func TestLoadRoastCurve(t *testing.T) {
+	log := newRoastLog(t.TempDir())
+	if _, err := log.CreateSession(context.Background(), "Colombia", twchart.Probes{{Name: "Beans", Position: 1}}); err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	now := time.Now()
+	if err := log.AddData(context.Background(), []twchart.Data{{Time: now, ProbeData: []float64{250}}}); err != nil {
+		t.Fatalf("AddData() error = %v", err)
+	}
+	path := log.Path()
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	curve, err := LoadRoastCurve(path, "Beans", 0)
+	if err != nil {
+		t.Fatalf("LoadRoastCurve() error = %v", err)
+	}
+	if curve.Name != "Colombia" || len(curve.Bean) != 1 || curve.Bean[0].Value != 250 {
+		t.Errorf("curve = %+v, want Colombia with one bean temperature", curve)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.jsonl")
+	if err := os.WriteFile(bad, []byte("not json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRoastCurve(bad, "Beans", 0); err == nil {
+		t.Error("LoadRoastCurve() error = nil, want error for invalid log")
+	}
+}
+
+func TestRoastCurveAddCommand(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	curve := NewRoastCurve("live", nil, "Beans")
+
+	if !curve.Origin().IsZero() {
+		t.Errorf("Origin() = %s, want zero for an empty curve", curve.Origin())
+	}
+	curve.AddCommand("NOTE  ", start)
+	curve.AddCommand("C", start)
+	curve.AddCommand("F5", start.Add(time.Second))
+	if !curve.Origin().Equal(start.Add(time.Second)) {
+		t.Errorf("Origin() = %s, want the first marker before starting", curve.Origin())
+	}
+
+	curve.AddCommand("S", start.Add(2*time.Second))
+	curve.AddCommand("PH", start.Add(2*time.Second))
+	curve.AddCommand("P7", start.Add(time.Minute))
+	curve.AddCommand("F+", start.Add(90*time.Second))
+	curve.AddCommand("NOTE first pops", start.Add(2*time.Minute))
+	curve.AddCommand("S", start.Add(3*time.Minute))
+	curve.AddCommand("CRACK", start.Add(4*time.Minute))
+	curve.AddCommand("DONE", start.Add(5*time.Minute))
+
+	if !curve.Origin().Equal(start.Add(2 * time.Second)) {
+		t.Errorf("Origin() = %s, want the first S", curve.Origin())
+	}
+	want := []CurveMarker{
+		{Time: start.Add(time.Second), Kind: CurveMarkerSetting, Label: "F5"},
+		{Time: start.Add(2 * time.Second), Kind: CurveMarkerStage, Label: "Preheat"},
+		{Time: start.Add(time.Minute), Kind: CurveMarkerSetting, Label: "P7"},
+		{Time: start.Add(90 * time.Second), Kind: CurveMarkerSetting, Label: "F+"},
+		{Time: start.Add(2 * time.Minute), Kind: CurveMarkerNote, Label: "first pops"},
+		{Time: start.Add(4 * time.Minute), Kind: CurveMarkerStage, Label: "First Crack"},
+		{Time: start.Add(5 * time.Minute), Kind: CurveMarkerStage, Label: "Done"},
+	}
+	if !slices.Equal(curve.Markers, want) {
+		t.Errorf("markers = %+v, want %+v", curve.Markers, want)
+	}
+}
+
+func TestRoastCurveAddReading(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	probes := twchart.Probes{{Name: "Ambient", Position: 1}, {Name: "Roaster", Position: 2}}
+	curve := NewRoastCurve("live", probes, "roaster")
+
+	curve.AddReading(ProbeReading{Position: 1, Time: start, Temperature: 70})
+	curve.AddReading(ProbeReading{Position: 2, Time: start, Temperature: 300})
+	curve.AddReading(ProbeReading{Position: 3, Time: start, Temperature: 500})
+
+	if len(curve.Ambient) != 1 || curve.Ambient[0].Value != 70 {
+		t.Errorf("Ambient = %+v, want 70", curve.Ambient)
+	}
+	if len(curve.Bean) != 1 || curve.Bean[0].Value != 300 {
+		t.Errorf("Bean = %+v, want 300 from the Roaster probe", curve.Bean)
+	}
+	if len(curve.RoR) != 0 {
+		t.Errorf("RoR = %+v, want none from one reading", curve.RoR)
+	}
+
+	// a live curve charts the Controller's rate of rise instead of calculating its own
+	curve.AddReading(ProbeReading{Position: 2, Time: start.Add(time.Minute), Temperature: 320})
+	if len(curve.RoR) != 0 {
+		t.Errorf("RoR = %+v, want none without AddRoR", curve.RoR)
+	}
+	curve.AddRoR(start.Add(time.Minute), 18)
+	want := []CurvePoint{{Time: start.Add(time.Minute), Value: 18}}
+	if !slices.Equal(curve.RoR, want) {
+		t.Errorf("RoR = %+v, want %+v", curve.RoR, want)
+	}
+}
@@ -0,0 +1,279 @@
+package controller
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/calvinmclean/autoroast/twchart"
+)
+
+// DefaultRoastLogDir is where roast logs are written
+const DefaultRoastLogDir = "roast_logs"
+
+// errRoastLogUnavailable is returned when a session's roast log can't be opened. The roast log is optional, so the
+// roast continues without it
+var errRoastLogUnavailable = errors.New("roast log is unavailable")
+
+// RoastLogEntryType is the kind of record in a roast log
+type RoastLogEntryType string
+
+const (
+	RoastLogSession RoastLogEntryType = "session"
+	RoastLogResume  RoastLogEntryType = "resume"
+	RoastLogStart   RoastLogEntryType = "start"
+	RoastLogEvent   RoastLogEntryType = "event"
+	RoastLogStage   RoastLogEntryType = "stage"
+	RoastLogCommand RoastLogEntryType = "command"
+	RoastLogData    RoastLogEntryType = "data"
+	RoastLogDone    RoastLogEntryType = "done"
+)
+
+// RoastLogEntry is one line of a roast log
+type RoastLogEntry struct {
+	Time time.Time
+	Type RoastLogEntryType
+	// Name is the session name, note, stage name, or command depending on the Type
+	Name      string         `json:",omitempty"`
+	SessionID string         `json:",omitempty"`
+	Probes    twchart.Probes `json:",omitempty"`
+	// Data is the probe temperatures sent to TWChart
+	Data []twchart.Data `json:",omitempty"`
+
+	// Status, Output, Payload, and Error are the firmware's response to a command
+	Status  string `json:",omitempty"`
+	Output  string `json:",omitempty"`
+	Payload string `json:",omitempty"`
+	Error   string `json:",omitempty"`
+}
+
+// ReadRoastLog parses all entries from a roast log
+func ReadRoastLog(r io.Reader) ([]RoastLogEntry, error) {
+	var entries []RoastLogEntry
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var entry RoastLogEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading roast log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// commandRecorder is implemented by twchartClients that also keep every command sent to the firmware
+type commandRecorder interface {
+	RecordCommand(now time.Time, result CommandResult, err error)
+}
+
+// roastLog is a twchartClient that writes everything that happens in a roast to a JSON-lines file, one
+// file per session. Each entry is written immediately so the log is complete if the app crashes. Nothing is
+// written if the session's file couldn't be opened
+type roastLog struct {
+	dir string
+	// path is the current session's log file. It is set before resuming to continue an existing log
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+var (
+	_ twchartClient   = &roastLog{}
+	_ commandRecorder = &roastLog{}
+)
+
+func newRoastLog(dir string) *roastLog {
+	return &roastLog{dir: dir}
+}
+
+// Path returns the current session's log file, or an empty string if a session has not been started
+func (l *roastLog) Path() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.path
+}
+
+// CreateSession starts a new log file named after the session
+func (l *roastLog) CreateSession(ctx context.Context, beanName string, probes twchart.Probes) (string, error) {
+	now := time.Now()
+	err := l.create(beanName, now)
+	if err != nil {
+		return "", err
+	}
+	return "", l.write(RoastLogEntry{Time: now, Type: RoastLogSession, Name: beanName, Probes: probes})
+}
+
+// ResumeSession continues writing to the log file from before resuming. A new file is started if the
+// previous one is not known
+func (l *roastLog) ResumeSession(ctx context.Context, id string) error {
+	now := time.Now()
+
+	var err error
+	if path := l.Path(); path != "" {
+		err = l.open(path, 0)
+	} else {
+		err = l.create(cmp.Or(id, "resumed"), now)
+	}
+	if err != nil {
+		return err
+	}
+	return l.write(RoastLogEntry{Time: now, Type: RoastLogResume, SessionID: id})
+}
+
+// SetStartTime implements twchartClient.
+func (l *roastLog) SetStartTime(ctx context.Context, startTime time.Time) error {
+	return l.write(RoastLogEntry{Time: startTime, Type: RoastLogStart})
+}
+
+// AddEvent implements twchartClient.
+func (l *roastLog) AddEvent(ctx context.Context, note string, now time.Time) error {
+	return l.write(RoastLogEntry{Time: now, Type: RoastLogEvent, Name: note})
+}
+
+// AddStage implements twchartClient.
+func (l *roastLog) AddStage(ctx context.Context, name string, now time.Time) error {
+	return l.write(RoastLogEntry{Time: now, Type: RoastLogStage, Name: name})
+}
+
+// AddData implements twchartClient.
+func (l *roastLog) AddData(ctx context.Context, data []twchart.Data) error {
+	return l.write(RoastLogEntry{Time: time.Now(), Type: RoastLogData, Data: data})
+}
+
+// Done implements twchartClient.
+func (l *roastLog) Done(ctx context.Context, now time.Time) error {
+	return l.write(RoastLogEntry{Time: now, Type: RoastLogDone})
+}
+
+// RecordCommand writes the command and the firmware's response. Errors are ignored since the command
+// has already been sent
+func (l *roastLog) RecordCommand(now time.Time, result CommandResult, err error) {
+	entry := RoastLogEntry{
+		Time:    now,
+		Type:    RoastLogCommand,
+		Name:    result.Command,
+		Output:  result.Output,
+		Payload: string(result.Payload),
+	}
+	if result.Status != 0 || err == nil {
+		entry.Status = result.Status.String()
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	writeErr := l.write(entry)
+	if writeErr != nil {
+		fmt.Printf("Error: %v\n", writeErr)
+	}
+}
+
+// Close closes the current log file
+func (l *roastLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// create opens a new log file named after the time and name. Sessions that start in the same second get a number
+// after the name so they don't use the same file
+func (l *roastLog) create(name string, now time.Time) error {
+	base := filepath.Join(l.dir, now.Format("2006-01-02T150405")+"-"+logFileName(name))
+	path := base + ".jsonl"
+	for i := 2; ; i++ {
+		err := l.open(path, os.O_EXCL)
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		path = fmt.Sprintf("%s-%d.jsonl", base, i)
+	}
+}
+
+// open replaces the current log file. If the file can't be opened, the current one is still closed so the new
+// session isn't written to it
+func (l *roastLog) open(path string, flag int) error {
+	var file *os.File
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		err = fmt.Errorf("%w: error creating directory: %w", errRoastLogUnavailable, err)
+	} else {
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND|flag, 0o644)
+		if err != nil {
+			err = fmt.Errorf("%w: error opening file: %w", errRoastLogUnavailable, err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = file
+	l.path = ""
+	if file != nil {
+		l.path = path
+	}
+
+	return err
+}
+
+func (l *roastLog) write(entry RoastLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding roast log entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	_, err = l.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("error writing roast log: %w", err)
+	}
+	return nil
+}
+
+// logFileName replaces characters that might not be allowed in file names
+func logFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, strings.TrimSpace(name))
+	if name == "" {
+		return "roast"
+	}
+	return name
+}
@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readRoastLogFile(t *testing.T, path string) []RoastLogEntry {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("error opening roast log: %v", err)
+	}
+	defer f.Close()
+
+	entries, err := ReadRoastLog(f)
+	if err != nil {
+		t.Fatalf("ReadRoastLog() error = %v", err)
+	}
+	return entries
+}
+
+func TestRoastLogRecordsRun(t *testing.T) {
+	log := newRoastLog(t.TempDir())
+	c := &Controller{
+		config: Config{
+			SessionName: "Test Bean",
+		},
+		twchartClient: log,
+		roastLog:      log,
+		port:          &mockPort{failures: map[string]string{"P9": "invalid input"}},
+	}
+
+	var output bytes.Buffer
+	input := strings.NewReader("I55\nS\nPREHEAT\nF6\nP9\nNOTE smells good\nFC\nDONE\n")
+	if err := c.Run(context.Background(), input, &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	path := log.Path()
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if !strings.HasSuffix(path, "-Test-Bean.jsonl") {
+		t.Errorf("roast log path = %q, want file named after the session", path)
+	}
+
+	var got []string
+	for _, entry := range readRoastLogFile(t, path) {
+		if entry.Time.IsZero() {
+			t.Errorf("%s entry is missing a time", entry.Type)
+		}
+		got = append(got, string(entry.Type)+" "+entry.Name)
+		if entry.Name == "P9" && entry.Type == RoastLogCommand && entry.Error == "" {
+			t.Errorf("failed command entry is missing the error: %+v", entry)
+		}
+	}
+	want := []string{
+		"session Test Bean",
+		"command I55",
+		"start ",
+		"command S",
+		"stage Preheat",
+		"event F6",
+		"command F6",
+		"command P9",
+		"event smells good",
+		"event First Crack",
+		"done ",
+	}
+	if !equalStrings(got, want) {
+		t.Errorf("roast log = %q, want %q", got, want)
+	}
+}
+
+func TestRoastLogContinuesAfterResume(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	log := newRoastLog(dir)
+	if _, err := log.CreateSession(ctx, "Test Bean", nil); err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	path := log.Path()
+	log.Close()
+
+	resumed := newRoastLog(dir)
+	resumed.path = path
+	if err := resumed.ResumeSession(ctx, "session-id"); err != nil {
+		t.Fatalf("ResumeSession() error = %v", err)
+	}
+	resumed.Close()
+
+	entries := readRoastLogFile(t, path)
+	if len(entries) != 2 || entries[1].Type != RoastLogResume || entries[1].SessionID != "session-id" {
+		t.Errorf("entries = %+v, want session followed by resume", entries)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("roast log directory has %d files, want 1", len(files))
+	}
+}
+
+func TestRoastLogSessionsInTheSameSecond(t *testing.T) {
+	log := newRoastLog(t.TempDir())
+	now := time.Now()
+
+	if err := log.create("Test Bean", now); err != nil {
+		t.Fatalf("create() error = %v", err)
+	}
+	first := log.Path()
+	if err := log.create("Test Bean", now); err != nil {
+		t.Fatalf("create() error = %v", err)
+	}
+	log.Close()
+
+	if second := log.Path(); second == first || !strings.HasSuffix(second, "-Test-Bean-2.jsonl") {
+		t.Errorf("second path = %q, want a new file numbered after %q", second, first)
+	}
+}
+
+func TestRunContinuesWithoutRoastLog(t *testing.T) {
+	// the roast log directory can't be created because a file has the same name
+	dir := filepath.Join(t.TempDir(), "roast_logs")
+	if err := os.WriteFile(dir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := &recordingTWChartClient{}
+	log := newRoastLog(dir)
+	port := &mockPort{}
+	c := &Controller{
+		config:        Config{SessionName: "Test Bean"},
+		twchartClient: multiTWChartClient{mock, log},
+		roastLog:      log,
+		port:          port,
+	}
+
+	var output bytes.Buffer
+	if err := c.Run(context.Background(), strings.NewReader("F5\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !strings.Contains(output.String(), "Warning: roast log is unavailable") {
+		t.Errorf("output = %q, want a warning about the roast log", output.String())
+	}
+	if strings.Contains(output.String(), "Error:") {
+		t.Errorf("output = %q, want no errors for events that aren't logged", output.String())
+	}
+	if got, want := mock.events, []string{"F5"}; !equalStrings(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestMultiTWChartClient(t *testing.T) {
+	recording := &recordingTWChartClient{}
+	log := newRoastLog(t.TempDir())
+	client := multiTWChartClient{log, recording}
+
+	id, err := client.CreateSession(context.Background(), "Test Bean", nil)
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	if id != "session-id" {
+		t.Errorf("CreateSession() = %q, want session-id", id)
+	}
+	if err := client.AddEvent(context.Background(), "F5", time.Now()); err != nil {
+		t.Fatalf("AddEvent() error = %v", err)
+	}
+	log.Close()
+
+	if got, want := recording.events, []string{"F5"}; !equalStrings(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+	if entries := readRoastLogFile(t, log.Path()); len(entries) != 2 {
+		t.Errorf("roast log has %d entries, want 2", len(entries))
+	}
+}
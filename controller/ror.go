@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRoRWindow is the RoRWindow used when it is not set
+const DefaultRoRWindow = 30 * time.Second
+
+// rorNotePrefix and rorNoteSuffix surround the rate of rise that is added to a fan or power change's event
+const (
+	rorNotePrefix = " (RoR "
+	rorNoteSuffix = "°F/min)"
+)
+
+// RateOfRise calculates the bean temperature's rate of rise in degrees per minute. It is the slope of a least
+// squares line through the readings in the window, which smooths out the thermocouple's noise and the delay
+// before the controller receives each reading. It is not safe for concurrent use
+type RateOfRise struct {
+	window   time.Duration
+	readings []rorReading
+}
+
+type rorReading struct {
+	time        time.Time
+	temperature float64
+}
+
+// NewRateOfRise creates a RateOfRise using readings from the window, like 30s or 60s. DefaultRoRWindow is used
+// if it is not positive
+func NewRateOfRise(window time.Duration) *RateOfRise {
+	if window <= 0 {
+		window = DefaultRoRWindow
+	}
+	return &RateOfRise{window: window}
+}
+
+// Window returns how far back readings are used
+func (r *RateOfRise) Window() time.Duration {
+	return r.window
+}
+
+// Add records a reading and drops the ones that are older than the window. Readings from before the last one,
+// like from a previous roast, start over
+func (r *RateOfRise) Add(t time.Time, temperature float64) {
+	if n := len(r.readings); n > 0 && t.Before(r.readings[n-1].time) {
+		r.readings = r.readings[:0]
+	}
+	r.readings = append(r.readings, rorReading{time: t, temperature: temperature})
+
+	cutoff := t.Add(-r.window)
+	i := 0
+	for i < len(r.readings) && r.readings[i].time.Before(cutoff) {
+		i++
+	}
+	r.readings = append(r.readings[:0], r.readings[i:]...)
+}
+
+// Value returns the rate of rise in degrees per minute. It returns false until the readings cover at least half
+// of the window, since a shorter line is mostly noise
+func (r *RateOfRise) Value() (float64, bool) {
+	if len(r.readings) < 2 {
+		return 0, false
+	}
+	first, last := r.readings[0], r.readings[len(r.readings)-1]
+	if last.time.Sub(first.time) < r.window/2 {
+		return 0, false
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	for _, reading := range r.readings {
+		x := reading.time.Sub(first.time).Minutes()
+		sumX += x
+		sumY += reading.temperature
+		sumXY += x * reading.temperature
+		sumXX += x * x
+	}
+
+	n := float64(len(r.readings))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+	return (n*sumXY - sumX*sumY) / denominator, true
+}
+
+// RateOfRise returns the bean temperature's rate of rise in degrees Fahrenheit per minute over the RoRWindow. It
+// returns false if there are not enough readings
+func (c *Controller) RateOfRise() (float64, bool) {
+	c.temperatureMu.Lock()
+	defer c.temperatureMu.Unlock()
+
+	if c.rateOfRise == nil {
+		return 0, false
+	}
+	return c.rateOfRise.Value()
+}
+
+// settingEvent returns the TWChart event for a fan or power change with the current rate of rise, like
+// "F5 (RoR 12.5°F/min)". The event is only the command if there are not enough readings
+func (c *Controller) settingEvent(command string) string {
+	ror, ok := c.RateOfRise()
+	if !ok {
+		return command
+	}
+	return fmt.Sprintf("%s%s%.1f%s", command, rorNotePrefix, ror, rorNoteSuffix)
+}
+
+// settingEventCommand removes the rate of rise that settingEvent adds so fan and power changes are recognized.
+// Other notes are returned unchanged
+func settingEventCommand(note string) string {
+	command, ror, ok := strings.Cut(note, rorNotePrefix)
+	if !ok {
+		return note
+	}
+	ror, ok = strings.CutSuffix(ror, rorNoteSuffix)
+	if !ok {
+		return note
+	}
+	if _, err := strconv.ParseFloat(ror, 64); err != nil {
+		return note
+	}
+	return command
+}
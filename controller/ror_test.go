@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateOfRise(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("Linear", func(t *testing.T) {
+		r := NewRateOfRise(30 * time.Second)
+		for s := 0; s <= 60; s += 2 {
+			if s == 14 {
+				if _, ok := r.Value(); ok {
+					t.Error("Value() = true before the readings cover half the window, want false")
+				}
+			}
+			r.Add(start.Add(time.Duration(s)*time.Second), 200+float64(s)/6)
+		}
+
+		ror, ok := r.Value()
+		if !ok || math.Abs(ror-10) > 0.001 {
+			t.Errorf("Value() = %v, %v, want 10", ror, ok)
+		}
+	})
+
+	t.Run("Noise", func(t *testing.T) {
+		r := NewRateOfRise(time.Minute)
+		for s := 0; s <= 60; s++ {
+			noise := 1.0
+			if s%2 == 0 {
+				noise = -1
+			}
+			r.Add(start.Add(time.Duration(s)*time.Second), 300+float64(s)/4+noise)
+		}
+
+		ror, ok := r.Value()
+		if !ok || math.Abs(ror-15) > 0.5 {
+			t.Errorf("Value() = %v, %v, want about 15", ror, ok)
+		}
+	})
+
+	t.Run("OnlyWindow", func(t *testing.T) {
+		r := NewRateOfRise(30 * time.Second)
+		temperature := 200.0
+		for s := 1; s <= 120; s++ {
+			// the rate of rise drops from 20 to 5 halfway through
+			if s <= 60 {
+				temperature += 20.0 / 60
+			} else {
+				temperature += 5.0 / 60
+			}
+			r.Add(start.Add(time.Duration(s)*time.Second), temperature)
+		}
+
+		ror, ok := r.Value()
+		if !ok || math.Abs(ror-5) > 0.001 {
+			t.Errorf("Value() = %v, %v, want 5 from the last 30s", ror, ok)
+		}
+	})
+
+	t.Run("StartOver", func(t *testing.T) {
+		r := NewRateOfRise(0)
+		if r.Window() != DefaultRoRWindow {
+			t.Errorf("Window() = %s, want %s", r.Window(), DefaultRoRWindow)
+		}
+		r.Add(start.Add(time.Minute), 400)
+		r.Add(start.Add(2*time.Minute), 450)
+		r.Add(start, 70)
+		if _, ok := r.Value(); ok {
+			t.Error("Value() = true after starting over, want false")
+		}
+	})
+}
+
+func TestSettingEventCommand(t *testing.T) {
+	for note, want := range map[string]string{
+		"F5":                     "F5",
+		"P7 (RoR 12.5°F/min)":    "P7",
+		"P7 (RoR -3.0°F/min)":    "P7",
+		"First Crack":            "First Crack",
+		"RoR too fast":           "RoR too fast",
+		"F5 (RoR 12.5°F/min) ok": "F5 (RoR 12.5°F/min) ok",
+		"F5 (RoR fast°F/min)":    "F5 (RoR fast°F/min)",
+	} {
+		if got := settingEventCommand(note); got != want {
+			t.Errorf("settingEventCommand(%q) = %q, want %q", note, got, want)
+		}
+	}
+}
+
+func TestRunAddsRateOfRiseToEvents(t *testing.T) {
+	mock := &recordingTWChartClient{}
+	c := &Controller{
+		config:        Config{SessionName: "test", RoRWindow: 30 * time.Second},
+		twchartClient: mock,
+		port:          &mockPort{},
+	}
+
+	var output bytes.Buffer
+	if err := c.Run(context.Background(), strings.NewReader("F5\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if _, ok := c.RateOfRise(); ok {
+		t.Error("RateOfRise() = true without readings, want false")
+	}
+
+	now := time.Now()
+	for s := 30; s >= 0; s -= 3 {
+		temperature := Temperature{Time: now.Add(-time.Duration(s) * time.Second)}
+		temperature.Bean = float32(400 - s/3)
+		c.recordTemperature(temperature)
+	}
+
+	ror, ok := c.RateOfRise()
+	if !ok || math.Abs(ror-20) > 0.001 {
+		t.Fatalf("RateOfRise() = %v, %v, want 20", ror, ok)
+	}
+
+	if err := c.Run(context.Background(), strings.NewReader("P7\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	want := []string{"F5", "P7 (RoR 20.0°F/min)"}
+	if strings.Join(mock.events, ",") != strings.Join(want, ",") {
+		t.Errorf("AddEvent calls = %v, want %v", mock.events, want)
+	}
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultSessionFile is where the current session is saved so it can be resumed
+const DefaultSessionFile = ".current_session"
+
+// SessionState is the saved state of a roast that can be resumed after restarting
+type SessionState struct {
+	ID        string
+	Name      string
+	StartTime time.Time
+	Stages    []SessionStage
+	Fan       int
+	Power     int
+	// RoastLog is the local roast log file, so it can be continued after resuming
+	RoastLog string `json:",omitempty"`
+}
+
+// SessionStage is a roast stage or first crack and when it started
+type SessionStage struct {
+	Name  string
+	Start time.Time
+}
+
+// Stage returns the name of the latest stage, or an empty string if no stages have started
+func (s SessionState) Stage() string {
+	if len(s.Stages) == 0 {
+		return ""
+	}
+	return s.Stages[len(s.Stages)-1].Name
+}
+
+// LoadSessionState reads a SessionState saved by the controller
+func LoadSessionState(path string) (SessionState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SessionState{}, fmt.Errorf("error reading session file: %w", err)
+	}
+
+	var state SessionState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return SessionState{}, fmt.Errorf("error parsing session file: %w", err)
+	}
+	if state.ID == "" && state.Name == "" {
+		return SessionState{}, errors.New("session file is missing session details")
+	}
+
+	return state, nil
+}
+
+// saveSessionState writes the session so it can be loaded by LoadSessionState
+func saveSessionState(path string, state SessionState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding session: %w", err)
+	}
+
+	err = writeFileAtomic(path, data)
+	if err != nil {
+		return fmt.Errorf("error saving session file: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file and renames it so the file is never partially written
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
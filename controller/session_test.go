@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSavesSession(t *testing.T) {
+	sessionFile := filepath.Join(t.TempDir(), DefaultSessionFile)
+	c := &Controller{
+		config: Config{
+			SessionName: "Test Bean",
+			SessionFile: sessionFile,
+		},
+		twchartClient: &recordingTWChartClient{},
+		port:          &mockPort{},
+	}
+
+	var output bytes.Buffer
+	input := strings.NewReader("I55\nS\nPREHEAT\nF6\nROASTING\nFC\nP4\n")
+	if err := c.Run(context.Background(), input, &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	state, err := LoadSessionState(sessionFile)
+	if err != nil {
+		t.Fatalf("LoadSessionState() error = %v", err)
+	}
+	if state.ID != "session-id" || state.Name != "Test Bean" {
+		t.Errorf("session = %q/%q, want session-id/Test Bean", state.ID, state.Name)
+	}
+	if state.Fan != 6 || state.Power != 4 {
+		t.Errorf("settings = F%d/P%d, want F6/P4", state.Fan, state.Power)
+	}
+	if state.StartTime.IsZero() {
+		t.Error("StartTime is zero, want start time")
+	}
+	if got, want := state.Stage(), "First Crack"; got != want {
+		t.Errorf("Stage() = %q, want %q", got, want)
+	}
+	if got := len(state.Stages); got != 3 {
+		t.Errorf("len(Stages) = %d, want 3", got)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(sessionFile))
+	if len(entries) != 1 {
+		t.Errorf("session directory has %d files, want only the session file", len(entries))
+	}
+}
+
+func TestRunRemovesSessionWhenDone(t *testing.T) {
+	sessionFile := filepath.Join(t.TempDir(), DefaultSessionFile)
+	c := &Controller{
+		config: Config{
+			SessionName: "Test Bean",
+			SessionFile: sessionFile,
+		},
+		twchartClient: &recordingTWChartClient{},
+		port:          &mockPort{},
+	}
+
+	if err := c.Run(context.Background(), strings.NewReader("S\nDONE\n"), &bytes.Buffer{}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if _, err := os.Stat(sessionFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat() error = %v, want session file removed", err)
+	}
+}
+
+func TestResumeSession(t *testing.T) {
+	sessionFile := filepath.Join(t.TempDir(), DefaultSessionFile)
+	startTime := time.Now().Add(-5 * time.Minute)
+	err := saveSessionState(sessionFile, SessionState{ID: "abc", Name: "Test Bean", StartTime: startTime, Fan: 7, Power: 3})
+	if err != nil {
+		t.Fatalf("saveSessionState() error = %v", err)
+	}
+
+	c, err := New(Config{
+		SerialPort:  SerialPortNone,
+		BaudRate:    "115200",
+		SessionFile: sessionFile,
+		Resume:      true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	mock := &recordingTWChartClient{}
+	c.twchartClient = mock
+
+	port := c.port.(*mockPort)
+	// the firmware's timer continues from when the roast was started
+	if got, want := port.commands, []string{"I73", "E00300"}; !equalStrings(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+
+	if err := c.Run(context.Background(), strings.NewReader("F5\n"), &bytes.Buffer{}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(mock.created) != 0 {
+		t.Errorf("created sessions = %q, want none", mock.created)
+	}
+	if got, want := mock.resumed, []string{"abc"}; !equalStrings(got, want) {
+		t.Errorf("resumed sessions = %q, want %q", got, want)
+	}
+
+	state, err := LoadSessionState(sessionFile)
+	if err != nil {
+		t.Fatalf("LoadSessionState() error = %v", err)
+	}
+	if state.ID != "abc" || state.Fan != 5 || state.Power != 3 {
+		t.Errorf("session = %#v, want resumed session with F5/P3", state)
+	}
+}
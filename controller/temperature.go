@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/calvinmclean/autoroast"
+)
+
+// Temperature is a bean temperature reading from the firmware's thermocouple
+type Temperature struct {
+	autoroast.TemperatureReading
+	// Time is when the reading was taken. Readings that the firmware streamed before they were received are
+	// stamped using their Elapsed
+	Time time.Time
+}
+
+// OnTemperature sets a function that is called with each reading from the firmware. It is called while a
+// command is being sent, so it must not send commands
+func (c *Controller) OnTemperature(f func(Temperature)) {
+	c.temperatureMu.Lock()
+	defer c.temperatureMu.Unlock()
+	c.onTemperature = f
+}
+
+// LastTemperature returns the most recent reading from the firmware. It returns false if there are no readings
+func (c *Controller) LastTemperature() (Temperature, bool) {
+	c.temperatureMu.Lock()
+	defer c.temperatureMu.Unlock()
+	return c.temperature, !c.temperature.Time.IsZero()
+}
+
+// Temperature reads the bean temperature from the firmware
+func (c *Controller) Temperature(ctx context.Context) (Temperature, error) {
+	if err := ctx.Err(); err != nil {
+		return Temperature{}, err
+	}
+
+	result, err := c.passthroughCommand([]byte{'B'})
+	if err != nil {
+		return Temperature{}, fmt.Errorf("error getting temperature: %w", err)
+	}
+
+	temperature := Temperature{Time: time.Now()}
+	err = temperature.UnmarshalText(result.Payload)
+	if err != nil {
+		return Temperature{}, fmt.Errorf("error parsing temperature: %w", err)
+	}
+	c.recordTemperature(temperature)
+
+	return temperature, nil
+}
+
+// pollTemperature reads the temperature every TemperatureInterval until ctx is done. Errors are ignored since a
+// lost connection is handled by the next command
+func (c *Controller) pollTemperature(ctx context.Context) {
+	ticker := time.NewTicker(c.config.TemperatureInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, _ = c.Temperature(ctx)
+		}
+	}
+}
+
+// collectTemperatures records the temperature readings in the firmware's output and returns the rest of the output.
+// The last reading was just taken, and the others are stamped by how long before it they were taken
+func (c *Controller) collectTemperatures(output string) string {
+	if !strings.Contains(output, autoroast.TemperaturePrefix) {
+		return output
+	}
+
+	var temperatures []Temperature
+	var rest []string
+	for _, line := range strings.Split(output, "\n") {
+		text, ok := strings.CutPrefix(strings.TrimSpace(line), autoroast.TemperaturePrefix)
+		if ok {
+			var temperature Temperature
+			if temperature.UnmarshalText([]byte(text)) == nil {
+				temperatures = append(temperatures, temperature)
+				continue
+			}
+		}
+		rest = append(rest, line)
+	}
+
+	now := time.Now()
+	var last time.Duration
+	if len(temperatures) > 0 {
+		last = temperatures[len(temperatures)-1].Elapsed
+	}
+	for _, temperature := range temperatures {
+		temperature.Time = now
+		// readings before the roast is started, or before the firmware restarted, don't have a usable Elapsed
+		if temperature.Elapsed > 0 && temperature.Elapsed <= last {
+			temperature.Time = now.Add(temperature.Elapsed - last)
+		}
+		c.recordTemperature(temperature)
+	}
+	return strings.Join(rest, "\n")
+}
+
+// subscribeTemperature calls f with each reading from the firmware until the returned function is called
+func (c *Controller) subscribeTemperature(f func(Temperature)) func() {
+	c.temperatureMu.Lock()
+	defer c.temperatureMu.Unlock()
+
+	if c.temperatureSubscribers == nil {
+		c.temperatureSubscribers = map[int]func(Temperature){}
+	}
+	id := c.nextTemperatureSubscriber
+	c.nextTemperatureSubscriber++
+	c.temperatureSubscribers[id] = f
+
+	return func() {
+		c.temperatureMu.Lock()
+		defer c.temperatureMu.Unlock()
+		delete(c.temperatureSubscribers, id)
+	}
+}
+
+func (c *Controller) recordTemperature(temperature Temperature) {
+	c.temperatureMu.Lock()
+	c.temperature = temperature
+	if c.rateOfRise == nil {
+		c.rateOfRise = NewRateOfRise(c.config.RoRWindow)
+	}
+	c.rateOfRise.Add(temperature.Time, float64(temperature.Bean))
+	var subscribers []func(Temperature)
+	if c.onTemperature != nil {
+		subscribers = append(subscribers, c.onTemperature)
+	}
+	for _, f := range c.temperatureSubscribers {
+		subscribers = append(subscribers, f)
+	}
+	c.temperatureMu.Unlock()
+
+	for _, f := range subscribers {
+		f(temperature)
+	}
+}
@@ -0,0 +1,248 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calvinmclean/autoroast/twchart"
+)
+
+// twchartDataInterval is how often probe readings are sent to TWChart
+const twchartDataInterval = 5 * time.Second
+
+// ProbeReading is a temperature from one of the session's probes
+type ProbeReading struct {
+	Position twchart.ProbePosition
+	Time     time.Time
+	// Temperature is in degrees Fahrenheit
+	Temperature float64
+}
+
+// TemperatureSource provides probe readings during a roast. Run sends readings until ctx is done. The firmware's
+// thermocouple is used as a source when TemperatureInterval is set, and others can be added with
+// AddTemperatureSource
+type TemperatureSource interface {
+	Run(ctx context.Context, readings chan<- ProbeReading) error
+}
+
+// AddTemperatureSource adds a source of probe readings that are sent to TWChart while Run is running
+func (c *Controller) AddTemperatureSource(source TemperatureSource) {
+	c.temperatureSources = append(c.temperatureSources, source)
+}
+
+// OnProbeReading sets a function that is called with each reading from the TemperatureSources while Run is
+// running. It must be set before Run
+func (c *Controller) OnProbeReading(f func(ProbeReading)) {
+	c.onProbeReading = f
+}
+
+// thermocoupleSource sends the firmware's bean temperature readings as the probe at position
+type thermocoupleSource struct {
+	position     twchart.ProbePosition
+	temperatures chan Temperature
+	unsubscribe  func()
+}
+
+var _ TemperatureSource = &thermocoupleSource{}
+
+// newThermocoupleSource subscribes to readings right away so none are missed before Run starts. Readings are
+// dropped if Run falls behind, rather than blocking the command that received them
+func newThermocoupleSource(c *Controller, position twchart.ProbePosition) *thermocoupleSource {
+	s := &thermocoupleSource{
+		position:     position,
+		temperatures: make(chan Temperature, 64),
+	}
+	s.unsubscribe = c.subscribeTemperature(func(temperature Temperature) {
+		select {
+		case s.temperatures <- temperature:
+		default:
+		}
+	})
+	return s
+}
+
+func (s *thermocoupleSource) Run(ctx context.Context, readings chan<- ProbeReading) error {
+	defer s.unsubscribe()
+
+	for {
+		select {
+		case temperature := <-s.temperatures:
+			readings <- s.reading(temperature)
+		case <-ctx.Done():
+			// readings are still collected while the sources stop, so the rest are sent
+			for {
+				select {
+				case temperature := <-s.temperatures:
+					readings <- s.reading(temperature)
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// reading rounds the temperature to the firmware's precision so converting it from a float32 doesn't add noise
+func (s *thermocoupleSource) reading(temperature Temperature) ProbeReading {
+	return ProbeReading{
+		Position:    s.position,
+		Time:        temperature.Time,
+		Temperature: math.Round(float64(temperature.Bean)*100) / 100,
+	}
+}
+
+// thermocoupleProbe returns the position of the probe that the thermocouple's readings are sent as
+func (c *Controller) thermocoupleProbe(probes twchart.Probes) (twchart.ProbePosition, error) {
+	for _, probe := range probes {
+		if strings.EqualFold(probe.Name, c.config.ThermocoupleProbe) {
+			return probe.Position, nil
+		}
+	}
+	return 0, fmt.Errorf("thermocouple probe %q is not one of the session's probes", c.config.ThermocoupleProbe)
+}
+
+// temperatureUpload collects readings from TemperatureSources and sends them to TWChart in batches. Readings in
+// the same second are combined into one row since that is the resolution of TWChart's data
+type temperatureUpload struct {
+	client   twchartClient
+	interval time.Duration
+	readings chan ProbeReading
+	batch    []twchart.Data
+	// sent is the latest second that was sent. Readings for it or earlier seconds are dropped since TWChart
+	// already has their rows
+	sent time.Time
+	// onReading is called with each reading before it is added to the batch
+	onReading func(ProbeReading)
+
+	cancel  context.CancelFunc
+	sources sync.WaitGroup
+	done    chan struct{}
+}
+
+// startTemperatureUpload runs the sources and sends their readings until stopTemperatureUpload is called
+func (c *Controller) startTemperatureUpload(ctx context.Context, sources []TemperatureSource) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &temperatureUpload{
+		client:    c.twchartClient,
+		interval:  twchartDataInterval,
+		readings:  make(chan ProbeReading, 64),
+		onReading: c.onProbeReading,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	for _, source := range sources {
+		s.sources.Go(func() {
+			err := source.Run(ctx, s.readings)
+			if err != nil && ctx.Err() == nil {
+				fmt.Printf("Error: temperature source stopped: %v\n", err)
+			}
+		})
+	}
+	go s.run(ctx)
+
+	c.temperatureUpload = s
+}
+
+// stopTemperatureUpload stops the sources and waits for the last readings to be sent
+func (c *Controller) stopTemperatureUpload() {
+	if c.temperatureUpload == nil {
+		return
+	}
+	c.temperatureUpload.cancel()
+	<-c.temperatureUpload.done
+	c.temperatureUpload = nil
+}
+
+func (s *temperatureUpload) run(ctx context.Context) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case reading := <-s.readings:
+			s.add(reading)
+		case <-ticker.C:
+			s.flush()
+		case <-ctx.Done():
+			s.finish()
+			return
+		}
+	}
+}
+
+// finish keeps collecting readings until the sources stop and then sends the rest of them
+func (s *temperatureUpload) finish() {
+	stopped := make(chan struct{})
+	go func() {
+		s.sources.Wait()
+		close(stopped)
+	}()
+
+	for {
+		select {
+		case reading := <-s.readings:
+			s.add(reading)
+		case <-stopped:
+			for len(s.readings) > 0 {
+				s.add(<-s.readings)
+			}
+			s.flush()
+			return
+		}
+	}
+}
+
+// add puts the reading in the batch's row for its second. Probes without a reading in a row are -1, like
+// Thermoworks data. A reading that arrives after its second was sent is dropped
+func (s *temperatureUpload) add(reading ProbeReading) {
+	if reading.Position <= 0 {
+		return
+	}
+	if s.onReading != nil {
+		s.onReading(reading)
+	}
+
+	t := reading.Time.Truncate(time.Second)
+	if !t.After(s.sent) {
+		return
+	}
+	i := slices.IndexFunc(s.batch, func(d twchart.Data) bool {
+		return d.Time.Equal(t)
+	})
+	if i < 0 {
+		s.batch = append(s.batch, twchart.Data{Time: t})
+		i = len(s.batch) - 1
+	}
+
+	row := &s.batch[i]
+	for len(row.ProbeData) < int(reading.Position) {
+		row.ProbeData = append(row.ProbeData, -1)
+	}
+	row.ProbeData[reading.Position-1] = reading.Temperature
+}
+
+// flush sends the batch in order
+func (s *temperatureUpload) flush() {
+	if len(s.batch) == 0 {
+		return
+	}
+
+	slices.SortFunc(s.batch, func(a, b twchart.Data) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	err := s.client.AddData(context.Background(), s.batch)
+	if err != nil {
+		fmt.Printf("Error: error sending temperatures to TWChart: %v\n", err)
+	}
+	s.sent = s.batch[len(s.batch)-1].Time
+	s.batch = nil
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixedTemperatureSource sends its readings and then waits until it is stopped
+type fixedTemperatureSource []ProbeReading
+
+func (s fixedTemperatureSource) Run(ctx context.Context, readings chan<- ProbeReading) error {
+	for _, reading := range s {
+		readings <- reading
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func TestTemperatureUpload(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	client := &recordingTWChartClient{}
+	c := &Controller{twchartClient: client}
+
+	c.startTemperatureUpload(context.Background(), []TemperatureSource{
+		fixedTemperatureSource{
+			{Position: 2, Time: start.Add(1500 * time.Millisecond), Temperature: 301},
+			{Position: 2, Time: start.Add(100 * time.Millisecond), Temperature: 300},
+			// positions that aren't probes are ignored
+			{Position: 0, Time: start, Temperature: 1},
+		},
+		fixedTemperatureSource{
+			{Position: 1, Time: start.Add(200 * time.Millisecond), Temperature: 75},
+		},
+	})
+	c.stopTemperatureUpload()
+
+	if len(client.data) != 2 {
+		t.Fatalf("data = %+v, want 2 rows", client.data)
+	}
+	if !client.data[0].Time.Equal(start) || !slices.Equal(client.data[0].ProbeData, []float64{75, 300}) {
+		t.Errorf("first row = %+v, want both probes at the start", client.data[0])
+	}
+	if !client.data[1].Time.Equal(start.Add(time.Second)) || !slices.Equal(client.data[1].ProbeData, []float64{-1, 301}) {
+		t.Errorf("second row = %+v, want only the second probe after 1s", client.data[1])
+	}
+
+	// stopping again does nothing
+	c.stopTemperatureUpload()
+}
+
+func TestTemperatureUploadDropsLateReadings(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	client := &recordingTWChartClient{}
+	s := &temperatureUpload{client: client}
+
+	s.add(ProbeReading{Position: 1, Time: start.Add(time.Second), Temperature: 75})
+	s.flush()
+	// the first second was already sent in a row for the second probe, so this doesn't send another one
+	s.add(ProbeReading{Position: 2, Time: start.Add(1200 * time.Millisecond), Temperature: 300})
+	s.add(ProbeReading{Position: 2, Time: start.Add(500 * time.Millisecond), Temperature: 299})
+	s.add(ProbeReading{Position: 2, Time: start.Add(2 * time.Second), Temperature: 301})
+	s.flush()
+
+	if len(client.data) != 2 {
+		t.Fatalf("data = %+v, want 2 rows", client.data)
+	}
+	if !client.data[0].Time.Equal(start.Add(time.Second)) || !slices.Equal(client.data[0].ProbeData, []float64{75}) {
+		t.Errorf("first row = %+v, want the first probe after 1s", client.data[0])
+	}
+	if !client.data[1].Time.Equal(start.Add(2*time.Second)) || !slices.Equal(client.data[1].ProbeData, []float64{-1, 301}) {
+		t.Errorf("second row = %+v, want only the second probe after 2s", client.data[1])
+	}
+}
+
+func TestRunSendsThermocoupleTemperatures(t *testing.T) {
+	client := &recordingTWChartClient{}
+	port := &mockPort{
+		outputs:  map[string]string{"F5": "temp elapsed_ms=1000 bean=300.00\r\n"},
+		payloads: map[string]string{"B": "elapsed_ms=0 bean=72.50"},
+	}
+	c := &Controller{
+		config: Config{
+			SessionName:         "test",
+			ProbesInput:         "1=Ambient,2=Beans",
+			TemperatureInterval: time.Second,
+			ThermocoupleProbe:   "beans",
+		},
+		twchartClient: client,
+		port:          port,
+	}
+	var readings []ProbeReading
+	c.OnProbeReading(func(reading ProbeReading) {
+		readings = append(readings, reading)
+	})
+
+	var output bytes.Buffer
+	if err := c.Run(context.Background(), strings.NewReader("F5\nDONE\nF6\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(client.data) != 1 || !slices.Equal(client.data[0].ProbeData, []float64{-1, 300}) {
+		t.Errorf("data = %+v, want the reading as the Beans probe", client.data)
+	}
+	if len(readings) != 1 || readings[0].Position != 2 || readings[0].Temperature != 300 {
+		t.Errorf("OnProbeReading readings = %+v, want the reading as the Beans probe", readings)
+	}
+	if strings.Contains(output.String(), "Error") {
+		t.Errorf("output = %q, want no errors", output.String())
+	}
+}
+
+func TestRunUnknownThermocoupleProbe(t *testing.T) {
+	c := &Controller{
+		config: Config{
+			SessionName:         "test",
+			ProbesInput:         "1=Ambient,2=Beans",
+			TemperatureInterval: time.Second,
+			ThermocoupleProbe:   "Roaster",
+		},
+		twchartClient: &recordingTWChartClient{},
+		port:          &mockPort{payloads: map[string]string{"B": "elapsed_ms=0 bean=72.50"}},
+	}
+
+	var output bytes.Buffer
+	if err := c.Run(context.Background(), strings.NewReader("F5\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !strings.Contains(output.String(), `thermocouple probe "Roaster" is not one of the session's probes`) {
+		t.Errorf("output = %q, want an error for the unknown probe", output.String())
+	}
+}
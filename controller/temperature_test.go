@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCollectTemperatures(t *testing.T) {
+	port := &mockPort{outputs: map[string]string{
+		"F5": "temp elapsed_ms=1000 bean=300.00\r\n[1s] F5\r\ntemp elapsed_ms=2000 bean=301.50\r\n",
+	}}
+	c := &Controller{port: port}
+
+	var received []Temperature
+	c.OnTemperature(func(temperature Temperature) {
+		received = append(received, temperature)
+	})
+
+	if _, ok := c.LastTemperature(); ok {
+		t.Error("LastTemperature() = true before any readings, want false")
+	}
+
+	result, err := c.passthroughCommand([]byte("F5"))
+	if err != nil {
+		t.Fatalf("passthroughCommand() error = %v", err)
+	}
+	if want := "[mock firmware] received F5\r\n[1s] F5"; result.Output != want {
+		t.Errorf("output = %q, want %q without readings", result.Output, want)
+	}
+
+	if len(received) != 2 || received[0].Bean != 300 || received[1].Elapsed != 2*time.Second {
+		t.Fatalf("received = %+v, want both readings", received)
+	}
+	// the readings are stamped with when the firmware took them
+	if got := received[1].Time.Sub(received[0].Time); got != time.Second {
+		t.Errorf("time between readings = %s, want 1s", got)
+	}
+	last, ok := c.LastTemperature()
+	if !ok || last.Bean != 301.5 || last.Time.IsZero() {
+		t.Errorf("LastTemperature() = %+v, %v, want the last reading", last, ok)
+	}
+}
+
+func TestTemperature(t *testing.T) {
+	port := &mockPort{payloads: map[string]string{"B": "elapsed_ms=0 bean=72.50"}}
+	c := &Controller{port: port}
+
+	temperature, err := c.Temperature(context.Background())
+	if err != nil {
+		t.Fatalf("Temperature() error = %v", err)
+	}
+	if temperature.Bean != 72.5 {
+		t.Errorf("Temperature() = %+v, want 72.5F", temperature)
+	}
+	if last, _ := c.LastTemperature(); last != temperature {
+		t.Errorf("LastTemperature() = %+v, want %+v", last, temperature)
+	}
+
+	c.port = &mockPort{failures: map[string]string{"B": "no thermometer"}}
+	if _, err := c.Temperature(context.Background()); err == nil {
+		t.Error("Temperature() error = nil, want firmware error")
+	}
+}
+
+func TestRunPollsTemperature(t *testing.T) {
+	port := &mockPort{payloads: map[string]string{"B": "elapsed_ms=0 bean=72.50"}}
+	c := &Controller{
+		config:        Config{SessionName: "test", TemperatureInterval: 10 * time.Millisecond},
+		twchartClient: noopTWChartClient{},
+		port:          port,
+	}
+	var polled atomic.Int32
+	c.OnTemperature(func(Temperature) { polled.Add(1) })
+
+	input, inputWriter := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		var output bytes.Buffer
+		done <- c.Run(context.Background(), input, &output)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for polled.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = inputWriter.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	c.portMu.Lock()
+	defer c.portMu.Unlock()
+	if polled.Load() < 3 || slices.ContainsFunc(port.commands, func(command string) bool { return command != "B" }) {
+		t.Errorf("commands = %v, want only B every interval", port.commands)
+	}
+}
+
+func TestRunWithoutThermometer(t *testing.T) {
+	port := &mockPort{failures: map[string]string{"B": "no thermometer"}}
+	c := &Controller{
+		config:        Config{SessionName: "test", TemperatureInterval: time.Millisecond},
+		twchartClient: noopTWChartClient{},
+		port:          port,
+	}
+
+	var output bytes.Buffer
+	if err := c.Run(context.Background(), strings.NewReader("F5\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !strings.Contains(output.String(), "error getting temperature") {
+		t.Errorf("output = %q, want the temperature error", output.String())
+	}
+	if !slices.Equal(port.commands, []string{"B", "F5"}) {
+		t.Errorf("commands = %v, want B once before F5", port.commands)
+	}
+}
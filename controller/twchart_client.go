@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/calvinmclean/autoroast/twchart"
@@ -9,10 +10,12 @@ import (
 
 type twchartClient interface {
 	CreateSession(ctx context.Context, beanName string, probes twchart.Probes) (string, error)
+	ResumeSession(ctx context.Context, id string) error
 	SetStartTime(ctx context.Context, startTime time.Time) error
 	AddEvent(ctx context.Context, note string, now time.Time) error
 	AddStage(ctx context.Context, name string, now time.Time) error
-	Done(ctx context.Context) error
+	AddData(ctx context.Context, data []twchart.Data) error
+	Done(ctx context.Context, now time.Time) error
 }
 
 type noopTWChartClient struct{}
@@ -29,13 +32,23 @@ func (n noopTWChartClient) AddStage(ctx context.Context, name string, now time.T
 	return nil
 }
 
+// AddData implements twchartClient.
+func (n noopTWChartClient) AddData(ctx context.Context, data []twchart.Data) error {
+	return nil
+}
+
 // CreateSession implements twchartClient.
 func (n noopTWChartClient) CreateSession(ctx context.Context, beanName string, probes twchart.Probes) (string, error) {
 	return "", nil
 }
 
+// ResumeSession implements twchartClient.
+func (n noopTWChartClient) ResumeSession(ctx context.Context, id string) error {
+	return nil
+}
+
 // Done implements twchartClient.
-func (n noopTWChartClient) Done(ctx context.Context) error {
+func (n noopTWChartClient) Done(ctx context.Context, now time.Time) error {
 	return nil
 }
 
@@ -43,3 +56,91 @@ func (n noopTWChartClient) Done(ctx context.Context) error {
 func (n noopTWChartClient) SetStartTime(ctx context.Context, startTime time.Time) error {
 	return nil
 }
+
+// multiTWChartClient sends everything to each of its clients, like io.MultiWriter. The session ID is the first
+// non-empty ID returned by a client
+type multiTWChartClient []twchartClient
+
+var (
+	_ twchartClient   = multiTWChartClient{}
+	_ commandRecorder = multiTWChartClient{}
+)
+
+// CreateSession implements twchartClient.
+func (m multiTWChartClient) CreateSession(ctx context.Context, beanName string, probes twchart.Probes) (string, error) {
+	var sessionID string
+	var errs []error
+	for _, client := range m {
+		id, err := client.CreateSession(ctx, beanName, probes)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if sessionID == "" {
+			sessionID = id
+		}
+	}
+	return sessionID, errors.Join(errs...)
+}
+
+// ResumeSession implements twchartClient.
+func (m multiTWChartClient) ResumeSession(ctx context.Context, id string) error {
+	return m.each(func(client twchartClient) error {
+		return client.ResumeSession(ctx, id)
+	})
+}
+
+// SetStartTime implements twchartClient.
+func (m multiTWChartClient) SetStartTime(ctx context.Context, startTime time.Time) error {
+	return m.each(func(client twchartClient) error {
+		return client.SetStartTime(ctx, startTime)
+	})
+}
+
+// AddEvent implements twchartClient.
+func (m multiTWChartClient) AddEvent(ctx context.Context, note string, now time.Time) error {
+	return m.each(func(client twchartClient) error {
+		return client.AddEvent(ctx, note, now)
+	})
+}
+
+// AddStage implements twchartClient.
+func (m multiTWChartClient) AddStage(ctx context.Context, name string, now time.Time) error {
+	return m.each(func(client twchartClient) error {
+		return client.AddStage(ctx, name, now)
+	})
+}
+
+// AddData implements twchartClient.
+func (m multiTWChartClient) AddData(ctx context.Context, data []twchart.Data) error {
+	return m.each(func(client twchartClient) error {
+		return client.AddData(ctx, data)
+	})
+}
+
+// Done implements twchartClient.
+func (m multiTWChartClient) Done(ctx context.Context, now time.Time) error {
+	return m.each(func(client twchartClient) error {
+		return client.Done(ctx, now)
+	})
+}
+
+// RecordCommand passes the command to clients that record commands
+func (m multiTWChartClient) RecordCommand(now time.Time, result CommandResult, err error) {
+	for _, client := range m {
+		if recorder, ok := client.(commandRecorder); ok {
+			recorder.RecordCommand(now, result, err)
+		}
+	}
+}
+
+// each calls f for every client, even if some of them fail
+func (m multiTWChartClient) each(f func(twchartClient) error) error {
+	var errs []error
+	for _, client := range m {
+		err := f(client)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,381 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/calvinmclean/autoroast/twchart"
+)
+
+// DefaultTWChartQueueFile is where TWChart events are saved until they are sent
+const DefaultTWChartQueueFile = ".twchart_queue"
+
+const (
+	twchartRequestTimeout = 10 * time.Second
+	twchartMinBackoff     = time.Second
+	twchartMaxBackoff     = time.Minute
+)
+
+type queuedEventType string
+
+const (
+	queuedCreateSession queuedEventType = "create"
+	queuedStartTime     queuedEventType = "start"
+	queuedEvent         queuedEventType = "event"
+	queuedStage         queuedEventType = "stage"
+	queuedData          queuedEventType = "data"
+	queuedDone          queuedEventType = "done"
+)
+
+// queuedTWChartEvent is a TWChart request with the time it originally happened
+type queuedTWChartEvent struct {
+	Type   queuedEventType
+	Name   string         `json:",omitempty"`
+	Probes twchart.Probes `json:",omitempty"`
+	Data   []twchart.Data `json:",omitempty"`
+	Time   time.Time
+}
+
+// twchartQueueState is saved to the queue file so events are not lost if the app is closed while TWChart is unreachable
+type twchartQueueState struct {
+	// SessionID is the TWChart session that pending events are added to
+	SessionID string
+	Pending   []queuedTWChartEvent
+}
+
+// twchartQueue wraps a twchartClient so requests return immediately and are sent in order by a background
+// goroutine. Requests that fail are retried with backoff until TWChart is reachable again. Requests that TWChart
+// rejects, like one for a deleted session, are dropped so they don't hold up the rest of the queue
+type twchartQueue struct {
+	client twchartClient
+	// path is where the state is saved. The queue is only kept in memory if it is empty
+	path string
+
+	mu    sync.Mutex
+	state twchartQueueState
+	// resumed is true when the client is using state.SessionID. It is false after loading a saved queue
+	// because the client does not know the session yet
+	resumed bool
+	offline bool
+	// droppingData is true after data is dropped because it can't be added to the session, so it is only logged once
+	droppingData bool
+
+	wake    chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	logf       func(format string, a ...any)
+}
+
+var _ twchartClient = &twchartQueue{}
+
+// newTWChartQueue loads pending events from the queue file and starts sending them in the background
+func newTWChartQueue(client twchartClient, path string) (*twchartQueue, error) {
+	q := &twchartQueue{
+		client:     client,
+		path:       path,
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		minBackoff: twchartMinBackoff,
+		maxBackoff: twchartMaxBackoff,
+		logf: func(format string, a ...any) {
+			fmt.Printf(format+"\n", a...)
+		},
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("error reading TWChart queue: %w", err)
+		default:
+			err = json.Unmarshal(data, &q.state)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing TWChart queue: %w", err)
+			}
+		}
+	}
+
+	return q, nil
+}
+
+// start sends pending events in the background until Close is called
+func (q *twchartQueue) start() {
+	go q.run()
+	q.notify()
+}
+
+// Close stops sending events. Pending events stay in the queue file and are sent the next time it is loaded
+func (q *twchartQueue) Close() {
+	q.once.Do(func() {
+		close(q.stop)
+	})
+	<-q.stopped
+}
+
+// SessionID returns the TWChart session's ID, or an empty string if it has not been created yet
+func (q *twchartQueue) SessionID() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.state.SessionID
+}
+
+// Pending returns the number of events that have not been sent
+func (q *twchartQueue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.state.Pending)
+}
+
+// CreateSession queues creating the session. The ID is not known until it is sent, so an empty string is
+// returned and SessionID can be used later
+func (q *twchartQueue) CreateSession(ctx context.Context, beanName string, probes twchart.Probes) (string, error) {
+	return "", q.enqueue(queuedTWChartEvent{Type: queuedCreateSession, Name: beanName, Probes: probes, Time: time.Now()})
+}
+
+// ResumeSession sets the session that new events are added to. If the queue still has events for an
+// unfinished session, that session is used instead
+func (q *twchartQueue) ResumeSession(ctx context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if id == "" || id == q.state.SessionID || len(q.state.Pending) > 0 {
+		return nil
+	}
+
+	q.state.SessionID = id
+	q.resumed = false
+	return q.save()
+}
+
+// SetStartTime implements twchartClient.
+func (q *twchartQueue) SetStartTime(ctx context.Context, startTime time.Time) error {
+	return q.enqueue(queuedTWChartEvent{Type: queuedStartTime, Time: startTime})
+}
+
+// AddEvent implements twchartClient.
+func (q *twchartQueue) AddEvent(ctx context.Context, note string, now time.Time) error {
+	return q.enqueue(queuedTWChartEvent{Type: queuedEvent, Name: note, Time: now})
+}
+
+// AddStage implements twchartClient.
+func (q *twchartQueue) AddStage(ctx context.Context, name string, now time.Time) error {
+	return q.enqueue(queuedTWChartEvent{Type: queuedStage, Name: name, Time: now})
+}
+
+// AddData implements twchartClient.
+func (q *twchartQueue) AddData(ctx context.Context, data []twchart.Data) error {
+	return q.enqueue(queuedTWChartEvent{Type: queuedData, Data: data, Time: time.Now()})
+}
+
+// Done implements twchartClient.
+func (q *twchartQueue) Done(ctx context.Context, now time.Time) error {
+	return q.enqueue(queuedTWChartEvent{Type: queuedDone, Time: now})
+}
+
+// enqueue adds the event and saves the queue. The event is still sent if saving fails, but it could be lost
+// if the app is closed before then
+func (q *twchartQueue) enqueue(e queuedTWChartEvent) error {
+	q.mu.Lock()
+	q.state.Pending = append(q.state.Pending, e)
+	err := q.save()
+	q.mu.Unlock()
+
+	q.notify()
+	return err
+}
+
+func (q *twchartQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// save writes the queue file, or removes it if there is nothing left to send. It must be called with mu locked
+func (q *twchartQueue) save() error {
+	if q.path == "" {
+		return nil
+	}
+
+	if len(q.state.Pending) == 0 && q.state.SessionID == "" {
+		err := os.Remove(q.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing TWChart queue: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(q.state)
+	if err != nil {
+		return fmt.Errorf("error encoding TWChart queue: %w", err)
+	}
+	err = writeFileAtomic(q.path, data)
+	if err != nil {
+		return fmt.Errorf("error saving TWChart queue: %w", err)
+	}
+	return nil
+}
+
+func (q *twchartQueue) run() {
+	defer close(q.stopped)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-q.stop
+		cancel()
+	}()
+
+	backoff := q.minBackoff
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		}
+
+		for {
+			err := q.sendPending(ctx)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			if !q.offline {
+				q.offline = true
+				q.logf("TWChart is unavailable, events are queued and retried in the background: %v", err)
+			}
+
+			select {
+			case <-q.stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, q.maxBackoff)
+		}
+
+		if q.offline {
+			q.offline = false
+			q.logf("TWChart is available again, sent queued events")
+		}
+		backoff = q.minBackoff
+	}
+}
+
+// sendPending sends events in order until the queue is empty or a request fails
+func (q *twchartQueue) sendPending(ctx context.Context) error {
+	for {
+		q.mu.Lock()
+		if len(q.state.Pending) == 0 {
+			q.mu.Unlock()
+			return nil
+		}
+		e := q.state.Pending[0]
+		sessionID := q.state.SessionID
+		resumed := q.resumed
+		q.mu.Unlock()
+
+		if e.Type != queuedCreateSession && sessionID == "" {
+			q.logf("Dropping TWChart %s event without a session: %q", e.Type, e.Name)
+			q.finish(func() {})
+			continue
+		}
+
+		if e.Type != queuedCreateSession && !resumed {
+			err := q.withTimeout(ctx, func(ctx context.Context) error {
+				return q.client.ResumeSession(ctx, sessionID)
+			})
+			if twchart.IsPermanent(err) {
+				// the session can't be used, so its events are dropped until another session is created
+				q.logf("Error: dropping TWChart session %s: %v", sessionID, err)
+				q.mu.Lock()
+				q.state.SessionID = ""
+				q.mu.Unlock()
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error resuming session: %w", err)
+			}
+			q.mu.Lock()
+			q.resumed = true
+			q.mu.Unlock()
+		}
+
+		var createdID string
+		err := q.withTimeout(ctx, func(ctx context.Context) error {
+			var err error
+			switch e.Type {
+			case queuedCreateSession:
+				createdID, err = q.client.CreateSession(ctx, e.Name, e.Probes)
+			case queuedStartTime:
+				err = q.client.SetStartTime(ctx, e.Time)
+			case queuedEvent:
+				err = q.client.AddEvent(ctx, e.Name, e.Time)
+			case queuedStage:
+				err = q.client.AddStage(ctx, e.Name, e.Time)
+			case queuedData:
+				err = q.client.AddData(ctx, e.Data)
+			case queuedDone:
+				err = q.client.Done(ctx, e.Time)
+			}
+			return err
+		})
+		if e.Type == queuedData && errors.Is(err, twchart.ErrNotLatestSession) {
+			// retrying won't help, so the data is dropped instead of holding up the events after it
+			if !q.droppingData {
+				q.droppingData = true
+				q.logf("Dropping TWChart data: %v", err)
+			}
+			q.finish(func() {})
+			continue
+		}
+		if twchart.IsPermanent(err) {
+			q.logf("Error: dropping TWChart %s %q: %v", e.Type, e.Name, err)
+		} else if err != nil {
+			return fmt.Errorf("error sending %s: %w", e.Type, err)
+		}
+
+		q.finish(func() {
+			switch e.Type {
+			case queuedCreateSession:
+				q.state.SessionID = createdID
+				q.resumed = true
+			case queuedDone:
+				q.state.SessionID = ""
+				q.resumed = false
+			}
+		})
+	}
+}
+
+// finish removes the first pending event after applying its changes to the state and saves the queue
+func (q *twchartQueue) finish(apply func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	apply()
+	q.state.Pending = q.state.Pending[1:]
+	err := q.save()
+	if err != nil {
+		q.logf("Error: %v", err)
+	}
+}
+
+func (q *twchartQueue) withTimeout(ctx context.Context, f func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, twchartRequestTimeout)
+	defer cancel()
+	return f(ctx)
+}
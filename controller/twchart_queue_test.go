@@ -0,0 +1,313 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/calvinmclean/autoroast/twchart"
+)
+
+var errUnreachable = errors.New("connection refused")
+
+// flakyTWChartClient records requests and fails all of them while down is true
+type flakyTWChartClient struct {
+	mu    sync.Mutex
+	down  bool
+	sent  []string
+	times []time.Time
+	// dataErr is returned by AddData when it isn't down
+	dataErr error
+	// errs maps requests to an error that is returned instead of recording them
+	errs map[string]error
+}
+
+func (f *flakyTWChartClient) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *flakyTWChartClient) record(request string, t time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errUnreachable
+	}
+	if err, ok := f.errs[request]; ok {
+		return err
+	}
+	f.sent = append(f.sent, request)
+	f.times = append(f.times, t)
+	return nil
+}
+
+func (f *flakyTWChartClient) requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.sent...)
+}
+
+func (f *flakyTWChartClient) CreateSession(ctx context.Context, beanName string, probes twchart.Probes) (string, error) {
+	err := f.record("create "+beanName, time.Time{})
+	if err != nil {
+		return "", err
+	}
+	return "session-id", nil
+}
+
+func (f *flakyTWChartClient) ResumeSession(ctx context.Context, id string) error {
+	return f.record("resume "+id, time.Time{})
+}
+
+func (f *flakyTWChartClient) SetStartTime(ctx context.Context, startTime time.Time) error {
+	return f.record("start", startTime)
+}
+
+func (f *flakyTWChartClient) AddEvent(ctx context.Context, note string, now time.Time) error {
+	return f.record("event "+note, now)
+}
+
+func (f *flakyTWChartClient) AddStage(ctx context.Context, name string, now time.Time) error {
+	return f.record("stage "+name, now)
+}
+
+func (f *flakyTWChartClient) AddData(ctx context.Context, data []twchart.Data) error {
+	f.mu.Lock()
+	dataErr := f.dataErr
+	f.mu.Unlock()
+	if dataErr != nil {
+		return dataErr
+	}
+	return f.record(fmt.Sprintf("data %d", len(data)), time.Time{})
+}
+
+func (f *flakyTWChartClient) Done(ctx context.Context, now time.Time) error {
+	return f.record("done", now)
+}
+
+func newTestTWChartQueue(t *testing.T, client twchartClient, path string) *twchartQueue {
+	t.Helper()
+
+	q, err := newTWChartQueue(client, path)
+	if err != nil {
+		t.Fatalf("newTWChartQueue() error = %v", err)
+	}
+	q.minBackoff = time.Millisecond
+	q.maxBackoff = 5 * time.Millisecond
+	q.logf = t.Logf
+	q.start()
+	t.Cleanup(q.Close)
+
+	return q
+}
+
+func waitForEmptyQueue(t *testing.T, q *twchartQueue) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for q.Pending() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue still has %d pending events", q.Pending())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTWChartQueueRetriesInOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultTWChartQueueFile)
+	client := &flakyTWChartClient{down: true}
+	q := newTestTWChartQueue(t, client, path)
+
+	ctx := context.Background()
+	eventTime := time.Now().Add(-time.Minute)
+	_, err := q.CreateSession(ctx, "Test Bean", nil)
+	if err != nil {
+		t.Fatalf("unexpected error queueing session: %v", err)
+	}
+	for _, err := range []error{
+		q.AddEvent(ctx, "F5", eventTime),
+		q.AddStage(ctx, "Roasting", eventTime.Add(time.Second)),
+		q.AddData(ctx, []twchart.Data{{Time: eventTime, ProbeData: []float64{75, 300}}}),
+	} {
+		if err != nil {
+			t.Fatalf("unexpected error queueing event: %v", err)
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if got := client.requests(); len(got) != 0 {
+		t.Errorf("requests while down = %q, want none", got)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("queue file was not saved: %v", err)
+	}
+
+	client.setDown(false)
+	waitForEmptyQueue(t, q)
+
+	want := []string{"create Test Bean", "event F5", "stage Roasting", "data 1"}
+	if got := client.requests(); !equalStrings(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+	if !client.times[1].Equal(eventTime) {
+		t.Errorf("event time = %v, want original time %v", client.times[1], eventTime)
+	}
+	if got := q.SessionID(); got != "session-id" {
+		t.Errorf("SessionID() = %q, want session-id", got)
+	}
+}
+
+func TestTWChartQueueDropsDataForOtherSessions(t *testing.T) {
+	client := &flakyTWChartClient{dataErr: fmt.Errorf("%w: data for session-id would be added to other", twchart.ErrNotLatestSession)}
+	q := newTestTWChartQueue(t, client, "")
+
+	ctx := context.Background()
+	if _, err := q.CreateSession(ctx, "Test Bean", nil); err != nil {
+		t.Fatalf("unexpected error queueing session: %v", err)
+	}
+	for _, err := range []error{
+		q.AddData(ctx, []twchart.Data{{Time: time.Now(), ProbeData: []float64{75, 300}}}),
+		q.AddEvent(ctx, "F5", time.Now()),
+		q.AddData(ctx, []twchart.Data{{Time: time.Now(), ProbeData: []float64{75, 301}}}),
+		q.AddStage(ctx, "Roasting", time.Now()),
+	} {
+		if err != nil {
+			t.Fatalf("unexpected error queueing event: %v", err)
+		}
+	}
+	waitForEmptyQueue(t, q)
+
+	// the data isn't retried, so the events after it are still sent
+	want := []string{"create Test Bean", "event F5", "stage Roasting"}
+	if got := client.requests(); !equalStrings(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+}
+
+func TestTWChartQueueDropsRejectedEvents(t *testing.T) {
+	client := &flakyTWChartClient{errs: map[string]error{
+		"event bad": &twchart.StatusError{StatusCode: http.StatusBadRequest},
+		"event F6":  &twchart.StatusError{StatusCode: http.StatusBadGateway},
+	}}
+	q := newTestTWChartQueue(t, client, "")
+
+	ctx := context.Background()
+	if _, err := q.CreateSession(ctx, "Test Bean", nil); err != nil {
+		t.Fatalf("unexpected error queueing session: %v", err)
+	}
+	for _, note := range []string{"bad", "F5", "F6"} {
+		if err := q.AddEvent(ctx, note, time.Now()); err != nil {
+			t.Fatalf("unexpected error queueing event: %v", err)
+		}
+	}
+
+	// the rejected event isn't retried, but the server error is until it succeeds
+	deadline := time.Now().Add(time.Second)
+	for len(client.requests()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("requests = %q, want the events after the rejected one to be sent", client.requests())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if q.Pending() != 1 {
+		t.Errorf("pending = %d, want the event that failed with a server error", q.Pending())
+	}
+
+	client.mu.Lock()
+	delete(client.errs, "event F6")
+	client.mu.Unlock()
+	waitForEmptyQueue(t, q)
+
+	want := []string{"create Test Bean", "event F5", "event F6"}
+	if got := client.requests(); !equalStrings(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+}
+
+func TestTWChartQueueDropsDeletedSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultTWChartQueueFile)
+	saved := `{"SessionID":"deleted","Pending":[{"Type":"event","Name":"F5"},{"Type":"done"},{"Type":"create","Name":"Next Bean"},{"Type":"event","Name":"F6"}]}`
+	if err := os.WriteFile(path, []byte(saved), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &flakyTWChartClient{errs: map[string]error{
+		"resume deleted": &twchart.StatusError{StatusCode: http.StatusNotFound},
+	}}
+	q := newTestTWChartQueue(t, client, path)
+	waitForEmptyQueue(t, q)
+
+	// the deleted session's events are dropped and the next session is still created
+	want := []string{"create Next Bean", "event F6"}
+	if got := client.requests(); !equalStrings(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+}
+
+func TestTWChartQueueSendsSavedEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultTWChartQueueFile)
+	ctx := context.Background()
+
+	client := &flakyTWChartClient{}
+	q := newTestTWChartQueue(t, client, path)
+	_, _ = q.CreateSession(ctx, "Test Bean", nil)
+	waitForEmptyQueue(t, q)
+
+	// TWChart goes down and the app is closed before the events are sent
+	client.setDown(true)
+	_ = q.AddEvent(ctx, "F5", time.Now())
+	_ = q.Done(ctx, time.Now())
+	q.Close()
+
+	restarted := &flakyTWChartClient{}
+	q = newTestTWChartQueue(t, restarted, path)
+	waitForEmptyQueue(t, q)
+
+	want := []string{"resume session-id", "event F5", "done"}
+	if got := restarted.requests(); !equalStrings(got, want) {
+		t.Errorf("requests after restarting = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("queue file still exists after the session is done: %v", err)
+	}
+}
+
+type failingTWChartClient struct {
+	noopTWChartClient
+}
+
+func (failingTWChartClient) AddEvent(ctx context.Context, note string, now time.Time) error {
+	return errUnreachable
+}
+
+func TestRunSendsCommandsWhenTWChartFails(t *testing.T) {
+	port := &mockPort{}
+	c := &Controller{
+		config: Config{
+			SessionName: "test",
+		},
+		twchartClient: failingTWChartClient{},
+		port:          port,
+	}
+
+	var output bytes.Buffer
+	if err := c.Run(context.Background(), strings.NewReader("F5\nP6\n"), &output); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if got, want := port.commands, []string{"F5", "P6"}; !equalStrings(got, want) {
+		t.Errorf("port commands = %q, want %q", got, want)
+	}
+	if !strings.Contains(output.String(), "Error: connection refused") {
+		t.Errorf("output = %q, want TWChart error", output.String())
+	}
+}
@@ -2,15 +2,28 @@ package commands
 
 import (
 	"errors"
+	"io"
+	"strconv"
 	"time"
 
 	"github.com/calvinmclean/autoroast"
 )
 
+const (
+	frameTimeout = time.Second
+	// resumeInputSize is the number of digits in ResumeCommand's elapsed seconds
+	resumeInputSize = 5
+)
+
+var errFrameTimeout = errors.New("timed out reading frame")
+
 type Command struct {
-	Flag        byte
-	InputSize   uint
-	Run         func(Device, []byte) error
+	Flag      byte
+	InputSize uint
+	Run       func(Device, []byte) error
+	// Query is used instead of Run for commands that respond with data. The output is printed or
+	// returned in the response payload
+	Query       func(Device, []byte) ([]byte, error)
 	Description string
 }
 
@@ -23,14 +36,22 @@ type Device interface {
 	GoToMode(autoroast.ControlMode) bool
 	ClickButton()
 	Start() error
+	Resume(time.Duration) error
 	Debug()
 	Verbose()
 	IncreaseTime()
 	Settings() (uint, uint)
+	Status() autoroast.DeviceStatus
+	CalibrationValues() autoroast.CalibrationValues
+	Calibration(autoroast.CalibrationField) (float64, bool)
+	SetCalibration(autoroast.CalibrationField, float64) error
 	FixFan(uint)
 	FixPower(uint)
 	MicroStep(int32)
 	Move(int32)
+	Temperature() (autoroast.TemperatureReading, error)
+	SetTemperatureStream(time.Duration)
+	TemperatureStream() time.Duration
 
 	// I/O
 	ReadByte() (byte, error)
@@ -113,6 +134,18 @@ var (
 		},
 		Description: "Start roasting. This sets the timer to track durations of each change.",
 	}
+	ResumeCommand = &Command{
+		Flag:      'E',
+		InputSize: resumeInputSize,
+		Run: func(c Device, b []byte) error {
+			seconds, err := strconv.ParseUint(string(b), 10, 32)
+			if err != nil {
+				return errors.New("invalid input: " + string(b))
+			}
+			return c.Resume(time.Duration(seconds) * time.Second)
+		},
+		Description: "Start roasting with the timer at an elapsed time to continue after a reset. Input: 5 digit seconds like 00090.",
+	}
 	DebugCommand = &Command{
 		Flag:      'D',
 		InputSize: 0,
@@ -122,6 +155,43 @@ var (
 		},
 		Description: "Print the current state.",
 	}
+	StatusCommand = &Command{
+		Flag:      'Q',
+		InputSize: 0,
+		Query: func(c Device, b []byte) ([]byte, error) {
+			return c.Status().MarshalText()
+		},
+		Description: "Print the current state in a machine-readable format.",
+	}
+	GetCalibrationCommand = &Command{
+		Flag:      'k',
+		InputSize: 1,
+		Query: func(c Device, b []byte) ([]byte, error) {
+			field := autoroast.CalibrationField(b[0])
+			if field == autoroast.CalibrationAll {
+				return c.CalibrationValues().MarshalText()
+			}
+
+			v, ok := c.Calibration(field)
+			if !ok {
+				return nil, errors.New("unknown calibration field: " + string(b))
+			}
+			return autoroast.CalibrationValues{field: v}.MarshalText()
+		},
+		Description: "Print a calibration value. Input: field (b, c, p, r, s, d, x) or '*' for all.",
+	}
+	SetCalibrationCommand = &Command{
+		Flag:      'K',
+		InputSize: 1 + autoroast.CalibrationValueSize,
+		Run: func(c Device, b []byte) error {
+			v, err := autoroast.ParseCalibrationValue(b[1:])
+			if err != nil {
+				return err
+			}
+			return c.SetCalibration(autoroast.CalibrationField(b[0]), v)
+		},
+		Description: "Set and save a calibration value. Input: field (b, c, p, r, s, d, x), then a 6 character value like 30.000.",
+	}
 	VerboseCommand = &Command{
 		Flag:      'V',
 		InputSize: 0,
@@ -267,6 +337,41 @@ var (
 		},
 		Description: "Move stepper motor by microsteps. Use left and right arrow keys.",
 	}
+	TemperatureCommand = &Command{
+		Flag:      'B',
+		InputSize: 0,
+		Query: func(c Device, b []byte) ([]byte, error) {
+			reading, err := c.Temperature()
+			if err != nil {
+				return nil, err
+			}
+			return reading.MarshalText()
+		},
+		Description: "Print the bean temperature in a machine-readable format.",
+	}
+	TemperatureStreamCommand = &Command{
+		Flag:      'b',
+		InputSize: 1,
+		Run: func(c Device, b []byte) error {
+			if b[0] < '0' || b[0] > '9' {
+				return errors.New("invalid input: " + string(b))
+			}
+			seconds := b[0] - '0'
+			if seconds == 0 {
+				c.SetTemperatureStream(0)
+				return nil
+			}
+
+			// make sure the thermometer works before streaming
+			_, err := c.Temperature()
+			if err != nil {
+				return err
+			}
+			c.SetTemperatureStream(time.Duration(seconds) * time.Second)
+			return nil
+		},
+		Description: "Stream the bean temperature while idle. Input: seconds between readings (1-9) or 0 to stop.",
+	}
 	HelpCommand = &Command{
 		Flag:        'H',
 		InputSize:   0,
@@ -287,6 +392,13 @@ var (
 	}
 )
 
+func (c *Command) run(d Device, input []byte) ([]byte, error) {
+	if c.Query != nil {
+		return c.Query(d, input)
+	}
+	return nil, c.Run(d, input)
+}
+
 func b2i(b byte) uint {
 	v := uint(b - '0')
 	if v < 1 || v > 9 {
@@ -301,7 +413,11 @@ var commands = []*Command{
 	SetModeCommand,
 	ClickCommand,
 	StartCommand,
+	ResumeCommand,
 	DebugCommand,
+	StatusCommand,
+	GetCalibrationCommand,
+	SetCalibrationCommand,
 	VerboseCommand,
 	IncreaseTimeCommand,
 	FixFanCommand,
@@ -311,8 +427,25 @@ var commands = []*Command{
 	FullRevolutionCommand,
 	InitCommand,
 	MicroStepCommand,
+	TemperatureCommand,
+	TemperatureStreamCommand,
+}
+
+// Lookup returns the command with the flag
+func Lookup(flag byte) (*Command, bool) {
+	if flag == HelpCommand.Flag {
+		return HelpCommand, true
+	}
+	for _, cmd := range commands {
+		if cmd.Flag == flag {
+			return cmd, true
+		}
+	}
+	return nil, false
 }
 
+// Run reads and runs commands until the Device's input returns io.EOF. While there is no input, the temperature
+// stream is written if it is on
 func Run(d Device) {
 	cmdMap := map[byte]*Command{
 		HelpCommand.Flag: HelpCommand,
@@ -322,9 +455,19 @@ func Run(d Device) {
 		cmdMap[cmd.Flag] = cmd
 	}
 
+	var lastReading time.Time
 	for {
 		cmdIn, err := d.ReadByte()
+		if err == io.EOF {
+			return
+		}
 		if err != nil {
+			lastReading = streamTemperature(d, lastReading)
+			continue
+		}
+
+		if cmdIn == autoroast.FrameStart {
+			runFrame(d, cmdMap)
 			continue
 		}
 
@@ -336,6 +479,9 @@ func Run(d Device) {
 		in := make([]byte, cmd.InputSize)
 		for i := 0; i < int(cmd.InputSize); {
 			b, err := d.ReadByte()
+			if err == io.EOF {
+				return
+			}
 			if err != nil {
 				continue
 			}
@@ -344,13 +490,130 @@ func Run(d Device) {
 			i++
 		}
 
-		err = cmd.Run(d, in)
+		out, err := cmd.run(d, in)
 		if err != nil {
 			println("error:", err.Error())
 		}
+		if len(out) > 0 {
+			println(string(out))
+		}
 		err = d.WriteByte(autoroast.TerminationChar)
 		if err != nil {
 			println("error:", err.Error())
 		}
 	}
 }
+
+// runFrame reads a framed request, runs its commands, and writes a framed response
+func runFrame(d Device, cmdMap map[byte]*Command) {
+	req, err := autoroast.DecodeRequest(frameByteReader(d))
+	if err != nil {
+		writeResponse(d, autoroast.Response{
+			Seq:     req.Seq,
+			Status:  autoroast.StatusInvalidFrame,
+			Payload: []byte(err.Error()),
+		})
+		return
+	}
+
+	status, out, err := runInput(d, cmdMap, req.Command)
+	resp := autoroast.Response{Seq: req.Seq, Status: status, Payload: out}
+	if err != nil {
+		resp.Payload = []byte(err.Error())
+	}
+	writeResponse(d, resp)
+}
+
+// runInput runs each command in the input in order and stops at the first error. Whitespace between
+// commands is ignored. The output of all query commands is combined
+func runInput(d Device, cmdMap map[byte]*Command, in []byte) (autoroast.Status, []byte, error) {
+	var output []byte
+	for i := 0; i < len(in); {
+		flag := in[i]
+		i++
+
+		switch flag {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+
+		cmd, ok := cmdMap[flag]
+		if !ok {
+			return autoroast.StatusUnknownCommand, output, errors.New("unknown command: " + string(flag))
+		}
+
+		end := i + int(cmd.InputSize)
+		if end > len(in) {
+			return autoroast.StatusError, output, errors.New("missing input for command: " + string(flag))
+		}
+
+		out, err := cmd.run(d, in[i:end])
+		if err != nil {
+			return autoroast.StatusError, output, err
+		}
+		if len(out) > 0 && len(output) > 0 {
+			output = append(output, '\n')
+		}
+		output = append(output, out...)
+		i = end
+	}
+
+	return autoroast.StatusOK, output, nil
+}
+
+// frameByteReader reads bytes for a frame and gives up if the rest of the frame does not arrive
+func frameByteReader(d Device) func() (byte, error) {
+	return func() (byte, error) {
+		deadline := time.Now().Add(frameTimeout)
+		for {
+			b, err := d.ReadByte()
+			if err == nil {
+				return b, nil
+			}
+			if err == io.EOF || time.Now().After(deadline) {
+				return 0, errFrameTimeout
+			}
+		}
+	}
+}
+
+// streamTemperature writes a temperature reading if the stream is on and its interval has passed since the last
+// reading. It returns when the last reading was written
+func streamTemperature(d Device, last time.Time) time.Time {
+	interval := d.TemperatureStream()
+	if interval <= 0 || time.Since(last) < interval {
+		return last
+	}
+
+	now := time.Now()
+	reading, err := d.Temperature()
+	if err != nil {
+		println("error:", err.Error())
+		return now
+	}
+
+	out, _ := reading.MarshalText()
+	writeLine(d, autoroast.TemperaturePrefix+string(out))
+	return now
+}
+
+// writeLine writes a line of output to the Device's serial connection
+func writeLine(d Device, line string) {
+	for _, b := range []byte(line + "\r\n") {
+		err := d.WriteByte(b)
+		if err != nil {
+			println("error:", err.Error())
+			return
+		}
+	}
+}
+
+func writeResponse(d Device, resp autoroast.Response) {
+	for _, b := range resp.Encode() {
+		err := d.WriteByte(b)
+		if err != nil {
+			println("error:", err.Error())
+			return
+		}
+	}
+}
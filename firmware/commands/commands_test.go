@@ -0,0 +1,305 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calvinmclean/autoroast"
+)
+
+var errNoInput = errors.New("no input")
+
+// fakeDevice records the calls made by commands and reads input until it runs out. Then, it reports no input
+// idle times before returning io.EOF
+type fakeDevice struct {
+	in    []byte
+	idle  int
+	out   bytes.Buffer
+	calls []string
+
+	fan, power uint
+	started    bool
+
+	temperature       autoroast.TemperatureReading
+	temperatureErr    error
+	temperatureStream time.Duration
+}
+
+var _ Device = &fakeDevice{}
+
+func (d *fakeDevice) record(call string) {
+	d.calls = append(d.calls, call)
+}
+
+func (d *fakeDevice) MoveFan(n int32) { d.record("MoveFan(" + strconv.Itoa(int(n)) + ")") }
+func (d *fakeDevice) SetFan(n uint) {
+	d.fan = n
+	d.record("SetFan(" + strconv.Itoa(int(n)) + ")")
+}
+func (d *fakeDevice) MovePower(n int32) { d.record("MovePower(" + strconv.Itoa(int(n)) + ")") }
+func (d *fakeDevice) SetPower(n uint) {
+	d.power = n
+	d.record("SetPower(" + strconv.Itoa(int(n)) + ")")
+}
+func (d *fakeDevice) GoToMode(m autoroast.ControlMode) bool {
+	d.record("GoToMode(" + m.String() + ")")
+	return true
+}
+func (d *fakeDevice) ClickButton() { d.record("ClickButton()") }
+func (d *fakeDevice) Start() error {
+	d.started = true
+	d.record("Start()")
+	return nil
+}
+func (d *fakeDevice) Resume(elapsed time.Duration) error {
+	d.started = true
+	d.record("Resume(" + elapsed.String() + ")")
+	return nil
+}
+func (d *fakeDevice) Debug()                 {}
+func (d *fakeDevice) Verbose()               {}
+func (d *fakeDevice) IncreaseTime()          { d.record("IncreaseTime()") }
+func (d *fakeDevice) Settings() (uint, uint) { return d.fan, d.power }
+func (d *fakeDevice) Status() autoroast.DeviceStatus {
+	return autoroast.DeviceStatus{Fan: d.fan, Power: d.power, Started: d.started}
+}
+func (d *fakeDevice) CalibrationValues() autoroast.CalibrationValues { return nil }
+func (d *fakeDevice) Calibration(autoroast.CalibrationField) (float64, bool) {
+	return 0, false
+}
+func (d *fakeDevice) SetCalibration(autoroast.CalibrationField, float64) error { return nil }
+func (d *fakeDevice) FixFan(n uint)                                            { d.fan = n }
+func (d *fakeDevice) FixPower(n uint)                                          { d.power = n }
+func (d *fakeDevice) MicroStep(int32)                                          {}
+func (d *fakeDevice) Move(int32)                                               {}
+func (d *fakeDevice) Temperature() (autoroast.TemperatureReading, error) {
+	return d.temperature, d.temperatureErr
+}
+func (d *fakeDevice) SetTemperatureStream(interval time.Duration) { d.temperatureStream = interval }
+func (d *fakeDevice) TemperatureStream() time.Duration            { return d.temperatureStream }
+
+func (d *fakeDevice) ReadByte() (byte, error) {
+	if len(d.in) == 0 {
+		if d.idle > 0 {
+			d.idle--
+			return 0, errNoInput
+		}
+		return 0, io.EOF
+	}
+	b := d.in[0]
+	d.in = d.in[1:]
+	return b, nil
+}
+
+func (d *fakeDevice) WriteByte(b byte) error {
+	return d.out.WriteByte(b)
+}
+
+// response decodes the first framed response written by the device
+func (d *fakeDevice) response(t *testing.T) autoroast.Response {
+	t.Helper()
+
+	out := d.out.Bytes()
+	start := bytes.IndexByte(out, autoroast.FrameStart)
+	if start < 0 {
+		t.Fatalf("no response in output %q", out)
+	}
+
+	resp, err := autoroast.DecodeResponse(bytes.NewReader(out[start+1:]).ReadByte)
+	if err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	return resp
+}
+
+func runFramed(t *testing.T, command string) *fakeDevice {
+	t.Helper()
+
+	req, err := autoroast.Request{Seq: 7, Command: []byte(command)}.Encode()
+	if err != nil {
+		t.Fatalf("error encoding %q: %v", command, err)
+	}
+
+	d := &fakeDevice{in: req}
+	Run(d)
+	return d
+}
+
+func TestRunFramed(t *testing.T) {
+	t.Run("MultipleCommands", func(t *testing.T) {
+		d := runFramed(t, "F5 P6")
+
+		if len(d.calls) != 2 || d.calls[0] != "SetFan(5)" || d.calls[1] != "SetPower(6)" {
+			t.Errorf("calls = %v, want [SetFan(5) SetPower(6)]", d.calls)
+		}
+		resp := d.response(t)
+		if resp.Seq != 7 || resp.Status != autoroast.StatusOK {
+			t.Errorf("response = %+v, want OK for seq 7", resp)
+		}
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		d := runFramed(t, "I46Q")
+
+		resp := d.response(t)
+		var status autoroast.DeviceStatus
+		if err := status.UnmarshalText(resp.Payload); err != nil {
+			t.Fatalf("error parsing status %q: %v", resp.Payload, err)
+		}
+		if status.Fan != 4 || status.Power != 6 {
+			t.Errorf("status = %+v, want F4/P6", status)
+		}
+	})
+
+	t.Run("StopsAtError", func(t *testing.T) {
+		d := runFramed(t, "F0P6")
+
+		if len(d.calls) != 0 {
+			t.Errorf("calls = %v, want none", d.calls)
+		}
+		resp := d.response(t)
+		if resp.Status != autoroast.StatusError || string(resp.Payload) != "invalid input: 0" {
+			t.Errorf("response = %v %q, want Error with invalid input", resp.Status, resp.Payload)
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		d := runFramed(t, "I46E00090")
+
+		if len(d.calls) != 1 || d.calls[0] != "Resume(1m30s)" {
+			t.Errorf("calls = %v, want [Resume(1m30s)]", d.calls)
+		}
+		if resp := d.response(t); resp.Status != autoroast.StatusOK {
+			t.Errorf("status = %v, want OK", resp.Status)
+		}
+
+		d = runFramed(t, "E+0090")
+		if resp := d.response(t); resp.Status != autoroast.StatusError || string(resp.Payload) != "invalid input: +0090" {
+			t.Errorf("response = %v %q, want Error with invalid input", resp.Status, resp.Payload)
+		}
+	})
+
+	t.Run("UnknownCommand", func(t *testing.T) {
+		d := runFramed(t, "F5X")
+
+		if len(d.calls) != 1 {
+			t.Errorf("calls = %v, want only SetFan(5)", d.calls)
+		}
+		if resp := d.response(t); resp.Status != autoroast.StatusUnknownCommand {
+			t.Errorf("status = %v, want UnknownCommand", resp.Status)
+		}
+	})
+
+	t.Run("MissingInput", func(t *testing.T) {
+		d := runFramed(t, "F")
+
+		if resp := d.response(t); resp.Status != autoroast.StatusError {
+			t.Errorf("status = %v, want Error", resp.Status)
+		}
+	})
+
+	t.Run("IncompleteFrame", func(t *testing.T) {
+		req, err := autoroast.Request{Seq: 1, Command: []byte("F5")}.Encode()
+		if err != nil {
+			t.Fatalf("error encoding request: %v", err)
+		}
+
+		d := &fakeDevice{in: req[:len(req)-2]}
+		Run(d)
+
+		if len(d.calls) != 0 {
+			t.Errorf("calls = %v, want none", d.calls)
+		}
+		if resp := d.response(t); resp.Status != autoroast.StatusInvalidFrame {
+			t.Errorf("status = %v, want InvalidFrame", resp.Status)
+		}
+	})
+}
+
+func TestRunUnframed(t *testing.T) {
+	d := &fakeDevice{in: []byte("F+xS")}
+	Run(d)
+
+	if len(d.calls) != 2 || d.calls[0] != "MoveFan(1)" || d.calls[1] != "Start()" {
+		t.Errorf("calls = %v, want [MoveFan(1) Start()]", d.calls)
+	}
+	if got := bytes.Count(d.out.Bytes(), []byte{autoroast.TerminationChar}); got != 2 {
+		t.Errorf("wrote %d termination characters, want 2", got)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, flag := range []byte{'F', 'H', 'I', 'b', 0x1B} {
+		cmd, ok := Lookup(flag)
+		if !ok || cmd.Flag != flag {
+			t.Errorf("Lookup(%q) = %v, %v, want the command", flag, cmd, ok)
+		}
+	}
+	if _, ok := Lookup('X'); ok {
+		t.Error("Lookup('X') = true, want false")
+	}
+}
+
+func TestTemperature(t *testing.T) {
+	t.Run("Query", func(t *testing.T) {
+		d := &fakeDevice{temperature: autoroast.TemperatureReading{Elapsed: time.Minute, Bean: 350.25}}
+		req, err := autoroast.Request{Seq: 1, Command: []byte("B")}.Encode()
+		if err != nil {
+			t.Fatalf("error encoding request: %v", err)
+		}
+		d.in = req
+		Run(d)
+
+		resp := d.response(t)
+		if resp.Status != autoroast.StatusOK || string(resp.Payload) != "elapsed_ms=60000 bean=350.25" {
+			t.Errorf("response = %v %q, want the reading", resp.Status, resp.Payload)
+		}
+	})
+
+	t.Run("StreamWhileIdle", func(t *testing.T) {
+		d := &fakeDevice{in: []byte("b1"), idle: 3, temperature: autoroast.TemperatureReading{Bean: 200}}
+		Run(d)
+
+		if d.temperatureStream != time.Second {
+			t.Errorf("stream interval = %s, want 1s", d.temperatureStream)
+		}
+		// the first idle read writes a reading and the rest are before the interval passes
+		if got := strings.Count(d.out.String(), autoroast.TemperaturePrefix+"elapsed_ms=0 bean=200.00\r\n"); got != 1 {
+			t.Errorf("wrote %d readings in %q, want 1", got, d.out.String())
+		}
+	})
+
+	t.Run("StopStream", func(t *testing.T) {
+		d := &fakeDevice{in: []byte("b0"), idle: 3, temperatureStream: time.Second}
+		Run(d)
+
+		if d.temperatureStream != 0 {
+			t.Errorf("stream interval = %s, want 0", d.temperatureStream)
+		}
+		if strings.Contains(d.out.String(), autoroast.TemperaturePrefix) {
+			t.Errorf("wrote readings after stopping: %q", d.out.String())
+		}
+	})
+
+	t.Run("StreamWithoutThermometer", func(t *testing.T) {
+		d := &fakeDevice{temperatureErr: errors.New("no thermometer")}
+		req, err := autoroast.Request{Seq: 1, Command: []byte("b5")}.Encode()
+		if err != nil {
+			t.Fatalf("error encoding request: %v", err)
+		}
+		d.in = req
+		Run(d)
+
+		if resp := d.response(t); resp.Status != autoroast.StatusError || string(resp.Payload) != "no thermometer" {
+			t.Errorf("response = %v %q, want Error from the thermometer", resp.Status, resp.Payload)
+		}
+		if d.temperatureStream != 0 {
+			t.Errorf("stream interval = %s, want 0", d.temperatureStream)
+		}
+	})
+}
@@ -1,26 +1,22 @@
 package device
 
 import (
-	"machine"
+	"encoding/binary"
+	"errors"
+	"math"
 	"time"
 
-	"tinygo.org/x/drivers/servo"
+	"github.com/calvinmclean/autoroast"
 )
 
-// StepperConfig ...
+// StepperConfig has the output pins connected to the stepper driver and how to use them
 type StepperConfig struct {
-	Pins     [4]machine.Pin
+	Pins     [4]Pin
 	StepMode StepMode
 	// TODO: replace with target RPM and calculate StepDelay
 	StepDelay time.Duration
 }
 
-// ServoConfig has device-level values for setting up the Servo
-type ServoConfig struct {
-	Pin machine.Pin
-	PWM servo.PWM
-}
-
 // CalibrationConfig has values for the moving parts that depend on positioning and motor specifics
 type CalibrationConfig struct {
 	ServoBasePosition     int
@@ -31,3 +27,116 @@ type CalibrationConfig struct {
 	DelayAfterStepperMove time.Duration
 	BackstepRatio         float32
 }
+
+// calibrationMagic is stored at the start of encoded calibration data to identify it
+var calibrationMagic = [4]byte{'A', 'R', 'C', '1'}
+
+// calibrationSize is the length of the encoded CalibrationConfig: magic, 7 float32 fields, and a checksum
+const calibrationSize = len(calibrationMagic) + 7*4 + 1
+
+// CalibrationStore persists the CalibrationConfig so changes made at runtime are kept after a reboot
+type CalibrationStore interface {
+	Load() (CalibrationConfig, error)
+	Save(CalibrationConfig) error
+}
+
+// Get returns the value of a calibration field. Durations are in milliseconds
+func (c CalibrationConfig) Get(f autoroast.CalibrationField) (float64, bool) {
+	switch f {
+	case autoroast.CalibrationServoBasePosition:
+		return float64(c.ServoBasePosition), true
+	case autoroast.CalibrationServoClickPosition:
+		return float64(c.ServoClickPosition), true
+	case autoroast.CalibrationServoPressDelay:
+		return float64(c.ServoPressDelay.Milliseconds()), true
+	case autoroast.CalibrationServoResetDelay:
+		return float64(c.ServoResetDelay.Milliseconds()), true
+	case autoroast.CalibrationStepsPerIncrement:
+		return float64(c.StepsPerIncrement), true
+	case autoroast.CalibrationDelayAfterStepperMove:
+		return float64(c.DelayAfterStepperMove.Milliseconds()), true
+	case autoroast.CalibrationBackstepRatio:
+		return float64(c.BackstepRatio), true
+	default:
+		return 0, false
+	}
+}
+
+// Set changes the value of a calibration field. Durations are in milliseconds
+func (c *CalibrationConfig) Set(f autoroast.CalibrationField, v float64) error {
+	if v < 0 {
+		return errors.New("invalid value for " + f.String())
+	}
+
+	switch f {
+	case autoroast.CalibrationServoBasePosition:
+		c.ServoBasePosition = int(v)
+	case autoroast.CalibrationServoClickPosition:
+		c.ServoClickPosition = int(v)
+	case autoroast.CalibrationServoPressDelay:
+		c.ServoPressDelay = time.Duration(v * float64(time.Millisecond))
+	case autoroast.CalibrationServoResetDelay:
+		c.ServoResetDelay = time.Duration(v * float64(time.Millisecond))
+	case autoroast.CalibrationStepsPerIncrement:
+		if v == 0 {
+			return errors.New("invalid value for " + f.String())
+		}
+		c.StepsPerIncrement = float32(v)
+	case autoroast.CalibrationDelayAfterStepperMove:
+		c.DelayAfterStepperMove = time.Duration(v * float64(time.Millisecond))
+	case autoroast.CalibrationBackstepRatio:
+		c.BackstepRatio = float32(v)
+	default:
+		return errors.New("unknown calibration field: " + string(byte(f)))
+	}
+	return nil
+}
+
+// Values returns all of the calibration fields
+func (c CalibrationConfig) Values() autoroast.CalibrationValues {
+	values := autoroast.CalibrationValues{}
+	for _, f := range autoroast.CalibrationFields {
+		values[f], _ = c.Get(f)
+	}
+	return values
+}
+
+// MarshalBinary encodes the CalibrationConfig for storage
+func (c CalibrationConfig) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 0, calibrationSize)
+	out = append(out, calibrationMagic[:]...)
+	for _, f := range autoroast.CalibrationFields {
+		v, _ := c.Get(f)
+		out = binary.LittleEndian.AppendUint32(out, math.Float32bits(float32(v)))
+	}
+	return append(out, checksum(out)), nil
+}
+
+// UnmarshalBinary decodes a CalibrationConfig created by MarshalBinary
+func (c *CalibrationConfig) UnmarshalBinary(data []byte) error {
+	if len(data) < calibrationSize || [4]byte(data[:4]) != calibrationMagic {
+		return errors.New("no stored calibration")
+	}
+	data = data[:calibrationSize]
+	if data[calibrationSize-1] != checksum(data[:calibrationSize-1]) {
+		return errors.New("invalid stored calibration checksum")
+	}
+
+	fields := data[len(calibrationMagic):]
+	for i, f := range autoroast.CalibrationFields {
+		v := math.Float32frombits(binary.LittleEndian.Uint32(fields[i*4:]))
+		err := c.Set(f, float64(v))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum ^= b
+	}
+	return sum
+}
@@ -0,0 +1,41 @@
+package device
+
+import "testing"
+
+func TestCalibrationConfigBinary(t *testing.T) {
+	cfg := testCalibration
+	cfg.StepsPerIncrement = 10.5
+	cfg.BackstepRatio = 0.25
+
+	data, err := cfg.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		var got CalibrationConfig
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary() error = %v", err)
+		}
+		if got != cfg {
+			t.Errorf("UnmarshalBinary() = %+v, want %+v", got, cfg)
+		}
+	})
+
+	t.Run("InvalidChecksum", func(t *testing.T) {
+		corrupt := append([]byte{}, data...)
+		corrupt[len(calibrationMagic)] ^= 0xFF
+
+		var got CalibrationConfig
+		if err := got.UnmarshalBinary(corrupt); err == nil {
+			t.Error("UnmarshalBinary() error = nil for corrupt data, want error")
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		var got CalibrationConfig
+		if err := got.UnmarshalBinary(make([]byte, calibrationSize)); err == nil {
+			t.Error("UnmarshalBinary() error = nil for erased flash, want error")
+		}
+	})
+}
@@ -2,13 +2,11 @@ package device
 
 import (
 	"errors"
-	"machine"
 	"math"
+	"strconv"
 	"time"
 
 	"github.com/calvinmclean/autoroast"
-
-	"tinygo.org/x/drivers/servo"
 )
 
 const selectModeTimeout = 3 * time.Second
@@ -16,8 +14,12 @@ const selectModeTimeout = 3 * time.Second
 // Device controls the FreshRoast SR800. It manages the Stepper and Servo motors and the machine's state
 type Device struct {
 	stepper        *Stepper
-	servo          servo.Servo
+	servo          Servo
+	serial         Serial
+	clock          Clock
+	thermometer    Thermometer
 	calibrationCfg CalibrationConfig
+	store          CalibrationStore
 
 	currentControlMode autoroast.ControlMode
 	fan                uint
@@ -30,24 +32,42 @@ type Device struct {
 	lastChange time.Time
 
 	verbose bool
+	log     func(string)
 
 	remainder float32
+
+	// temperatureStream is the interval between bean temperature readings written by the commands. The stream is
+	// off if it is zero
+	temperatureStream time.Duration
 }
 
-// New intializes the state with the provided configs
-func New(stepperCfg StepperConfig, servoCfg ServoConfig, calibrationCfg CalibrationConfig) (Device, error) {
-	stepper, err := NewStepper(stepperCfg)
+// New intializes the state with the provided hardware and config. If the store has a saved CalibrationConfig,
+// it is used instead of the provided one
+func New(hw Hardware, calibrationCfg CalibrationConfig, store CalibrationStore) (Device, error) {
+	if hw.Log == nil {
+		hw.Log = printLog
+	}
+
+	if store != nil {
+		stored, err := store.Load()
+		if err == nil {
+			calibrationCfg = stored
+		} else {
+			hw.Log("using default calibration: " + err.Error())
+		}
+	}
+
+	if hw.Clock == nil {
+		hw.Clock = SystemClock{}
+	}
+
+	stepper, err := NewStepper(hw.Stepper, hw.Clock)
 	if err != nil {
 		return Device{}, errors.New("error creating stepper: " + err.Error())
 	}
 
-	var myServo servo.Servo
-	if servoCfg != (ServoConfig{}) {
-		myServo, err = servo.New(servoCfg.PWM, servoCfg.Pin)
-		if err != nil {
-			return Device{}, errors.New("error creating servo: " + err.Error())
-		}
-		err := myServo.SetAngle(calibrationCfg.ServoBasePosition)
+	if hw.Servo != nil {
+		err := hw.Servo.SetAngle(calibrationCfg.ServoBasePosition)
 		if err != nil {
 			return Device{}, errors.New("error setting servo angle: " + err.Error())
 		}
@@ -55,14 +75,19 @@ func New(stepperCfg StepperConfig, servoCfg ServoConfig, calibrationCfg Calibrat
 
 	return Device{
 		stepper:            stepper,
-		servo:              myServo,
+		servo:              hw.Servo,
+		serial:             hw.Serial,
+		clock:              hw.Clock,
+		thermometer:        hw.Thermometer,
 		calibrationCfg:     calibrationCfg,
+		store:              store,
 		currentControlMode: autoroast.ControlModeFan,
 		fan:                0,
 		power:              0,
 		startTime:          time.Time{},
 		lastChange:         time.Time{},
 		verbose:            false,
+		log:                hw.Log,
 	}, nil
 }
 
@@ -71,46 +96,62 @@ func (d *Device) Start() error {
 	if d.fan == 0 || d.power == 0 {
 		return errors.New("set initial fan/power before starting")
 	}
-	d.startTime = time.Now()
+	d.startTime = d.clock.Now()
 
-	println(d.ts(), "Started...")
+	d.println("Started...")
+
+	return nil
+}
+
+// Resume starts with the timer already at elapsed. It is used to continue a roast after the device is reset
+func (d *Device) Resume(elapsed time.Duration) error {
+	if d.fan == 0 || d.power == 0 {
+		return errors.New("set initial fan/power before resuming")
+	}
+	d.startTime = d.clock.Now().Add(-elapsed)
+
+	d.println("Resumed...")
 
 	return nil
 }
 
 // Duration returns the duration that this has been running
 func (d *Device) Duration() time.Duration {
-	return time.Since(d.startTime)
+	return d.clock.Now().Sub(d.startTime)
 }
 
 // ClickButton uses the servo motor to click the FreshRoast button to enable setting changes
 func (d *Device) ClickButton() {
 	if d.verbose {
-		println(d.ts(), "ClickButton")
+		d.println("ClickButton")
+	}
+	if d.servo == nil {
+		d.println("error clicking button: no servo")
+		return
 	}
 
 	err := d.servo.SetAngle(d.calibrationCfg.ServoClickPosition)
 	if err != nil {
-		println(d.ts(), "error setting servo angle:", err.Error())
+		d.println("error setting servo angle:", err.Error())
 		return
 	}
 
-	time.Sleep(d.calibrationCfg.ServoPressDelay)
+	d.clock.Sleep(d.calibrationCfg.ServoPressDelay)
 
 	err = d.servo.SetAngle(d.calibrationCfg.ServoBasePosition)
 	if err != nil {
-		println(d.ts(), "error resetting servo angle:", err.Error())
+		d.println("error resetting servo angle:", err.Error())
 		return
 	}
 
-	d.lastChange = time.Now()
-	time.Sleep(d.calibrationCfg.ServoResetDelay)
+	d.lastChange = d.clock.Now()
+	d.clock.Sleep(d.calibrationCfg.ServoResetDelay)
 }
 
 // GoToMode will click the FreshRoast button until the target ControlMode is active
 func (d *Device) GoToMode(target autoroast.ControlMode) bool {
 	if d.verbose {
-		println(d.ts(), "GoToMode:", target)
+		d.println("GoToMode:", target.String())
 	}
 
 	if target == autoroast.ControlModeUnknown {
@@ -122,7 +163,7 @@ func (d *Device) GoToMode(target autoroast.ControlMode) bool {
 	// click an extra time to get back into "select mode"
 	var clicked bool
 	if !d.startTime.IsZero() {
-		if time.Since(d.lastChange) > selectModeTimeout {
+		if d.clock.Now().Sub(d.lastChange) > selectModeTimeout {
 			d.ClickButton()
 			clicked = true
 		}
@@ -150,10 +191,10 @@ func (d *Device) FixControlMode(cm autoroast.ControlMode) {
 // It does not change the "State" of the device. This is useful for fixing off-by-one movements
 func (d *Device) MoveFan(i int32) {
 	if d.verbose {
-		println(d.ts(), "MoveFan", i)
+		d.println("MoveFan", strconv.Itoa(int(i)))
 	}
 	if d.GoToMode(autoroast.ControlModeFan) {
-		time.Sleep(200 * time.Millisecond)
+		d.clock.Sleep(200 * time.Millisecond)
 	}
 	d.Move(i)
 }
@@ -162,10 +203,10 @@ func (d *Device) MoveFan(i int32) {
 // It does not change the "State" of the device. This is useful for fixing off-by-one movements
 func (d *Device) MovePower(i int32) {
 	if d.verbose {
-		println(d.ts(), "MovePower", i)
+		d.println("MovePower", strconv.Itoa(int(i)))
 	}
 	if d.GoToMode(autoroast.ControlModePower) {
-		time.Sleep(200 * time.Millisecond)
+		d.clock.Sleep(200 * time.Millisecond)
 	}
 	d.Move(i)
 }
@@ -174,7 +215,7 @@ func (d *Device) MovePower(i int32) {
 // If this exceeds the bounds, it will still move by the number of increments.
 func (d *Device) MoveTimer(i int32) {
 	if d.verbose {
-		println(d.ts(), "MoveTimer", i)
+		d.println("MoveTimer", strconv.Itoa(int(i)))
 	}
 	d.GoToMode(autoroast.ControlModeTimer)
 	d.Move(i)
@@ -193,13 +234,13 @@ func (d *Device) FixFan(f uint) {
 // SetFan sets the FreshRoast fan to the specified value
 func (d *Device) SetFan(f uint) {
 	if d.verbose {
-		println(d.ts(), "SetFan", f)
+		d.println("SetFan", strconv.Itoa(int(f)))
 	}
 	if f < 1 || f > 9 {
 		return
 	}
 
-	println(d.ts(), levelStr("F", f))
+	d.println(levelStr("F", f))
 
 	delta := int32(f) - int32(d.fan)
 
@@ -219,13 +260,13 @@ func (d *Device) SetFan(f uint) {
 // SetPower sets the FreshRoast power to the specified value
 func (d *Device) SetPower(p uint) {
 	if d.verbose {
-		println(d.ts(), "SetPower", p)
+		d.println("SetPower", strconv.Itoa(int(p)))
 	}
 	if p < 1 || p > 9 {
 		return
 	}
 
-	println(d.ts(), levelStr("P", p))
+	d.println(levelStr("P", p))
 
 	// When moving to extremes, we can move extra to re-calibrate and account for inaccuracy
 	delta := int32(p) - int32(d.power)
@@ -244,7 +285,7 @@ func (d *Device) SetPower(p uint) {
 // IncreaseTime just increases the time on device by 5m
 func (d *Device) IncreaseTime() {
 	if d.verbose {
-		println(d.ts(), "IncreaseTime")
+		d.println("IncreaseTime")
 	}
 	d.GoToMode(autoroast.ControlModeTimer)
 	d.Move(5)
@@ -279,28 +320,28 @@ func (d *Device) Move(n int32) {
 	d.stepper.Move(move)
 
 	// Set lastChange before backsteps since the backsteps shouldn't actually move the roaster knob
-	d.lastChange = time.Now()
+	d.lastChange = d.clock.Now()
 
 	if backsteps != 0 {
-		time.Sleep(200 * time.Millisecond)
+		d.clock.Sleep(200 * time.Millisecond)
 		// Move back slightly
 		d.stepper.Move(backsteps)
 	}
 
-	time.Sleep(d.calibrationCfg.DelayAfterStepperMove)
+	d.clock.Sleep(d.calibrationCfg.DelayAfterStepperMove)
 }
 
 // Debug pritns out details of the Device's state
 func (c *Device) Debug() {
 	d := c.ts() + " " + levelStr("F", c.fan) + "/" + levelStr("P", c.power)
 	d += " mode=" + c.currentControlMode.String()
-	println(d)
+	c.log(d)
 }
 
 // Verbose sets the Device to Verbose mode and increases logging
 func (d *Device) Verbose() {
 	d.verbose = true
-	println(d.ts(), "Set Verbose Mode")
+	d.println("Set Verbose Mode")
 }
 
 // ts returns the duration timestamp for logging
@@ -311,6 +352,19 @@ func (d *Device) ts() string {
 	return "[" + d.Duration().String() + "]"
 }
 
+// println logs a line with the duration timestamp, like the builtin println
+func (d *Device) println(parts ...string) {
+	line := d.ts()
+	for _, part := range parts {
+		line += " " + part
+	}
+	d.log(line)
+}
+
+func printLog(line string) {
+	println(line)
+}
+
 // levelStr formats a power/fan level setting like F9 or P9
 func levelStr[T uint | int](character string, level T) string {
 	return character + string(byte(level)+'0')
@@ -325,10 +379,97 @@ func (d *Device) Settings() (uint, uint) {
 	return d.fan, d.power
 }
 
+// Calibration returns the current value of a calibration field. Durations are in milliseconds
+func (d *Device) Calibration(f autoroast.CalibrationField) (float64, bool) {
+	return d.calibrationCfg.Get(f)
+}
+
+// CalibrationValues returns the current values of all calibration fields
+func (d *Device) CalibrationValues() autoroast.CalibrationValues {
+	return d.calibrationCfg.Values()
+}
+
+// SetCalibration changes a calibration field and saves the new CalibrationConfig so it is used after rebooting
+func (d *Device) SetCalibration(f autoroast.CalibrationField, v float64) error {
+	cfg := d.calibrationCfg
+	err := cfg.Set(f, v)
+	if err != nil {
+		return err
+	}
+
+	if f == autoroast.CalibrationServoBasePosition && d.servo != nil {
+		err = d.servo.SetAngle(cfg.ServoBasePosition)
+		if err != nil {
+			return errors.New("error setting servo angle: " + err.Error())
+		}
+	}
+
+	d.calibrationCfg = cfg
+	if d.verbose {
+		d.println("SetCalibration", f.String())
+	}
+
+	if d.store == nil {
+		return nil
+	}
+	err = d.store.Save(cfg)
+	if err != nil {
+		return errors.New("error saving calibration: " + err.Error())
+	}
+	return nil
+}
+
+// Status returns the Device's current state
+func (d *Device) Status() autoroast.DeviceStatus {
+	status := autoroast.DeviceStatus{
+		Fan:         d.fan,
+		Power:       d.power,
+		ControlMode: d.currentControlMode,
+		Remainder:   d.remainder,
+		Verbose:     d.verbose,
+	}
+	if !d.startTime.IsZero() {
+		status.Started = true
+		status.Elapsed = d.Duration()
+	}
+	return status
+}
+
+// Temperature reads the bean temperature from the Thermometer
+func (d *Device) Temperature() (autoroast.TemperatureReading, error) {
+	if d.thermometer == nil {
+		return autoroast.TemperatureReading{}, errors.New("no thermometer")
+	}
+
+	c, err := d.thermometer.ReadTemperature()
+	if err != nil {
+		return autoroast.TemperatureReading{}, err
+	}
+
+	reading := autoroast.TemperatureReading{Bean: autoroast.CelsiusToFahrenheit(c)}
+	if !d.startTime.IsZero() {
+		reading.Elapsed = d.Duration()
+	}
+	return reading, nil
+}
+
+// SetTemperatureStream sets the interval between streamed temperature readings. Zero stops the stream
+func (d *Device) SetTemperatureStream(interval time.Duration) {
+	d.temperatureStream = interval
+	if d.verbose {
+		d.println("SetTemperatureStream", interval.String())
+	}
+}
+
+// TemperatureStream returns the interval between streamed temperature readings. It is zero if the stream is off
+func (d *Device) TemperatureStream() time.Duration {
+	return d.temperatureStream
+}
+
 func (d *Device) ReadByte() (byte, error) {
-	return machine.Serial.ReadByte()
+	return d.serial.ReadByte()
 }
 
 func (d *Device) WriteByte(b byte) error {
-	return machine.Serial.WriteByte(b)
+	return d.serial.WriteByte(b)
 }
@@ -0,0 +1,446 @@
+package device
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/calvinmclean/autoroast"
+)
+
+// fakeClock only moves forward when sleeping
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// fakeServo records every angle that it is set to
+type fakeServo struct {
+	angles []int
+}
+
+func (s *fakeServo) SetAngle(angle int) error {
+	s.angles = append(s.angles, angle)
+	return nil
+}
+
+// clicks counts how many times the servo moved to the click position
+func (s *fakeServo) clicks(clickPosition int) int {
+	var n int
+	for _, a := range s.angles {
+		if a == clickPosition {
+			n++
+		}
+	}
+	return n
+}
+
+// fakeStepper decodes the sequence from its pins to track the stepper's position in half steps
+type fakeStepper struct {
+	values   [4]bool
+	index    int
+	position int32
+	max      int32
+}
+
+type fakePin struct {
+	stepper *fakeStepper
+	i       int
+}
+
+func (p fakePin) Set(v bool) {
+	p.stepper.values[p.i] = v
+	// the last pin is set after the others, so the full step is ready
+	if p.i == 3 {
+		p.stepper.update()
+	}
+}
+
+func (s *fakeStepper) pins() [4]Pin {
+	return [4]Pin{fakePin{s, 0}, fakePin{s, 1}, fakePin{s, 2}, fakePin{s, 3}}
+}
+
+func (s *fakeStepper) update() {
+	for i, seq := range halfStepSequence {
+		if seq != s.values {
+			continue
+		}
+		// full steps skip every other half step
+		switch diff := (i - s.index + len(halfStepSequence)) % len(halfStepSequence); diff {
+		case 1, 2:
+			s.position += int32(diff)
+		case 6, 7:
+			s.position -= int32(len(halfStepSequence) - diff)
+		}
+		s.index = i
+		s.max = max(s.max, s.position)
+		return
+	}
+}
+
+type fakeStore struct {
+	stored *CalibrationConfig
+}
+
+func (s *fakeStore) Load() (CalibrationConfig, error) {
+	if s.stored == nil {
+		return CalibrationConfig{}, errors.New("no stored calibration")
+	}
+	return *s.stored, nil
+}
+
+func (s *fakeStore) Save(cfg CalibrationConfig) error {
+	s.stored = &cfg
+	return nil
+}
+
+var testCalibration = CalibrationConfig{
+	ServoBasePosition:     30,
+	ServoClickPosition:    70,
+	ServoPressDelay:       200 * time.Millisecond,
+	ServoResetDelay:       250 * time.Millisecond,
+	StepsPerIncrement:     10,
+	DelayAfterStepperMove: 500 * time.Millisecond,
+}
+
+type testDevice struct {
+	*Device
+	stepper *fakeStepper
+	servo   *fakeServo
+	clock   *fakeClock
+	store   *fakeStore
+}
+
+func newTestDevice(t *testing.T, cfg CalibrationConfig) testDevice {
+	t.Helper()
+
+	td := testDevice{
+		stepper: &fakeStepper{},
+		servo:   &fakeServo{},
+		clock:   &fakeClock{now: time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)},
+		store:   &fakeStore{},
+	}
+	d, err := New(Hardware{
+		Stepper: StepperConfig{Pins: td.stepper.pins(), StepMode: StepModeHalf, StepDelay: time.Microsecond},
+		Servo:   td.servo,
+		Clock:   td.clock,
+	}, cfg, td.store)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	td.Device = &d
+
+	return td
+}
+
+func TestNew(t *testing.T) {
+	t.Run("SetsServoBasePosition", func(t *testing.T) {
+		d := newTestDevice(t, testCalibration)
+		if len(d.servo.angles) != 1 || d.servo.angles[0] != testCalibration.ServoBasePosition {
+			t.Errorf("servo angles = %v, want [%d]", d.servo.angles, testCalibration.ServoBasePosition)
+		}
+	})
+
+	t.Run("UsesStoredCalibration", func(t *testing.T) {
+		stored := testCalibration
+		stored.StepsPerIncrement = 20
+		store := &fakeStore{stored: &stored}
+
+		d, err := New(Hardware{
+			Stepper: StepperConfig{Pins: (&fakeStepper{}).pins()},
+			Clock:   &fakeClock{},
+		}, testCalibration, store)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		if v, _ := d.Calibration(autoroast.CalibrationStepsPerIncrement); v != 20 {
+			t.Errorf("StepsPerIncrement = %v, want stored value 20", v)
+		}
+	})
+
+	t.Run("MissingPin", func(t *testing.T) {
+		_, err := New(Hardware{}, testCalibration, nil)
+		if err == nil {
+			t.Error("New() error = nil, want error for missing stepper pins")
+		}
+	})
+}
+
+func TestStart(t *testing.T) {
+	d := newTestDevice(t, testCalibration)
+	if err := d.Start(); err == nil {
+		t.Error("Start() error = nil before setting fan and power, want error")
+	}
+
+	d.FixFan(5)
+	d.FixPower(5)
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	d.clock.Sleep(90 * time.Second)
+	status := d.Status()
+	if !status.Started || status.Elapsed != 90*time.Second {
+		t.Errorf("status = %+v, want started for 90s", status)
+	}
+}
+
+func TestResume(t *testing.T) {
+	d := newTestDevice(t, testCalibration)
+	if err := d.Resume(time.Minute); err == nil {
+		t.Error("Resume() error = nil before setting fan and power, want error")
+	}
+
+	d.FixFan(5)
+	d.FixPower(5)
+	if err := d.Resume(4 * time.Minute); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+
+	d.clock.Sleep(30 * time.Second)
+	status := d.Status()
+	if !status.Started || status.Elapsed != 4*time.Minute+30*time.Second {
+		t.Errorf("status = %+v, want started for 4m30s", status)
+	}
+}
+
+func TestMove(t *testing.T) {
+	t.Run("SingleIncrementMovesExtra", func(t *testing.T) {
+		d := newTestDevice(t, testCalibration)
+		d.Move(1)
+		if d.stepper.position != 15 {
+			t.Errorf("position = %d, want 15", d.stepper.position)
+		}
+		d.Move(-1)
+		if d.stepper.position != 0 {
+			t.Errorf("position = %d, want 0", d.stepper.position)
+		}
+	})
+
+	t.Run("KeepsRemainder", func(t *testing.T) {
+		cfg := testCalibration
+		cfg.StepsPerIncrement = 10.4
+		d := newTestDevice(t, cfg)
+
+		// 20.8 steps rounds up to 21, so the next move is 0.2 steps shorter
+		d.Move(2)
+		d.Move(2)
+		if d.stepper.position != 42 {
+			t.Errorf("position = %d, want 42", d.stepper.position)
+		}
+		if r := d.Status().Remainder; math.Abs(float64(r)+0.4) > 0.001 {
+			t.Errorf("remainder = %v, want -0.4", r)
+		}
+
+		d.Move(-4)
+		if d.stepper.position != 0 {
+			t.Errorf("position = %d, want 0", d.stepper.position)
+		}
+	})
+
+	t.Run("Backsteps", func(t *testing.T) {
+		cfg := testCalibration
+		cfg.BackstepRatio = 2
+		d := newTestDevice(t, cfg)
+
+		// the move isn't extended by the backsteps, so it backs up from the target. The roaster's calibration is
+		// tuned for this
+		d.Move(2)
+		if d.stepper.max != 20 || d.stepper.position != 15 {
+			t.Errorf("moved to %d and ended at %d, want 20 and 15", d.stepper.max, d.stepper.position)
+		}
+
+		d.Move(-2)
+		if d.stepper.position != 0 {
+			t.Errorf("position = %d, want 0", d.stepper.position)
+		}
+
+		// it still backs up when the remainder doesn't add up to a step
+		d.Move(0)
+		if d.stepper.position != -5 {
+			t.Errorf("position = %d after Move(0), want -5", d.stepper.position)
+		}
+	})
+
+	t.Run("WaitsAfterMoving", func(t *testing.T) {
+		d := newTestDevice(t, testCalibration)
+		start := d.clock.Now()
+		d.Move(2)
+		if waited := d.clock.Now().Sub(start); waited < testCalibration.DelayAfterStepperMove {
+			t.Errorf("waited %s, want at least %s", waited, testCalibration.DelayAfterStepperMove)
+		}
+	})
+}
+
+func TestGoToMode(t *testing.T) {
+	click := testCalibration.ServoClickPosition
+
+	t.Run("BeforeStarting", func(t *testing.T) {
+		d := newTestDevice(t, testCalibration)
+
+		if d.GoToMode(autoroast.ControlModeFan) {
+			t.Error("GoToMode(Fan) = true when already in Fan mode, want false")
+		}
+		if !d.GoToMode(autoroast.ControlModeTimer) {
+			t.Error("GoToMode(Timer) = false, want true")
+		}
+		if got := d.servo.clicks(click); got != 2 {
+			t.Errorf("clicks = %d, want 2", got)
+		}
+
+		// the mode wraps around back to Fan
+		d.GoToMode(autoroast.ControlModeFan)
+		if got := d.servo.clicks(click); got != 3 {
+			t.Errorf("clicks = %d, want 3", got)
+		}
+	})
+
+	t.Run("ExtraClickAfterSelectModeTimeout", func(t *testing.T) {
+		d := newTestDevice(t, testCalibration)
+		d.FixFan(5)
+		d.FixPower(5)
+		if err := d.Start(); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+
+		d.clock.Sleep(selectModeTimeout + time.Second)
+		if !d.GoToMode(autoroast.ControlModeFan) {
+			t.Error("GoToMode(Fan) = false after select mode timed out, want true")
+		}
+		if got := d.servo.clicks(click); got != 1 {
+			t.Errorf("clicks = %d, want 1 to enter select mode", got)
+		}
+
+		// still in select mode, so only one click is needed
+		d.GoToMode(autoroast.ControlModePower)
+		if got := d.servo.clicks(click); got != 2 {
+			t.Errorf("clicks = %d, want 2", got)
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		d := newTestDevice(t, testCalibration)
+		if d.GoToMode(autoroast.ControlModeUnknown) {
+			t.Error("GoToMode(Unknown) = true, want false")
+		}
+	})
+}
+
+func TestSetFanAndPower(t *testing.T) {
+	d := newTestDevice(t, testCalibration)
+	d.FixFan(5)
+	d.FixPower(3)
+
+	d.SetFan(7)
+	if d.stepper.position != 20 {
+		t.Errorf("position = %d, want 20", d.stepper.position)
+	}
+
+	// moves past 9 to make sure the knob is at the maximum
+	d.SetFan(9)
+	if d.stepper.position != 70 {
+		t.Errorf("position = %d, want 70", d.stepper.position)
+	}
+
+	d.SetFan(0)
+	if fan, _ := d.Settings(); fan != 9 {
+		t.Errorf("fan = %d after invalid setting, want 9", fan)
+	}
+
+	d.SetPower(1)
+	if d.stepper.position != 20 {
+		t.Errorf("position = %d, want 20", d.stepper.position)
+	}
+	if got := d.servo.clicks(testCalibration.ServoClickPosition); got != 1 {
+		t.Errorf("clicks = %d, want 1 to change to Power mode", got)
+	}
+
+	status := d.Status()
+	if status.Fan != 9 || status.Power != 1 || status.ControlMode != autoroast.ControlModePower {
+		t.Errorf("status = %+v, want F9/P1 in Power mode", status)
+	}
+}
+
+func TestSetCalibration(t *testing.T) {
+	d := newTestDevice(t, testCalibration)
+
+	err := d.SetCalibration(autoroast.CalibrationServoBasePosition, 40)
+	if err != nil {
+		t.Fatalf("SetCalibration() error = %v", err)
+	}
+	if last := d.servo.angles[len(d.servo.angles)-1]; last != 40 {
+		t.Errorf("servo angle = %d, want new base position 40", last)
+	}
+	if d.store.stored == nil || d.store.stored.ServoBasePosition != 40 {
+		t.Errorf("stored calibration = %+v, want ServoBasePosition 40", d.store.stored)
+	}
+
+	err = d.SetCalibration(autoroast.CalibrationStepsPerIncrement, 0)
+	if err == nil {
+		t.Error("SetCalibration() error = nil for zero StepsPerIncrement, want error")
+	}
+	if v, _ := d.Calibration(autoroast.CalibrationStepsPerIncrement); v != 10 {
+		t.Errorf("StepsPerIncrement = %v after invalid change, want 10", v)
+	}
+}
+
+type fakeThermometer struct {
+	celsius float32
+	err     error
+}
+
+func (t fakeThermometer) ReadTemperature() (float32, error) {
+	return t.celsius, t.err
+}
+
+func TestTemperature(t *testing.T) {
+	t.Run("NoThermometer", func(t *testing.T) {
+		d := newTestDevice(t, testCalibration)
+		if _, err := d.Temperature(); err == nil {
+			t.Error("Temperature() error = nil without a thermometer, want error")
+		}
+	})
+
+	t.Run("Fahrenheit", func(t *testing.T) {
+		d := newTestDevice(t, testCalibration)
+		d.thermometer = fakeThermometer{celsius: 200}
+
+		reading, err := d.Temperature()
+		if err != nil {
+			t.Fatalf("Temperature() error = %v", err)
+		}
+		if reading.Bean != 392 || reading.Elapsed != 0 {
+			t.Errorf("reading = %+v, want 392F before starting", reading)
+		}
+
+		d.FixFan(5)
+		d.FixPower(5)
+		if err := d.Start(); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		d.clock.Sleep(time.Minute)
+		reading, err = d.Temperature()
+		if err != nil {
+			t.Fatalf("Temperature() error = %v", err)
+		}
+		if reading.Elapsed != time.Minute {
+			t.Errorf("elapsed = %s, want 1m0s", reading.Elapsed)
+		}
+	})
+
+	t.Run("Fault", func(t *testing.T) {
+		d := newTestDevice(t, testCalibration)
+		d.thermometer = fakeThermometer{err: ErrThermocoupleOpen}
+		if _, err := d.Temperature(); !errors.Is(err, ErrThermocoupleOpen) {
+			t.Errorf("Temperature() error = %v, want %v", err, ErrThermocoupleOpen)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+//go:build tinygo
+
+package device
+
+import (
+	"machine"
+)
+
+// FlashStore keeps the CalibrationConfig at the start of the flash memory's data section, which is
+// not overwritten when flashing new firmware
+type FlashStore struct{}
+
+var _ CalibrationStore = FlashStore{}
+
+// Load reads the CalibrationConfig from flash. It returns an error if nothing has been stored
+func (FlashStore) Load() (CalibrationConfig, error) {
+	data := make([]byte, calibrationSize)
+	_, err := machine.Flash.ReadAt(data, 0)
+	if err != nil {
+		return CalibrationConfig{}, err
+	}
+
+	var cfg CalibrationConfig
+	err = cfg.UnmarshalBinary(data)
+	if err != nil {
+		return CalibrationConfig{}, err
+	}
+	return cfg, nil
+}
+
+// Save erases the first block of the data section and writes the CalibrationConfig
+func (FlashStore) Save(cfg CalibrationConfig) error {
+	data, err := cfg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	// writes must be a multiple of the block size, so pad with the erased value
+	blockSize := int(machine.Flash.WriteBlockSize())
+	for len(data)%blockSize != 0 {
+		data = append(data, 0xFF)
+	}
+
+	err = machine.Flash.EraseBlocks(0, 1)
+	if err != nil {
+		return err
+	}
+
+	_, err = machine.Flash.WriteAt(data, 0)
+	return err
+}
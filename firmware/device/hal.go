@@ -0,0 +1,64 @@
+package device
+
+import "time"
+
+// Pin is a digital output pin
+type Pin interface {
+	Set(bool)
+}
+
+// Servo is the servo motor that clicks the FreshRoast's button
+type Servo interface {
+	SetAngle(angle int) error
+}
+
+// Clock is used for all timing so it can be controlled in tests
+type Clock interface {
+	Now() time.Time
+	Sleep(time.Duration)
+}
+
+// Serial is the connection to the controller
+type Serial interface {
+	ReadByte() (byte, error)
+	WriteByte(byte) error
+}
+
+// SPI is the bus used to read sensors like the Thermocouple
+type SPI interface {
+	Tx(w, r []byte) error
+}
+
+// Thermometer measures the bean temperature in degrees Celsius
+type Thermometer interface {
+	ReadTemperature() (float32, error)
+}
+
+// Hardware has everything that the Device uses to interact with the outside world. On the microcontroller,
+// it is created with the machine package. Tests use fakes
+type Hardware struct {
+	Stepper StepperConfig
+	// Servo is optional. The button can't be clicked without it
+	Servo  Servo
+	Serial Serial
+	// Clock defaults to the system clock
+	Clock Clock
+	// Thermometer is optional. The bean temperature can't be read without it
+	Thermometer Thermometer
+	// Log writes a line of output. It defaults to println, which is written to the USB serial port on the
+	// microcontroller
+	Log func(string)
+}
+
+// SystemClock uses the time package
+type SystemClock struct{}
+
+var _ Clock = SystemClock{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
@@ -0,0 +1,37 @@
+//go:build tinygo
+
+package device
+
+import (
+	"machine"
+
+	"tinygo.org/x/drivers/servo"
+)
+
+// OutputPin configures a machine.Pin as an output for the Stepper
+func OutputPin(p machine.Pin) Pin {
+	p.Configure(machine.PinConfig{Mode: machine.PinOutput})
+	return p
+}
+
+// NewServo creates a Servo using the PWM on the pin
+func NewServo(pwm servo.PWM, pin machine.Pin) (Servo, error) {
+	s, err := servo.New(pwm, pin)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MachineSerial reads and writes the USB serial connection
+type MachineSerial struct{}
+
+var _ Serial = MachineSerial{}
+
+func (MachineSerial) ReadByte() (byte, error) {
+	return machine.Serial.ReadByte()
+}
+
+func (MachineSerial) WriteByte(b byte) error {
+	return machine.Serial.WriteByte(b)
+}
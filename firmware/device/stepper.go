@@ -2,7 +2,6 @@ package device
 
 import (
 	"errors"
-	"machine"
 	"time"
 )
 
@@ -16,29 +15,33 @@ const (
 )
 
 type Stepper struct {
-	pins        [4]machine.Pin
+	pins        [4]Pin
 	stepMode    StepMode
 	currentStep int
 	stepDelay   time.Duration
+	clock       Clock
 }
 
-func NewStepper(cfg StepperConfig) (*Stepper, error) {
+func NewStepper(cfg StepperConfig, clock Clock) (*Stepper, error) {
 	if cfg.StepMode != StepModeFull && cfg.StepMode != StepModeHalf {
 		return nil, errors.New("invalid StepMode")
 	}
+	for _, p := range cfg.Pins {
+		if p == nil {
+			return nil, errors.New("missing stepper pin")
+		}
+	}
 
 	if cfg.StepDelay == 0 {
 		cfg.StepDelay = defaultStepDelay
 	}
 
 	w := &Stepper{
-		pins:        [4]machine.Pin{cfg.Pins[0], cfg.Pins[1], cfg.Pins[2], cfg.Pins[3]},
+		pins:        cfg.Pins,
 		stepMode:    cfg.StepMode,
 		stepDelay:   cfg.StepDelay,
 		currentStep: 0,
-	}
-	for _, p := range w.pins {
-		p.Configure(machine.PinConfig{Mode: machine.PinOutput})
+		clock:       clock,
 	}
 	return w, nil
 }
@@ -89,7 +92,7 @@ func (s *Stepper) StepForward() {
 
 	s.currentStep = (s.currentStep + 1) % sequenceLen
 	s.applyStep()
-	time.Sleep(s.stepDelay)
+	s.clock.Sleep(s.stepDelay)
 }
 
 func (s *Stepper) StepBackward() {
@@ -100,7 +103,7 @@ func (s *Stepper) StepBackward() {
 
 	s.currentStep = (s.currentStep - 1 + sequenceLen) % sequenceLen
 	s.applyStep()
-	time.Sleep(s.stepDelay)
+	s.clock.Sleep(s.stepDelay)
 }
 
 func (s *Stepper) Move(steps int32) {
@@ -0,0 +1,23 @@
+package device
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStepperFullStep(t *testing.T) {
+	pins := &fakeStepper{}
+	clock := &fakeClock{}
+	s, err := NewStepper(StepperConfig{Pins: pins.pins(), StepMode: StepModeFull, StepDelay: time.Millisecond}, clock)
+	if err != nil {
+		t.Fatalf("NewStepper() error = %v", err)
+	}
+
+	s.Move(3)
+	if pins.position != 6 {
+		t.Errorf("half steps = %d, want 6", pins.position)
+	}
+	if waited := clock.now.Sub(time.Time{}); waited != 3*time.Millisecond {
+		t.Errorf("waited %s, want 3ms", waited)
+	}
+}
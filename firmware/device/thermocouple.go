@@ -0,0 +1,100 @@
+package device
+
+import "errors"
+
+// ThermocoupleChip is the thermocouple-to-digital converter connected to the SPI bus
+type ThermocoupleChip int
+
+const (
+	// MAX31855 responds with 32 bits: a signed 14-bit thermocouple temperature, the internal temperature, and
+	// fault bits for open and shorted thermocouples
+	MAX31855 ThermocoupleChip = iota
+	// MAX6675 responds with 16 bits: an unsigned 12-bit temperature and an open thermocouple bit. It needs
+	// about 220ms between readings to finish a conversion
+	MAX6675
+)
+
+// ParseThermocoupleChip returns the chip with the name, like MAX31855 or MAX6675. It returns false for unknown names
+func ParseThermocoupleChip(name string) (ThermocoupleChip, bool) {
+	switch name {
+	case "MAX31855":
+		return MAX31855, true
+	case "MAX6675":
+		return MAX6675, true
+	default:
+		return 0, false
+	}
+}
+
+var (
+	ErrThermocoupleOpen         = errors.New("thermocouple is not connected")
+	ErrThermocoupleShortedToGND = errors.New("thermocouple is shorted to GND")
+	ErrThermocoupleShortedToVCC = errors.New("thermocouple is shorted to VCC")
+)
+
+// Thermocouple reads the bean temperature from a MAX31855 or MAX6675 on an SPI bus
+type Thermocouple struct {
+	chip ThermocoupleChip
+	bus  SPI
+	// cs is the chip select pin, which is held low while reading
+	cs  Pin
+	buf [4]byte
+}
+
+var _ Thermometer = &Thermocouple{}
+
+// NewThermocouple creates a Thermocouple using the bus, which must already be configured, and the chip select pin
+func NewThermocouple(chip ThermocoupleChip, bus SPI, cs Pin) *Thermocouple {
+	cs.Set(true)
+	return &Thermocouple{chip: chip, bus: bus, cs: cs}
+}
+
+// ReadTemperature reads the thermocouple temperature in degrees Celsius. An error is returned if the chip
+// reports a fault
+func (t *Thermocouple) ReadTemperature() (float32, error) {
+	size := 4
+	if t.chip == MAX6675 {
+		size = 2
+	}
+	buf := t.buf[:size]
+
+	t.cs.Set(false)
+	err := t.bus.Tx(nil, buf)
+	t.cs.Set(true)
+	if err != nil {
+		return 0, errors.New("error reading thermocouple: " + err.Error())
+	}
+
+	if t.chip == MAX6675 {
+		return decodeMAX6675(uint16(buf[0])<<8 | uint16(buf[1]))
+	}
+	return decodeMAX31855(uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3]))
+}
+
+func decodeMAX31855(v uint32) (float32, error) {
+	if v&(1<<16) != 0 {
+		switch {
+		case v&0b001 != 0:
+			return 0, ErrThermocoupleOpen
+		case v&0b010 != 0:
+			return 0, ErrThermocoupleShortedToGND
+		case v&0b100 != 0:
+			return 0, ErrThermocoupleShortedToVCC
+		}
+		return 0, errors.New("thermocouple fault")
+	}
+
+	// the top 14 bits are the signed temperature in 0.25C increments, so an arithmetic shift keeps the sign
+	raw := int32(v) >> 18
+	return float32(raw) * 0.25, nil
+}
+
+func decodeMAX6675(v uint16) (float32, error) {
+	if v&(1<<2) != 0 {
+		return 0, ErrThermocoupleOpen
+	}
+
+	// bits 14-3 are the temperature in 0.25C increments
+	raw := (v >> 3) & 0x0FFF
+	return float32(raw) * 0.25, nil
+}
@@ -0,0 +1,105 @@
+package device
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeSPI responds to every read with the same bytes and tracks the chip select pin
+type fakeSPI struct {
+	response []byte
+	err      error
+	// selected is true while the chip select pin is low
+	selected bool
+	reads    int
+}
+
+func (s *fakeSPI) Tx(w, r []byte) error {
+	if !s.selected {
+		return errors.New("chip not selected")
+	}
+	s.reads++
+	copy(r, s.response)
+	return s.err
+}
+
+type chipSelectPin struct {
+	spi *fakeSPI
+}
+
+func (p chipSelectPin) Set(v bool) {
+	p.spi.selected = !v
+}
+
+func TestThermocouple(t *testing.T) {
+	tests := []struct {
+		name     string
+		chip     ThermocoupleChip
+		response []byte
+		want     float32
+		wantErr  error
+	}{
+		// 0x0C80 << 2 is 200C, followed by an internal temperature of 25C
+		{"MAX31855", MAX31855, []byte{0x0C, 0x80, 0x19, 0x00}, 200, nil},
+		{"MAX31855Quarter", MAX31855, []byte{0x0C, 0x84, 0x19, 0x00}, 200.25, nil},
+		{"MAX31855Negative", MAX31855, []byte{0xFF, 0xFC, 0x00, 0x00}, -0.25, nil},
+		{"MAX31855Open", MAX31855, []byte{0x00, 0x01, 0x19, 0x01}, 0, ErrThermocoupleOpen},
+		{"MAX31855ShortedToGND", MAX31855, []byte{0x00, 0x01, 0x19, 0x02}, 0, ErrThermocoupleShortedToGND},
+		{"MAX31855ShortedToVCC", MAX31855, []byte{0x00, 0x01, 0x19, 0x04}, 0, ErrThermocoupleShortedToVCC},
+		// 800 quarter degrees shifted past the 3 status bits
+		{"MAX6675", MAX6675, []byte{0x19, 0x00}, 200, nil},
+		{"MAX6675Open", MAX6675, []byte{0x19, 0x04}, 0, ErrThermocoupleOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spi := &fakeSPI{response: tt.response}
+			tc := NewThermocouple(tt.chip, spi, chipSelectPin{spi})
+			if spi.selected {
+				t.Fatal("chip selected before reading")
+			}
+
+			got, err := tc.ReadTemperature()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadTemperature() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ReadTemperature() = %v, want %v", got, tt.want)
+			}
+			if spi.reads != 1 || spi.selected {
+				t.Errorf("read %d times and selected = %v after reading, want 1 read and deselected", spi.reads, spi.selected)
+			}
+		})
+	}
+
+	t.Run("BusError", func(t *testing.T) {
+		spi := &fakeSPI{response: []byte{0x19, 0x00}, err: errors.New("bus error")}
+		tc := NewThermocouple(MAX6675, spi, chipSelectPin{spi})
+		if _, err := tc.ReadTemperature(); err == nil {
+			t.Error("ReadTemperature() error = nil, want bus error")
+		}
+		if spi.selected {
+			t.Error("chip still selected after error")
+		}
+	})
+}
+
+func TestParseThermocoupleChip(t *testing.T) {
+	tests := []struct {
+		name string
+		chip ThermocoupleChip
+		ok   bool
+	}{
+		{"MAX31855", MAX31855, true},
+		{"MAX6675", MAX6675, true},
+		{"max6675", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		chip, ok := ParseThermocoupleChip(tt.name)
+		if chip != tt.chip || ok != tt.ok {
+			t.Errorf("ParseThermocoupleChip(%q) = %v, %v, want %v, %v", tt.name, chip, ok, tt.chip, tt.ok)
+		}
+	}
+}
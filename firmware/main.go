@@ -1,3 +1,5 @@
+//go:build tinygo
+
 package main
 
 import (
@@ -9,16 +11,26 @@ import (
 	"github.com/calvinmclean/autoroast/firmware/device"
 )
 
+// thermocoupleChip is the thermocouple amplifier on SPI0 (SCK GP2, SDO GP3, SDI GP4, chip select GP5). Build with
+// -ldflags="-X main.thermocoupleChip=MAX6675" to use a MAX6675, or "none" if there isn't one. The pins can only be
+// changed here
+var thermocoupleChip = "MAX31855"
+
 func main() {
 	stepperCfg := device.StepperConfig{
-		Pins:      [4]machine.Pin{machine.GP16, machine.GP17, machine.GP18, machine.GP19},
+		Pins: [4]device.Pin{
+			device.OutputPin(machine.GP16),
+			device.OutputPin(machine.GP17),
+			device.OutputPin(machine.GP18),
+			device.OutputPin(machine.GP19),
+		},
 		StepMode:  device.StepModeHalf,
 		StepDelay: 3000 * time.Microsecond,
 	}
 
-	servoCfg := device.ServoConfig{
-		PWM: machine.PWM3,
-		Pin: machine.GP22,
+	servo, err := device.NewServo(machine.PWM3, machine.GP22)
+	if err != nil {
+		panic(err)
 	}
 	stepsPerIncrement := nominalStepsPerIncrement(30, 9, 8, 4096)
 	calibrationCfg := device.CalibrationConfig{
@@ -31,7 +43,28 @@ func main() {
 		BackstepRatio:         2,
 	}
 
-	d, err := device.New(stepperCfg, servoCfg, calibrationCfg)
+	hw := device.Hardware{
+		Stepper: stepperCfg,
+		Servo:   servo,
+		Serial:  device.MachineSerial{},
+	}
+	if thermocoupleChip != "none" {
+		chip, ok := device.ParseThermocoupleChip(thermocoupleChip)
+		if !ok {
+			panic("unknown thermocouple chip: " + thermocoupleChip)
+		}
+		err = machine.SPI0.Configure(machine.SPIConfig{
+			Frequency: 1_000_000,
+			SCK:       machine.GP2,
+			SDO:       machine.GP3,
+			SDI:       machine.GP4,
+		})
+		if err != nil {
+			panic(err)
+		}
+		hw.Thermometer = device.NewThermocouple(chip, machine.SPI0, device.OutputPin(machine.GP5))
+	}
+	d, err := device.New(hw, calibrationCfg, device.FlashStore{})
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,162 @@
+package autoroast
+
+import "errors"
+
+// FrameStart marks the beginning of a framed request or response
+const FrameStart = 0x01 // ascii SOH (Start of Heading)
+
+// MaxRequestSize is the largest command that fits in a single request frame
+const MaxRequestSize = 0xFF
+
+var (
+	ErrChecksum        = errors.New("invalid frame checksum")
+	ErrMissingEnd      = errors.New("missing frame termination")
+	ErrRequestTooLarge = errors.New("request exceeds max frame size")
+)
+
+// Status is the result code returned by the firmware for a framed request
+type Status byte
+
+const (
+	StatusOK Status = iota
+	StatusError
+	StatusUnknownCommand
+	StatusInvalidFrame
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "OK"
+	case StatusError:
+		return "Error"
+	case StatusUnknownCommand:
+		return "UnknownCommand"
+	case StatusInvalidFrame:
+		return "InvalidFrame"
+	default:
+		return "Unknown"
+	}
+}
+
+// Request is sent from the host to the firmware. The Command can contain one or more firmware commands
+// which are run in order.
+//
+// Encoded format: FrameStart, Seq, len(Command), Command..., checksum
+type Request struct {
+	Seq     byte
+	Command []byte
+}
+
+// Encode returns the framed bytes for the Request
+func (r Request) Encode() ([]byte, error) {
+	if len(r.Command) > MaxRequestSize {
+		return nil, ErrRequestTooLarge
+	}
+
+	out := make([]byte, 0, len(r.Command)+4)
+	out = append(out, FrameStart, r.Seq, byte(len(r.Command)))
+	out = append(out, r.Command...)
+	return append(out, checksum(out[1:])), nil
+}
+
+// DecodeRequest reads a Request using readByte. The leading FrameStart must already be consumed.
+// The returned Request includes the Seq when it was read so errors can be reported for the correct request.
+func DecodeRequest(readByte func() (byte, error)) (Request, error) {
+	var req Request
+	header, err := readBytes(readByte, 2)
+	if err != nil {
+		return req, err
+	}
+	req.Seq = header[0]
+
+	req.Command, err = readBytes(readByte, int(header[1]))
+	if err != nil {
+		return req, err
+	}
+
+	sum, err := readByte()
+	if err != nil {
+		return req, err
+	}
+	if sum != checksum(header, req.Command) {
+		return req, ErrChecksum
+	}
+
+	return req, nil
+}
+
+// Response is sent from the firmware to the host after running a Request. The Payload contains
+// command output for successful requests and the error message for failed requests.
+//
+// Encoded format: FrameStart, Seq, Status, len(Payload) (2 bytes, big endian), Payload..., checksum, TerminationChar
+type Response struct {
+	Seq     byte
+	Status  Status
+	Payload []byte
+}
+
+// Encode returns the framed bytes for the Response. Payloads that are too large are truncated
+func (r Response) Encode() []byte {
+	payload := r.Payload
+	if len(payload) > 0xFFFF {
+		payload = payload[:0xFFFF]
+	}
+
+	out := make([]byte, 0, len(payload)+7)
+	out = append(out, FrameStart, r.Seq, byte(r.Status), byte(len(payload)>>8), byte(len(payload)))
+	out = append(out, payload...)
+	return append(out, checksum(out[1:]), TerminationChar)
+}
+
+// DecodeResponse reads a Response using readByte. The leading FrameStart must already be consumed
+func DecodeResponse(readByte func() (byte, error)) (Response, error) {
+	var resp Response
+	header, err := readBytes(readByte, 4)
+	if err != nil {
+		return resp, err
+	}
+	resp.Seq = header[0]
+	resp.Status = Status(header[1])
+
+	resp.Payload, err = readBytes(readByte, int(header[2])<<8|int(header[3]))
+	if err != nil {
+		return resp, err
+	}
+
+	trailer, err := readBytes(readByte, 2)
+	if err != nil {
+		return resp, err
+	}
+	if trailer[0] != checksum(header, resp.Payload) {
+		return resp, ErrChecksum
+	}
+	if trailer[1] != TerminationChar {
+		return resp, ErrMissingEnd
+	}
+
+	return resp, nil
+}
+
+func readBytes(readByte func() (byte, error), n int) ([]byte, error) {
+	out := make([]byte, n)
+	for i := range out {
+		b, err := readByte()
+		if err != nil {
+			return nil, err
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+// checksum XORs all of the input bytes
+func checksum(in ...[]byte) byte {
+	var sum byte
+	for _, b := range in {
+		for _, v := range b {
+			sum ^= v
+		}
+	}
+	return sum
+}
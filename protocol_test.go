@@ -0,0 +1,99 @@
+package autoroast
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRequestRoundTrip(t *testing.T) {
+	encoded, err := Request{Seq: 7, Command: []byte("F5P4")}.Encode()
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if encoded[0] != FrameStart {
+		t.Fatalf("first byte = %#x, want FrameStart", encoded[0])
+	}
+
+	req, err := DecodeRequest(bytes.NewReader(encoded[1:]).ReadByte)
+	if err != nil {
+		t.Fatalf("DecodeRequest() error = %v", err)
+	}
+	if req.Seq != 7 || string(req.Command) != "F5P4" {
+		t.Errorf("request = %#v, want seq 7 and command F5P4", req)
+	}
+}
+
+func TestRequestTooLarge(t *testing.T) {
+	_, err := Request{Command: make([]byte, MaxRequestSize+1)}.Encode()
+	if !errors.Is(err, ErrRequestTooLarge) {
+		t.Errorf("Encode() error = %v, want ErrRequestTooLarge", err)
+	}
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	// sequence numbers and lengths can contain the TerminationChar without breaking the frame
+	payload := bytes.Repeat([]byte("x"), 0x104)
+	encoded := Response{Seq: TerminationChar, Status: StatusError, Payload: payload}.Encode()
+	if last := encoded[len(encoded)-1]; last != TerminationChar {
+		t.Fatalf("last byte = %#x, want TerminationChar", last)
+	}
+
+	resp, err := DecodeResponse(bytes.NewReader(encoded[1:]).ReadByte)
+	if err != nil {
+		t.Fatalf("DecodeResponse() error = %v", err)
+	}
+	if resp.Seq != TerminationChar || resp.Status != StatusError || !bytes.Equal(resp.Payload, payload) {
+		t.Errorf("response = seq %d status %s len %d, want original", resp.Seq, resp.Status, len(resp.Payload))
+	}
+}
+
+func TestDecodeInvalidChecksum(t *testing.T) {
+	encoded := Response{Seq: 1, Status: StatusOK, Payload: []byte("F5")}.Encode()
+	encoded[len(encoded)-3] = 'X'
+
+	_, err := DecodeResponse(bytes.NewReader(encoded[1:]).ReadByte)
+	if !errors.Is(err, ErrChecksum) {
+		t.Errorf("DecodeResponse() error = %v, want ErrChecksum", err)
+	}
+}
+
+func TestDeviceStatusRoundTrip(t *testing.T) {
+	status := DeviceStatus{
+		Fan:         5,
+		Power:       6,
+		ControlMode: ControlModePower,
+		Started:     true,
+		Elapsed:     90500 * time.Millisecond,
+		Remainder:   0.25,
+		Verbose:     true,
+	}
+
+	text, err := status.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText() error = %v", err)
+	}
+	if got, want := string(text), "fan=5 power=6 mode=Power started=1 elapsed_ms=90500 remainder=0.250 verbose=1"; got != want {
+		t.Errorf("MarshalText() = %q, want %q", got, want)
+	}
+
+	var parsed DeviceStatus
+	if err := parsed.UnmarshalText(text); err != nil {
+		t.Fatalf("UnmarshalText() error = %v", err)
+	}
+	if parsed != status {
+		t.Errorf("UnmarshalText() = %#v, want %#v", parsed, status)
+	}
+}
+
+func TestDeviceStatusInvalid(t *testing.T) {
+	for _, input := range []string{"fan", "fan=10", "elapsed_ms=soon"} {
+		t.Run(input, func(t *testing.T) {
+			var status DeviceStatus
+			if err := status.UnmarshalText([]byte(input)); err == nil {
+				t.Errorf("UnmarshalText(%q) error = nil, want error", input)
+			}
+		})
+	}
+}
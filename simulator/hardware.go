@@ -0,0 +1,210 @@
+package simulator
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/calvinmclean/autoroast/firmware/device"
+)
+
+// readTimeout is how long ReadByte waits for input. The firmware's serial port returns an error instead
+// of blocking when there is no input, and commands rely on that for frame timeouts
+const readTimeout = 10 * time.Millisecond
+
+const (
+	// buttonAngle is the servo angle where it presses the roaster's button. It is between the default base and
+	// click positions
+	buttonAngle = 50
+	// knobStepsPerIncrement is how many stepper steps turn the roaster's knob by one increment. It is the same as
+	// the default calibration
+	knobStepsPerIncrement = 121
+	// knobPause is how long the stepper stops between steps to end a move
+	knobPause = 100 * time.Millisecond
+)
+
+var errNoInput = errors.New("no input")
+
+// DefaultCalibration is the calibration that the simulated firmware starts with. It matches the roaster, so
+// clicks and knob turns aren't missed
+var DefaultCalibration = device.CalibrationConfig{
+	ServoBasePosition:     30,
+	ServoClickPosition:    70,
+	ServoPressDelay:       200 * time.Millisecond,
+	ServoResetDelay:       250 * time.Millisecond,
+	StepsPerIncrement:     knobStepsPerIncrement,
+	DelayAfterStepperMove: 500 * time.Millisecond,
+	BackstepRatio:         2,
+}
+
+// halfStepSequence is the order that the stepper driver's pins are set to turn forward in half steps
+var halfStepSequence = [8][4]bool{
+	{true, false, false, false},
+	{true, true, false, false},
+	{false, true, false, false},
+	{false, true, true, false},
+	{false, false, true, false},
+	{false, false, true, true},
+	{false, false, false, true},
+	{true, false, false, true},
+}
+
+// clock uses the Simulator's functions so tests can control time
+type clock struct {
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+var _ device.Clock = clock{}
+
+func (c clock) Now() time.Time {
+	return c.now()
+}
+
+func (c clock) Sleep(d time.Duration) {
+	c.sleep(d)
+}
+
+// serial connects the firmware to the Simulator's Read and Write
+type serial struct {
+	in     <-chan byte
+	out    chan<- byte
+	closed <-chan struct{}
+}
+
+var _ device.Serial = serial{}
+
+// ReadByte reads input sent to the simulator. It returns io.EOF after the simulator is closed
+func (s serial) ReadByte() (byte, error) {
+	select {
+	case b := <-s.in:
+		return b, nil
+	case <-s.closed:
+		return 0, io.EOF
+	case <-time.After(readTimeout):
+		return 0, errNoInput
+	}
+}
+
+// WriteByte writes output that can be read from the simulator
+func (s serial) WriteByte(b byte) error {
+	select {
+	case s.out <- b:
+		return nil
+	case <-s.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+// log writes a line of output like the firmware's println
+func (s serial) log(line string) {
+	for _, b := range []byte(line + "\r\n") {
+		if s.WriteByte(b) != nil {
+			return
+		}
+	}
+}
+
+// servo clicks the roaster's button when it moves past buttonAngle
+type servo struct {
+	roaster *Roaster
+	clock   device.Clock
+	pressed bool
+}
+
+var _ device.Servo = &servo{}
+
+func (s *servo) SetAngle(angle int) error {
+	pressed := angle >= buttonAngle
+	if pressed && !s.pressed {
+		s.roaster.Click(s.clock.Now())
+	}
+	s.pressed = pressed
+	return nil
+}
+
+// knob decodes the stepper driver's pins to turn the roaster's knob. It turns one increment for every
+// knobStepsPerIncrement steps in the same direction, so the firmware backing up less than an increment after a
+// move doesn't turn it back
+type knob struct {
+	roaster *Roaster
+	clock   device.Clock
+	log     func(string)
+
+	values [4]bool
+	index  int
+	// direction is 1 or -1 for the current move and steps is how far the stepper has moved in it
+	direction int
+	steps     int
+	lastStep  time.Time
+	// turned is how many increments the knob has turned in the current move
+	turned int
+}
+
+type knobPin struct {
+	knob *knob
+	i    int
+}
+
+var _ device.Pin = knobPin{}
+
+func (p knobPin) Set(v bool) {
+	p.knob.values[p.i] = v
+	// the last pin is set after the others, so the step is ready
+	if p.i == 3 {
+		p.knob.update()
+	}
+}
+
+func (k *knob) pins() [4]device.Pin {
+	return [4]device.Pin{knobPin{k, 0}, knobPin{k, 1}, knobPin{k, 2}, knobPin{k, 3}}
+}
+
+func (k *knob) update() {
+	for i, seq := range halfStepSequence {
+		if seq != k.values {
+			continue
+		}
+
+		direction := 1
+		if (i-k.index+len(halfStepSequence))%len(halfStepSequence) > len(halfStepSequence)/2 {
+			direction = -1
+		}
+		k.index = i
+		k.step(direction)
+		return
+	}
+}
+
+func (k *knob) step(direction int) {
+	now := k.clock.Now()
+	if direction != k.direction || now.Sub(k.lastStep) > knobPause {
+		k.direction = direction
+		k.steps = 0
+		k.turned = 0
+	}
+	k.steps++
+	k.lastStep = now
+
+	turned := k.steps / knobStepsPerIncrement
+	if turned == k.turned {
+		return
+	}
+	if !k.roaster.Turn(now, int32(k.direction*(turned-k.turned))) {
+		k.log("[roaster] knob turned outside of select mode")
+	}
+	k.turned = turned
+}
+
+// thermometer reads the simulated roaster's bean temperature
+type thermometer struct {
+	roaster *Roaster
+	clock   device.Clock
+}
+
+var _ device.Thermometer = thermometer{}
+
+func (t thermometer) ReadTemperature() (float32, error) {
+	f := t.roaster.State(t.clock.Now()).BeanTemperature
+	return float32((f - 32) * 5 / 9), nil
+}
@@ -0,0 +1,154 @@
+package simulator
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/calvinmclean/autoroast"
+)
+
+// SelectModeTimeout is how long the FreshRoast's display stays in select mode after the last click or knob turn
+// while it is running
+const SelectModeTimeout = 3 * time.Second
+
+const maxTimer = 99
+
+const (
+	// ambientTemperature is the bean temperature before roasting, in degrees Fahrenheit
+	ambientTemperature = 70
+	// beanTimeConstant is how long it takes the beans to get about 63% of the way to the temperature that the
+	// current settings hold them at
+	beanTimeConstant = 4 * time.Minute
+)
+
+// Roaster models the FreshRoast SR800's display and knob. It has the real settings, which can be different
+// from what the Device expects if clicks or knob turns are missed
+type Roaster struct {
+	mu sync.Mutex
+
+	fan   uint
+	power uint
+	// timer is the remaining time in minutes
+	timer   uint
+	mode    autoroast.ControlMode
+	running bool
+
+	lastInteraction time.Time
+
+	// beanTemperature is in degrees Fahrenheit at heatedAt
+	beanTemperature float64
+	heatedAt        time.Time
+}
+
+// RoasterState is a snapshot of the Roaster's display
+type RoasterState struct {
+	Fan     uint
+	Power   uint
+	Timer   uint
+	Mode    autoroast.ControlMode
+	Running bool
+	// Selecting is true if a click will change the mode and the knob will change the current setting
+	Selecting bool
+	// BeanTemperature is in degrees Fahrenheit
+	BeanTemperature float64
+}
+
+// NewRoaster creates a Roaster with the fan and power already set. It starts in the fan mode
+func NewRoaster(fan, power uint) *Roaster {
+	return &Roaster{
+		fan:   clamp(int32(fan), 1, 9),
+		power: clamp(int32(power), 1, 9),
+		timer: 5,
+		mode:  autoroast.ControlModeFan,
+
+		beanTemperature: ambientTemperature,
+	}
+}
+
+// State returns the current display at the specified time
+func (r *Roaster) State(now time.Time) RoasterState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.heat(now)
+	return RoasterState{
+		Fan:             r.fan,
+		Power:           r.power,
+		Timer:           r.timer,
+		Mode:            r.mode,
+		Running:         r.running,
+		Selecting:       r.selecting(now),
+		BeanTemperature: r.beanTemperature,
+	}
+}
+
+// Start starts roasting. After this, the display leaves select mode if it is not used for SelectModeTimeout
+func (r *Roaster) Start(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.heat(now)
+	r.running = true
+	r.lastInteraction = now
+}
+
+// Click presses the button. In select mode, this changes to the next mode. Otherwise, it only enters
+// select mode without changing the mode
+func (r *Roaster) Click(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.selecting(now) {
+		r.mode = r.mode.Next()
+	}
+	r.lastInteraction = now
+}
+
+// Turn turns the knob by n increments to change the current mode's setting. Fan and power stop at 1 and 9.
+// The knob does nothing outside of select mode, so it returns false
+func (r *Roaster) Turn(now time.Time, n int32) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.selecting(now) {
+		return false
+	}
+	r.lastInteraction = now
+	r.heat(now)
+
+	switch r.mode {
+	case autoroast.ControlModeFan:
+		r.fan = clamp(int32(r.fan)+n, 1, 9)
+	case autoroast.ControlModePower:
+		r.power = clamp(int32(r.power)+n, 1, 9)
+	case autoroast.ControlModeTimer:
+		r.timer = clamp(int32(r.timer)+n, 0, maxTimer)
+	}
+	return true
+}
+
+// heat moves the bean temperature toward the temperature that the fan and power settings hold the beans at. Power
+// adds heat and the fan removes it. It must be called with mu locked before the settings change
+func (r *Roaster) heat(now time.Time) {
+	if !r.heatedAt.IsZero() && now.After(r.heatedAt) {
+		target := float64(ambientTemperature)
+		if r.running {
+			target += 55*float64(r.power) - 15*float64(r.fan)
+		}
+		elapsed := now.Sub(r.heatedAt).Seconds()
+		r.beanTemperature += (target - r.beanTemperature) * (1 - math.Exp(-elapsed/beanTimeConstant.Seconds()))
+	}
+	if now.After(r.heatedAt) {
+		r.heatedAt = now
+	}
+}
+
+// selecting must be called with mu locked
+func (r *Roaster) selecting(now time.Time) bool {
+	return !r.running || now.Sub(r.lastInteraction) <= SelectModeTimeout
+}
+
+func clamp(v, low, high int32) uint {
+	return uint(max(low, min(v, high)))
+}
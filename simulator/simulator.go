@@ -0,0 +1,155 @@
+// Package simulator runs the firmware's commands against a simulated FreshRoast so the controller, UI, and
+// replay files can be used without hardware
+package simulator
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/calvinmclean/autoroast/firmware/commands"
+	"github.com/calvinmclean/autoroast/firmware/device"
+)
+
+const bufferSize = 4096
+
+// Config sets the simulated roaster's settings before anything is changed
+type Config struct {
+	Fan   uint
+	Power uint
+}
+
+// Simulator is a serial port connected to the firmware's Device, which controls a simulated roaster instead of
+// the servo and stepper motors
+type Simulator struct {
+	roaster *Roaster
+
+	in     chan byte
+	out    chan byte
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+var _ io.ReadWriteCloser = &Simulator{}
+
+// New starts the firmware with a simulated roaster. Clicks and knob turns take as long as they do with the
+// real device
+func New(cfg Config) *Simulator {
+	return newSimulator(cfg, time.Now, time.Sleep)
+}
+
+func newSimulator(cfg Config, now func() time.Time, sleep func(time.Duration)) *Simulator {
+	s := &Simulator{
+		in:     make(chan byte, bufferSize),
+		out:    make(chan byte, bufferSize),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	s.roaster = NewRoaster(cfg.Fan, cfg.Power)
+
+	clk := clock{now: now, sleep: sleep}
+	port := serial{in: s.in, out: s.out, closed: s.closed}
+	knob := &knob{roaster: s.roaster, clock: clk, log: port.log}
+	d, err := device.New(device.Hardware{
+		Stepper:     device.StepperConfig{Pins: knob.pins(), StepMode: device.StepModeHalf},
+		Servo:       &servo{roaster: s.roaster, clock: clk},
+		Serial:      port,
+		Clock:       clk,
+		Thermometer: thermometer{roaster: s.roaster, clock: clk},
+		Log:         port.log,
+	}, DefaultCalibration, nil)
+	if err != nil {
+		// the simulated hardware always has every stepper pin and a valid step mode
+		panic(err)
+	}
+
+	go func() {
+		defer close(s.done)
+		commands.Run(firmware{Device: &d, roaster: s.roaster, clock: clk})
+	}()
+
+	return s
+}
+
+// firmware is the firmware's Device. The roaster is started with it since someone would press the roaster's start
+// button at the same time on the real device
+type firmware struct {
+	*device.Device
+	roaster *Roaster
+	clock   device.Clock
+}
+
+var _ commands.Device = firmware{}
+
+func (f firmware) Start() error {
+	err := f.Device.Start()
+	if err == nil {
+		f.roaster.Start(f.clock.Now())
+	}
+	return err
+}
+
+func (f firmware) Resume(elapsed time.Duration) error {
+	err := f.Device.Resume(elapsed)
+	if err == nil {
+		f.roaster.Start(f.clock.Now())
+	}
+	return err
+}
+
+// Roaster returns the simulated FreshRoast
+func (s *Simulator) Roaster() *Roaster {
+	return s.roaster
+}
+
+// Write sends input to the firmware
+func (s *Simulator) Write(p []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	for i, b := range p {
+		select {
+		case s.in <- b:
+		case <-s.closed:
+			return i, io.ErrClosedPipe
+		}
+	}
+	return len(p), nil
+}
+
+// Read waits for output from the firmware
+func (s *Simulator) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	select {
+	case p[0] = <-s.out:
+	case <-s.closed:
+		return 0, io.EOF
+	}
+
+	n := 1
+	for n < len(p) {
+		select {
+		case p[n] = <-s.out:
+			n++
+		default:
+			return n, nil
+		}
+	}
+	return n, nil
+}
+
+// Close stops the firmware
+func (s *Simulator) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+	<-s.done
+	return nil
+}
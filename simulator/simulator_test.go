@@ -0,0 +1,229 @@
+package simulator
+
+import (
+	"bufio"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/calvinmclean/autoroast"
+)
+
+// fakeClock only moves forward when sleeping so tests don't have to wait for clicks and knob turns
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestSimulator(t *testing.T, cfg Config) (*Simulator, *fakeClock) {
+	t.Helper()
+
+	clock := &fakeClock{now: time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)}
+	s := newSimulator(cfg, clock.Now, clock.Sleep)
+	t.Cleanup(func() { s.Close() })
+
+	return s, clock
+}
+
+// send writes a request frame and returns the firmware's output and response
+func send(t *testing.T, s *Simulator, reader *bufio.Reader, seq byte, command string) (string, autoroast.Response) {
+	t.Helper()
+
+	req, err := autoroast.Request{Seq: seq, Command: []byte(command)}.Encode()
+	if err != nil {
+		t.Fatalf("error encoding %q: %v", command, err)
+	}
+	if _, err := s.Write(req); err != nil {
+		t.Fatalf("error writing %q: %v", command, err)
+	}
+
+	var output strings.Builder
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			t.Fatalf("error reading response to %q: %v", command, err)
+		}
+		if b == autoroast.FrameStart {
+			break
+		}
+		output.WriteByte(b)
+	}
+
+	resp, err := autoroast.DecodeResponse(reader.ReadByte)
+	if err != nil {
+		t.Fatalf("error decoding response to %q: %v", command, err)
+	}
+	return output.String(), resp
+}
+
+func TestRoasterSelectMode(t *testing.T) {
+	now := time.Now()
+	r := NewRoaster(5, 5)
+
+	r.Click(now)
+	if got := r.State(now).Mode; got != autoroast.ControlModePower {
+		t.Errorf("mode before starting = %v, want Power", got)
+	}
+
+	r.Start(now)
+	now = now.Add(SelectModeTimeout + time.Second)
+	if r.Turn(now, 1) {
+		t.Error("Turn() = true outside of select mode, want false")
+	}
+
+	// the first click only enters select mode again
+	r.Click(now)
+	if got := r.State(now).Mode; got != autoroast.ControlModePower {
+		t.Errorf("mode after entering select mode = %v, want Power", got)
+	}
+
+	r.Turn(now, 10)
+	if got := r.State(now).Power; got != 9 {
+		t.Errorf("power = %d, want 9 after turning past the maximum", got)
+	}
+}
+
+func TestSimulatorRunsCommands(t *testing.T) {
+	s, clock := newTestSimulator(t, Config{Fan: 5, Power: 5})
+	reader := bufio.NewReader(s)
+
+	for i, command := range []string{"I55", "S", "F7", "P3"} {
+		output, resp := send(t, s, reader, byte(i+1), command)
+		if resp.Status != autoroast.StatusOK {
+			t.Fatalf("%q responded %v: %s", command, resp.Status, resp.Payload)
+		}
+		if command == "S" && !strings.Contains(output, "Started...") {
+			t.Errorf("output for S = %q, want start log", output)
+		}
+
+		// wait long enough for select mode to time out between commands
+		clock.Sleep(5 * time.Second)
+	}
+
+	state := s.Roaster().State(clock.Now())
+	if state.Fan != 7 || state.Power != 3 {
+		t.Errorf("roaster = F%d/P%d, want F7/P3", state.Fan, state.Power)
+	}
+
+	_, resp := send(t, s, reader, 5, "Q")
+	var status autoroast.DeviceStatus
+	if err := status.UnmarshalText(resp.Payload); err != nil {
+		t.Fatalf("error parsing status: %v", err)
+	}
+	if status.Fan != 7 || status.Power != 3 || status.ControlMode != autoroast.ControlModePower || !status.Started {
+		t.Errorf("status = %+v, want started at F7/P3 in Power mode", status)
+	}
+}
+
+func TestSimulatorShowsWrongInitialSettings(t *testing.T) {
+	s, clock := newTestSimulator(t, Config{Fan: 1, Power: 1})
+	reader := bufio.NewReader(s)
+
+	// the device is told the roaster is at F5, so it only moves up by 2
+	send(t, s, reader, 1, "I55")
+	send(t, s, reader, 2, "F7")
+
+	if got := s.Roaster().State(clock.Now()).Fan; got != 3 {
+		t.Errorf("roaster fan = %d, want 3", got)
+	}
+}
+
+func TestSimulatorSingleIncrements(t *testing.T) {
+	s, clock := newTestSimulator(t, Config{Fan: 5, Power: 5})
+	reader := bufio.NewReader(s)
+
+	// single increments move an extra half increment and then back up, which only turns the knob once
+	for i, tt := range []struct {
+		command    string
+		fan, power uint
+	}{
+		{"I55", 5, 5},
+		{"S", 5, 5},
+		{"F+", 6, 5},
+		{"F+", 7, 5},
+		{"F-", 6, 5},
+		{"P-", 6, 4},
+		{"F9", 9, 4},
+	} {
+		send(t, s, reader, byte(i+1), tt.command)
+		clock.Sleep(5 * time.Second)
+
+		state := s.Roaster().State(clock.Now())
+		if state.Fan != tt.fan || state.Power != tt.power {
+			t.Errorf("roaster after %q = F%d/P%d, want F%d/P%d", tt.command, state.Fan, state.Power, tt.fan, tt.power)
+		}
+	}
+}
+
+func TestSimulatorInvalidCommand(t *testing.T) {
+	s, _ := newTestSimulator(t, Config{Fan: 5, Power: 5})
+	reader := bufio.NewReader(s)
+
+	_, resp := send(t, s, reader, 1, "F0")
+	if resp.Status != autoroast.StatusError {
+		t.Errorf("status = %v, want Error", resp.Status)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := s.Write([]byte{'F'}); err == nil {
+		t.Error("Write() after Close() error = nil, want error")
+	}
+}
+
+func TestRoasterBeanTemperature(t *testing.T) {
+	now := time.Now()
+	r := NewRoaster(5, 9)
+
+	if got := r.State(now).BeanTemperature; got != ambientTemperature {
+		t.Errorf("temperature before starting = %v, want %v", got, ambientTemperature)
+	}
+
+	now = now.Add(time.Minute)
+	r.Start(now)
+	first := r.State(now.Add(4 * time.Minute)).BeanTemperature
+	// about 63% of the way from 70F to the 490F that F5/P9 holds the beans at
+	if first < 320 || first > 345 {
+		t.Errorf("temperature after 4m = %v, want about 335", first)
+	}
+
+	// turning the power down cools the beans
+	r.Click(now.Add(4 * time.Minute))
+	r.Click(now.Add(4 * time.Minute))
+	r.Turn(now.Add(4*time.Minute), -8)
+	if got := r.State(now.Add(8 * time.Minute)).BeanTemperature; got >= first {
+		t.Errorf("temperature after lowering power = %v, want less than %v", got, first)
+	}
+}
+
+func TestSimulatorTemperature(t *testing.T) {
+	s, clock := newTestSimulator(t, Config{Fan: 5, Power: 9})
+	reader := bufio.NewReader(s)
+
+	send(t, s, reader, 1, "I59")
+	send(t, s, reader, 2, "S")
+	clock.Sleep(4 * time.Minute)
+
+	_, resp := send(t, s, reader, 3, "B")
+	var reading autoroast.TemperatureReading
+	if err := reading.UnmarshalText(resp.Payload); err != nil {
+		t.Fatalf("error parsing reading %q: %v", resp.Payload, err)
+	}
+	if reading.Bean < 320 || reading.Elapsed < 4*time.Minute {
+		t.Errorf("reading = %+v, want a hot reading after 4m", reading)
+	}
+}
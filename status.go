@@ -0,0 +1,86 @@
+package autoroast
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeviceStatus is the firmware's current state. It is encoded as space-separated key=value pairs like:
+//
+//	fan=5 power=6 mode=Fan started=1 elapsed_ms=90500 remainder=0.250 verbose=0
+type DeviceStatus struct {
+	Fan         uint
+	Power       uint
+	ControlMode ControlMode
+	Started     bool
+	// Elapsed is the duration since the device was started
+	Elapsed time.Duration
+	// Remainder is the fractional stepper movement carried over to the next move
+	Remainder float32
+	Verbose   bool
+}
+
+// MarshalText encodes the DeviceStatus as key=value pairs
+func (s DeviceStatus) MarshalText() ([]byte, error) {
+	out := "fan=" + strconv.FormatUint(uint64(s.Fan), 10)
+	out += " power=" + strconv.FormatUint(uint64(s.Power), 10)
+	out += " mode=" + s.ControlMode.String()
+	out += " started=" + boolStr(s.Started)
+	out += " elapsed_ms=" + strconv.FormatInt(s.Elapsed.Milliseconds(), 10)
+	out += " remainder=" + strconv.FormatFloat(float64(s.Remainder), 'f', 3, 32)
+	out += " verbose=" + boolStr(s.Verbose)
+	return []byte(out), nil
+}
+
+// UnmarshalText parses key=value pairs created by MarshalText. Unknown keys are ignored
+func (s *DeviceStatus) UnmarshalText(text []byte) error {
+	for _, field := range strings.Fields(string(text)) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return errors.New("invalid status field: " + field)
+		}
+
+		var err error
+		switch key {
+		case "fan":
+			s.Fan, err = parseLevel(value)
+		case "power":
+			s.Power, err = parseLevel(value)
+		case "mode":
+			s.ControlMode = ParseControlMode(value)
+		case "started":
+			s.Started = value == "1"
+		case "elapsed_ms":
+			var ms int64
+			ms, err = strconv.ParseInt(value, 10, 64)
+			s.Elapsed = time.Duration(ms) * time.Millisecond
+		case "remainder":
+			var r float64
+			r, err = strconv.ParseFloat(value, 32)
+			s.Remainder = float32(r)
+		case "verbose":
+			s.Verbose = value == "1"
+		}
+		if err != nil {
+			return errors.New("invalid status value for " + key + ": " + value)
+		}
+	}
+	return nil
+}
+
+func parseLevel(value string) (uint, error) {
+	level, err := strconv.ParseUint(value, 10, 8)
+	if err != nil || level > 9 {
+		return 0, errors.New("invalid level")
+	}
+	return uint(level), nil
+}
+
+func boolStr(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
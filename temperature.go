@@ -0,0 +1,66 @@
+package autoroast
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TemperaturePrefix starts each line of the firmware's temperature stream so readings can be separated from
+// the rest of its output
+const TemperaturePrefix = "temp "
+
+// TemperatureReading is the bean temperature measured by the firmware's thermocouple. It is encoded as
+// space-separated key=value pairs like:
+//
+//	elapsed_ms=90500 bean=401.50
+type TemperatureReading struct {
+	// Elapsed is the duration since the device was started. It is zero if not started
+	Elapsed time.Duration
+	// Bean is the bean temperature in degrees Fahrenheit
+	Bean float32
+}
+
+// MarshalText encodes the TemperatureReading as key=value pairs
+func (r TemperatureReading) MarshalText() ([]byte, error) {
+	out := "elapsed_ms=" + strconv.FormatInt(r.Elapsed.Milliseconds(), 10)
+	out += " bean=" + strconv.FormatFloat(float64(r.Bean), 'f', 2, 32)
+	return []byte(out), nil
+}
+
+// UnmarshalText parses key=value pairs created by MarshalText. Unknown keys are ignored
+func (r *TemperatureReading) UnmarshalText(text []byte) error {
+	var hasBean bool
+	for _, field := range strings.Fields(string(text)) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return errors.New("invalid temperature field: " + field)
+		}
+
+		var err error
+		switch key {
+		case "elapsed_ms":
+			var ms int64
+			ms, err = strconv.ParseInt(value, 10, 64)
+			r.Elapsed = time.Duration(ms) * time.Millisecond
+		case "bean":
+			var bean float64
+			bean, err = strconv.ParseFloat(value, 32)
+			r.Bean = float32(bean)
+			hasBean = true
+		}
+		if err != nil {
+			return errors.New("invalid temperature value for " + key + ": " + value)
+		}
+	}
+	if !hasBean {
+		return errors.New("missing bean temperature")
+	}
+	return nil
+}
+
+// CelsiusToFahrenheit converts thermocouple readings to the unit used for roasting
+func CelsiusToFahrenheit(c float32) float32 {
+	return c*9/5 + 32
+}
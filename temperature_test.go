@@ -0,0 +1,40 @@
+package autoroast
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTemperatureReadingRoundTrip(t *testing.T) {
+	reading := TemperatureReading{Elapsed: 90500 * time.Millisecond, Bean: 401.5}
+
+	text, err := reading.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText() error = %v", err)
+	}
+	if got, want := string(text), "elapsed_ms=90500 bean=401.50"; got != want {
+		t.Errorf("MarshalText() = %q, want %q", got, want)
+	}
+
+	var parsed TemperatureReading
+	if err := parsed.UnmarshalText(text); err != nil {
+		t.Fatalf("UnmarshalText() error = %v", err)
+	}
+	if parsed != reading {
+		t.Errorf("UnmarshalText() = %+v, want %+v", parsed, reading)
+	}
+
+	for _, invalid := range []string{"elapsed_ms=100", "bean=hot", "bean"} {
+		if err := parsed.UnmarshalText([]byte(invalid)); err == nil {
+			t.Errorf("UnmarshalText(%q) error = nil, want error", invalid)
+		}
+	}
+}
+
+func TestCelsiusToFahrenheit(t *testing.T) {
+	for c, want := range map[float32]float32{0: 32, 100: 212, 200: 392, -40: -40} {
+		if got := CelsiusToFahrenheit(c); got != want {
+			t.Errorf("CelsiusToFahrenheit(%v) = %v, want %v", c, got, want)
+		}
+	}
+}
@@ -3,10 +3,13 @@ package twchart
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,9 +19,66 @@ import (
 
 type Probes []twchart.Probe
 
+// Session is a session recorded by TWChart
+type Session = twchart.Session
+
+// Stage is a stage in a Session
+type Stage = twchart.Stage
+
+// Event is a note in a Session
+type Event = twchart.Event
+
+// ProbePosition is where a probe is connected to the Thermoworks device
+type ProbePosition = twchart.ProbePosition
+
+// Data is a temperature from each of a Session's probes at the same time. ProbeData is indexed by ProbePosition-1
+// and missing temperatures are -1
+type Data = twchart.ThermoworksData
+
+// ErrNotLatestSession is returned by AddData when the Client's session isn't the most recently created one, since
+// TWChart only adds uploaded data to that session
+var ErrNotLatestSession = errors.New("session is not the latest TWChart session")
+
+// StatusError is returned when TWChart responds with an unexpected status code
+type StatusError struct {
+	StatusCode int
+	Response   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d, response: %v", e.StatusCode, e.Response)
+}
+
+// IsPermanent returns true if the error is a response from TWChart that won't change if the request is sent again,
+// like a 4xx status code for an invalid request or a session that was deleted. Network errors, 5xx status codes,
+// and rate limits can succeed later
+func IsPermanent(err error) bool {
+	var statusCode int
+	var statusErr *StatusError
+	var respErr *babyapi.ErrResponse
+	switch {
+	case errors.As(err, &statusErr):
+		statusCode = statusErr.StatusCode
+	case errors.As(err, &respErr):
+		statusCode = respErr.HTTPStatusCode
+	default:
+		return false
+	}
+
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	default:
+		return statusCode >= 400 && statusCode < 500
+	}
+}
+
 type Client struct {
 	client    *babyapi.Client[*session]
 	sessionID string
+	// latestSessionID is the most recently created session the last time AddData listed them. It is only listed
+	// again when it isn't sessionID so every upload doesn't list all of the sessions
+	latestSessionID string
 }
 
 type session struct {
@@ -54,6 +114,28 @@ func (c *Client) CreateSession(ctx context.Context, beanName string, probes Prob
 	return resp.Data.GetID(), nil
 }
 
+// ResumeSession makes sure the session exists and uses it instead of creating a new one
+func (c *Client) ResumeSession(ctx context.Context, id string) error {
+	resp, err := c.client.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	c.sessionID = resp.Data.GetID()
+
+	return nil
+}
+
+// GetSession fetches a recorded session
+func (c *Client) GetSession(ctx context.Context, id string) (Session, error) {
+	resp, err := c.client.Get(ctx, id)
+	if err != nil {
+		return Session{}, err
+	}
+
+	return resp.Data.Session, nil
+}
+
 func (c Client) SetStartTime(ctx context.Context, startTime time.Time) error {
 	_, err := c.client.Patch(ctx, c.sessionID, &session{Session: twchart.Session{
 		StartTime: startTime,
@@ -79,11 +161,109 @@ func (c Client) AddStage(ctx context.Context, name string, now time.Time) error
 	return c.makeRequest(ctx, url, s)
 }
 
-func (c Client) Done(ctx context.Context) error {
+func (c Client) Done(ctx context.Context, now time.Time) error {
 	url, _ := c.client.URL(c.sessionID)
 	url += "/done"
 
-	return c.makeRequest(ctx, url, map[string]any{"time": time.Now()})
+	return c.makeRequest(ctx, url, map[string]any{"time": now})
+}
+
+// AddData uploads probe temperatures in the same CSV format as Thermoworks so the session's chart is updated
+// during the roast. TWChart adds uploaded data to its most recently created session, so ErrNotLatestSession is
+// returned instead of uploading if that isn't the Client's session. Once the session is the latest one, it isn't
+// checked again
+func (c *Client) AddData(ctx context.Context, data []Data) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	if c.latestSessionID != c.sessionID {
+		latest, err := c.getLatestSessionID(ctx)
+		if err != nil {
+			return err
+		}
+		c.latestSessionID = latest
+		if latest != c.sessionID {
+			return fmt.Errorf("%w: data for %s would be added to %s", ErrNotLatestSession, c.sessionID, latest)
+		}
+	}
+
+	body, err := encodeDataCSV(data)
+	if err != nil {
+		return fmt.Errorf("error encoding data: %w", err)
+	}
+
+	url, _ := c.client.URL("")
+	url += "/upload-csv"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Add("Content-Type", "text/csv")
+
+	return c.send(req, http.StatusOK)
+}
+
+// getLatestSessionID returns the ID of the most recently created session, which TWChart adds uploaded data to
+func (c Client) getLatestSessionID(ctx context.Context) (string, error) {
+	resp, err := c.client.Search(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("error listing sessions: %w", err)
+	}
+	if resp.Data == nil {
+		return "", fmt.Errorf("unexpected response listing sessions: %q", resp.Body)
+	}
+
+	var latest *session
+	for _, s := range resp.Data.Items {
+		if latest == nil || s.UploadedAt.After(latest.UploadedAt) {
+			latest = s
+		}
+	}
+	if latest == nil {
+		return "", nil
+	}
+	return latest.GetID(), nil
+}
+
+// encodeDataCSV writes a header with a column for each ProbePosition followed by a row for each Data
+func encodeDataCSV(data []Data) ([]byte, error) {
+	var probes int
+	for _, d := range data {
+		probes = max(probes, len(d.ProbeData))
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	row := []string{"DateTime"}
+	for i := range probes {
+		row = append(row, "Probe "+strconv.Itoa(i+1))
+	}
+	err := w.Write(row)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range data {
+		row = append(row[:0], d.Time.Local().Format(time.DateTime))
+		for i := range probes {
+			value := ""
+			if i < len(d.ProbeData) && d.ProbeData[i] > 0 {
+				value = strconv.FormatFloat(d.ProbeData[i], 'f', 2, 64)
+			}
+			row = append(row, value)
+		}
+		err = w.Write(row)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
 }
 
 func (c Client) makeRequest(ctx context.Context, url string, body any) error {
@@ -104,12 +284,17 @@ func (c Client) makeRequest(ctx context.Context, url string, body any) error {
 
 	req.Header.Add("Content-Type", "application/json")
 
+	return c.send(req, http.StatusNoContent)
+}
+
+// send makes the request and returns an error if the response does not have the expected status code
+func (c Client) send(req *http.Request, expectedStatusCode int) error {
 	resp, err := c.client.MakeGenericRequest(req, nil)
 	if err != nil {
 		return fmt.Errorf("error making request: %w", err)
 	}
-	if resp.Response.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("unexpected status code: %d, response: %v", resp.Response.StatusCode, resp.Body)
+	if resp.Response.StatusCode != expectedStatusCode {
+		return &StatusError{StatusCode: resp.Response.StatusCode, Response: resp.Body}
 	}
 
 	return nil
@@ -1,9 +1,18 @@
 package twchart
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/calvinmclean/babyapi"
+	"github.com/calvinmclean/twchart"
 )
 
 func TestJSON(t *testing.T) {
@@ -15,3 +24,85 @@ func TestJSON(t *testing.T) {
 	}
 	fmt.Println(s)
 }
+
+func TestEncodeDataCSV(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.Local)
+	data := []Data{
+		{Time: start, ProbeData: []float64{75, 300.5}},
+		{Time: start.Add(time.Second), ProbeData: []float64{-1, 302.25}},
+		{Time: start.Add(2 * time.Second), ProbeData: []float64{76}},
+	}
+
+	got, err := encodeDataCSV(data)
+	if err != nil {
+		t.Fatalf("encodeDataCSV() error = %v", err)
+	}
+
+	want := "DateTime,Probe 1,Probe 2\n" +
+		"2025-06-01 10:00:00,75.00,300.50\n" +
+		"2025-06-01 10:00:01,,302.25\n" +
+		"2025-06-01 10:00:02,76.00,\n"
+	if string(got) != want {
+		t.Errorf("encodeDataCSV() = %q, want %q", got, want)
+	}
+}
+
+func TestAddDataOnlyToLatestSession(t *testing.T) {
+	older := &session{Session: twchart.Session{ID: babyapi.NewID(), UploadedAt: time.Now().Add(-time.Hour)}}
+	latest := &session{Session: twchart.Session{ID: babyapi.NewID(), UploadedAt: time.Now()}}
+
+	var lists, uploads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.TrimSuffix(r.URL.Path, "/") == "/sessions":
+			lists++
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"items": []*session{latest, older}})
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/upload-csv"):
+			uploads++
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	data := []Data{{Time: time.Now(), ProbeData: []float64{75, 300}}}
+
+	client.sessionID = older.GetID()
+	err := client.AddData(context.Background(), data)
+	if !errors.Is(err, ErrNotLatestSession) || uploads != 0 {
+		t.Errorf("AddData() error = %v with %d uploads, want ErrNotLatestSession without uploading", err, uploads)
+	}
+
+	client.sessionID = latest.GetID()
+	err = client.AddData(context.Background(), data)
+	if err != nil || uploads != 1 {
+		t.Errorf("AddData() error = %v with %d uploads, want one upload", err, uploads)
+	}
+
+	// the sessions aren't listed again once the session is the latest one
+	err = client.AddData(context.Background(), data)
+	if err != nil || uploads != 2 || lists != 2 {
+		t.Errorf("AddData() error = %v with %d uploads and %d lists, want 2 uploads and 2 lists", err, uploads, lists)
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection refused"), false},
+		{fmt.Errorf("error making request: %w", &StatusError{StatusCode: http.StatusBadRequest}), true},
+		{&StatusError{StatusCode: http.StatusTooManyRequests}, false},
+		{&StatusError{StatusCode: http.StatusBadGateway}, false},
+		{&babyapi.ErrResponse{HTTPStatusCode: http.StatusNotFound}, true},
+		{&babyapi.ErrResponse{HTTPStatusCode: http.StatusInternalServerError}, false},
+	} {
+		if got := IsPermanent(tt.err); got != tt.want {
+			t.Errorf("IsPermanent(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/calvinmclean/autoroast"
+	"github.com/calvinmclean/autoroast/controller"
+)
+
+// createCalibrationItem creates an accordion item with an entry for each calibration field. Values are read from
+// the device with the Load button and each field is saved to the device when it is set. The roaster is replaced
+// when a roast is configured, so currentRoaster is called each time it is used and must be safe to call from any
+// goroutine
+func createCalibrationItem(
+	ctx context.Context,
+	currentRoaster func() *controller.Controller,
+	onError func(error),
+) *widget.AccordionItem {
+	load := func() (autoroast.CalibrationValues, error) {
+		roaster := currentRoaster()
+		if roaster == nil {
+			return nil, errors.New("controller is not running")
+		}
+		return roaster.Calibration(ctx)
+	}
+	save := func(field autoroast.CalibrationField, value float64) error {
+		roaster := currentRoaster()
+		if roaster == nil {
+			return errors.New("controller is not running")
+		}
+		return roaster.SetCalibration(ctx, field, value)
+	}
+
+	entries := map[autoroast.CalibrationField]*widget.Entry{}
+	fields := container.NewGridWithColumns(3)
+	for _, field := range autoroast.CalibrationFields {
+		entry := widget.NewEntry()
+		entries[field] = entry
+
+		set := func() {
+			value, err := strconv.ParseFloat(entry.Text, 64)
+			if err != nil {
+				onError(fmt.Errorf("invalid value for %s: %q", field, entry.Text))
+				return
+			}
+			go func() {
+				err := save(field, value)
+				if err != nil {
+					fyne.Do(func() { onError(err) })
+				}
+			}()
+		}
+		entry.OnSubmitted = func(string) { set() }
+
+		fields.Add(widget.NewLabel(calibrationLabel(field)))
+		fields.Add(entry)
+		fields.Add(widget.NewButton("Set", set))
+	}
+
+	loadButton := widget.NewButtonWithIcon("Load", theme.ViewRefreshIcon(), func() {
+		go func() {
+			values, err := load()
+			fyne.Do(func() {
+				if err != nil {
+					onError(err)
+					return
+				}
+				for field, value := range values {
+					if entry, ok := entries[field]; ok {
+						entry.SetText(strconv.FormatFloat(value, 'f', -1, 64))
+					}
+				}
+			})
+		}()
+	})
+
+	return widget.NewAccordionItem("Calibration", container.NewVBox(fields, loadButton))
+}
+
+// calibrationLabel includes the unit for duration fields
+func calibrationLabel(field autoroast.CalibrationField) string {
+	switch field {
+	case autoroast.CalibrationServoPressDelay,
+		autoroast.CalibrationServoResetDelay,
+		autoroast.CalibrationDelayAfterStepperMove:
+		return field.String() + " (ms)"
+	default:
+		return field.String()
+	}
+}
@@ -0,0 +1,73 @@
+package ui
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+	"fyne.io/fyne/v2/widget"
+	"github.com/calvinmclean/autoroast"
+	"github.com/calvinmclean/autoroast/controller"
+)
+
+func TestCalibrationLabel(t *testing.T) {
+	for field, want := range map[autoroast.CalibrationField]string{
+		autoroast.CalibrationServoBasePosition: "ServoBasePosition",
+		autoroast.CalibrationServoPressDelay:   "ServoPressDelay (ms)",
+		autoroast.CalibrationBackstepRatio:     "BackstepRatio",
+	} {
+		if got := calibrationLabel(field); got != want {
+			t.Errorf("calibrationLabel(%s) = %q, want %q", field, got, want)
+		}
+	}
+}
+
+func TestCalibrationItemSetsField(t *testing.T) {
+	app := test.NewApp()
+	defer app.Quit()
+
+	roaster, err := controller.New(controller.Config{SerialPort: controller.SerialPortSim, BaudRate: "115200"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer roaster.Close()
+
+	errs := make(chan error, 1)
+	item := createCalibrationItem(context.Background(), func() *controller.Controller { return roaster }, func(err error) {
+		errs <- err
+	})
+
+	// each field has a label, entry, and Set button
+	fields := item.Detail.(*fyne.Container).Objects[0].(*fyne.Container).Objects
+	for i := 0; i < len(fields); i += 3 {
+		if fields[i].(*widget.Label).Text != calibrationLabel(autoroast.CalibrationBackstepRatio) {
+			continue
+		}
+		test.Type(fields[i+1].(*widget.Entry), "3")
+		test.Tap(fields[i+2].(*widget.Button))
+	}
+
+	// the K command is sent in the background, so the value is read back from the firmware until it changes
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		select {
+		case err := <-errs:
+			t.Fatalf("error setting BackstepRatio: %v", err)
+		default:
+		}
+
+		values, err := roaster.Calibration(context.Background())
+		if err != nil {
+			t.Fatalf("Calibration() error = %v", err)
+		}
+		if values[autoroast.CalibrationBackstepRatio] == 3 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("BackstepRatio = %v, want 3 after setting it", values[autoroast.CalibrationBackstepRatio])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,434 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/calvinmclean/autoroast/controller"
+	nativeDialog "github.com/sqweek/dialog"
+)
+
+const (
+	// the axes start at these so they don't jump around at the beginning of a roast
+	chartMinDuration    = 10 * time.Minute
+	chartMinTemperature = 500.0
+	chartMinRoR         = 30.0
+
+	// chartMargin is the space around the plot for the axis labels
+	chartMargin    = 40
+	chartLabelSize = 10
+	// chartNoteLength is the most characters of a note that are shown on its marker
+	chartNoteLength = 16
+)
+
+var (
+	beanColor          = color.RGBA{R: 200, G: 40, B: 40, A: 255}
+	ambientColor       = color.RGBA{R: 50, G: 110, B: 200, A: 255}
+	rorColor           = color.RGBA{R: 40, G: 150, B: 70, A: 255}
+	stageMarkerColor   = color.RGBA{R: 139, G: 0, B: 0, A: 200}
+	settingMarkerColor = color.RGBA{R: 128, G: 128, B: 128, A: 160}
+	noteMarkerColor    = color.RGBA{R: 220, G: 140, B: 0, A: 200}
+)
+
+// referenceColor fades a color so the reference roast is drawn behind the live one
+func referenceColor(c color.RGBA) color.RGBA {
+	c.A = 80
+	return c
+}
+
+// roastChart plots a roast's bean and ambient temperatures and rate of rise over time, with lines for its stages,
+// fan and power changes, and notes. A reference roast can be drawn behind it to roast against. The curves are
+// changed on the main goroutine, so Refresh is called after adding to them
+type roastChart struct {
+	widget.BaseWidget
+	curve     *controller.RoastCurve
+	reference *controller.RoastCurve
+	objects   chartObjects
+}
+
+func newRoastChart() *roastChart {
+	chart := &roastChart{}
+	chart.ExtendBaseWidget(chart)
+	return chart
+}
+
+// SetCurve sets the roast that is charted
+func (c *roastChart) SetCurve(curve *controller.RoastCurve) {
+	c.curve = curve
+	c.Refresh()
+}
+
+// SetReference sets the roast that is drawn behind the curve. It is removed if reference is nil
+func (c *roastChart) SetReference(reference *controller.RoastCurve) {
+	c.reference = reference
+	c.Refresh()
+}
+
+func (c *roastChart) CreateRenderer() fyne.WidgetRenderer {
+	return &roastChartRenderer{chart: c}
+}
+
+// roastChartRenderer draws the chart again when it is resized or refreshed. The chart's objects are reused, so
+// only the ones that changed are repainted
+type roastChartRenderer struct {
+	chart   *roastChart
+	objects []fyne.CanvasObject
+}
+
+func (r *roastChartRenderer) Layout(size fyne.Size) {
+	r.objects = r.chart.draw(size)
+}
+
+func (r *roastChartRenderer) MinSize() fyne.Size {
+	return fyne.NewSize(300, 180)
+}
+
+func (r *roastChartRenderer) Refresh() {
+	r.Layout(r.chart.Size())
+	for _, object := range r.objects {
+		object.Refresh()
+	}
+}
+
+func (r *roastChartRenderer) Objects() []fyne.CanvasObject {
+	return r.objects
+}
+
+func (r *roastChartRenderer) Destroy() {}
+
+func (c *roastChart) draw(size fyne.Size) []fyne.CanvasObject {
+	c.objects.reset()
+	area := chartArea{
+		pos:     fyne.NewPos(chartMargin, 2*chartLabelSize),
+		size:    fyne.NewSize(size.Width-2*chartMargin, size.Height-chartMargin-2*chartLabelSize),
+		scale:   newChartScale(c.curve, c.reference),
+		objects: &c.objects,
+	}
+	if area.size.Width <= 0 || area.size.Height <= 0 {
+		return nil
+	}
+
+	area.axes()
+	if c.reference != nil {
+		area.curve(c.reference, true)
+	}
+	if c.curve != nil {
+		area.curve(c.curve, false)
+	}
+	c.legend()
+	return c.objects.drawn
+}
+
+// chartObjects keeps the chart's lines and text between draws so they are updated in place instead of being
+// created again each time the chart is refreshed
+type chartObjects struct {
+	lines []*canvas.Line
+	texts []*canvas.Text
+	// usedLines and usedTexts are how many of each are in the current draw
+	usedLines int
+	usedTexts int
+	// drawn is the objects in the current draw in the order that they are painted
+	drawn []fyne.CanvasObject
+}
+
+// reset starts a new draw. Objects from the last one are reused in the same order
+func (o *chartObjects) reset() {
+	o.usedLines, o.usedTexts = 0, 0
+	o.drawn = nil
+}
+
+func (o *chartObjects) line(c color.Color, width float32, from, to fyne.Position) *canvas.Line {
+	if o.usedLines == len(o.lines) {
+		o.lines = append(o.lines, canvas.NewLine(c))
+	}
+	line := o.lines[o.usedLines]
+	o.usedLines++
+
+	line.StrokeColor = c
+	line.StrokeWidth = width
+	line.Position1 = from
+	line.Position2 = to
+	o.drawn = append(o.drawn, line)
+	return line
+}
+
+func (o *chartObjects) text(text string, c color.Color) *canvas.Text {
+	if o.usedTexts == len(o.texts) {
+		o.texts = append(o.texts, canvas.NewText(text, c))
+	}
+	t := o.texts[o.usedTexts]
+	o.usedTexts++
+
+	t.Text = text
+	t.Color = c
+	t.TextSize = chartLabelSize
+	t.Alignment = fyne.TextAlignLeading
+	t.Resize(fyne.Size{})
+	o.drawn = append(o.drawn, t)
+	return t
+}
+
+// chartLegendItem is a line's label in the legend
+type chartLegendItem struct {
+	text  string
+	color color.Color
+}
+
+// legend labels each line in the space above the plot
+func (c *roastChart) legend() {
+	items := []chartLegendItem{
+		{"Bean", beanColor},
+		{"Ambient", ambientColor},
+		{"RoR", rorColor},
+	}
+	if c.reference != nil {
+		name := c.reference.Name
+		if name == "" {
+			name = "previous roast"
+		}
+		items = append(items, chartLegendItem{"Reference: " + name, referenceColor(beanColor)})
+	}
+
+	x := float32(chartMargin)
+	for _, item := range items {
+		text := c.objects.text(item.text, item.color)
+		text.Move(fyne.NewPos(x, 0))
+		x += fyne.MeasureText(item.text, chartLabelSize, text.TextStyle).Width + chartLabelSize
+	}
+}
+
+// chartScale is the largest value on each axis. Elapsed time is from each curve's Origin, so a reference roast
+// lines up with the live one
+type chartScale struct {
+	duration       time.Duration
+	maxTemperature float64
+	maxRoR         float64
+}
+
+// newChartScale fits all of the curves. Nil curves are skipped
+func newChartScale(curves ...*controller.RoastCurve) chartScale {
+	s := chartScale{
+		duration:       chartMinDuration,
+		maxTemperature: chartMinTemperature,
+		maxRoR:         chartMinRoR,
+	}
+	for _, curve := range curves {
+		if curve == nil {
+			continue
+		}
+		origin := curve.Origin()
+		for _, points := range [][]controller.CurvePoint{curve.Bean, curve.Ambient} {
+			for _, p := range points {
+				s.duration = max(s.duration, p.Time.Sub(origin))
+				s.maxTemperature = max(s.maxTemperature, p.Value)
+			}
+		}
+		for _, p := range curve.RoR {
+			s.maxRoR = max(s.maxRoR, p.Value)
+		}
+		for _, marker := range curve.Markers {
+			s.duration = max(s.duration, marker.Time.Sub(origin))
+		}
+	}
+
+	if rem := s.duration % time.Minute; rem != 0 {
+		s.duration += time.Minute - rem
+	}
+	s.maxTemperature = math.Ceil(s.maxTemperature/50) * 50
+	s.maxRoR = math.Ceil(s.maxRoR/5) * 5
+	return s
+}
+
+// timeStep is how far apart the time axis labels are
+func (s chartScale) timeStep() time.Duration {
+	if s.duration > 20*time.Minute {
+		return 5 * time.Minute
+	}
+	if s.duration > 10*time.Minute {
+		return 2 * time.Minute
+	}
+	return time.Minute
+}
+
+// chartArea is where the curves are plotted, with temperatures on the left axis and the rate of rise on the right.
+// Its lines and text are added to objects
+type chartArea struct {
+	pos     fyne.Position
+	size    fyne.Size
+	scale   chartScale
+	objects *chartObjects
+}
+
+func (a chartArea) x(elapsed time.Duration) float32 {
+	return a.pos.X + a.size.Width*float32(elapsed)/float32(a.scale.duration)
+}
+
+// y keeps values in the area, like a negative rate of rise while cooling
+func (a chartArea) y(value, maxValue float64) float32 {
+	ratio := max(0, min(value/maxValue, 1))
+	return a.pos.Y + a.size.Height*float32(1-ratio)
+}
+
+func (a chartArea) bottom() float32 {
+	return a.pos.Y + a.size.Height
+}
+
+func (a chartArea) right() float32 {
+	return a.pos.X + a.size.Width
+}
+
+func (a chartArea) axes() {
+	foreground := theme.Color(theme.ColorNameForeground)
+	grid := theme.Color(theme.ColorNameSeparator)
+
+	for elapsed := time.Duration(0); elapsed <= a.scale.duration; elapsed += a.scale.timeStep() {
+		x := a.x(elapsed)
+		a.objects.line(grid, 1, fyne.NewPos(x, a.pos.Y), fyne.NewPos(x, a.bottom()))
+
+		label := a.objects.text(fmt.Sprintf("%d:00", int(elapsed.Minutes())), foreground)
+		label.Alignment = fyne.TextAlignCenter
+		label.Resize(fyne.NewSize(chartMargin, chartLabelSize))
+		label.Move(fyne.NewPos(x-chartMargin/2, a.bottom()+4))
+	}
+
+	for temperature := 0.0; temperature <= a.scale.maxTemperature; temperature += 100 {
+		y := a.y(temperature, a.scale.maxTemperature)
+		a.objects.line(grid, 1, fyne.NewPos(a.pos.X, y), fyne.NewPos(a.right(), y))
+
+		label := a.objects.text(fmt.Sprintf("%.0f°", temperature), beanColor)
+		label.Alignment = fyne.TextAlignTrailing
+		label.Resize(fyne.NewSize(chartMargin-4, chartLabelSize))
+		label.Move(fyne.NewPos(0, y-chartLabelSize/2))
+	}
+
+	for ror := 0.0; ror <= a.scale.maxRoR; ror += 10 {
+		label := a.objects.text(fmt.Sprintf("%.0f", ror), rorColor)
+		label.Move(fyne.NewPos(a.right()+4, a.y(ror, a.scale.maxRoR)-chartLabelSize/2))
+	}
+
+	a.objects.line(foreground, 1, fyne.NewPos(a.pos.X, a.pos.Y), fyne.NewPos(a.pos.X, a.bottom()))
+	a.objects.line(foreground, 1, fyne.NewPos(a.pos.X, a.bottom()), fyne.NewPos(a.right(), a.bottom()))
+	a.objects.line(foreground, 1, fyne.NewPos(a.right(), a.pos.Y), fyne.NewPos(a.right(), a.bottom()))
+}
+
+// curve draws the markers behind the lines. Only stages are marked for a reference roast, without labels, so the
+// live roast's markers are still readable
+func (a chartArea) curve(curve *controller.RoastCurve, reference bool) {
+	origin := curve.Origin()
+	fade := func(c color.RGBA) color.RGBA {
+		if reference {
+			return referenceColor(c)
+		}
+		return c
+	}
+
+	for i, marker := range curve.Markers {
+		if reference && marker.Kind != controller.CurveMarkerStage {
+			continue
+		}
+		elapsed := marker.Time.Sub(origin)
+		if elapsed < 0 || elapsed > a.scale.duration {
+			continue
+		}
+
+		markerColor, label := stageMarkerColor, marker.Label
+		switch marker.Kind {
+		case controller.CurveMarkerSetting:
+			markerColor = settingMarkerColor
+		case controller.CurveMarkerNote:
+			markerColor = noteMarkerColor
+			if runes := []rune(label); len(runes) > chartNoteLength {
+				label = strings.TrimSpace(string(runes[:chartNoteLength])) + "…"
+			}
+		}
+
+		x := a.x(elapsed)
+		a.objects.line(fade(markerColor), 1, fyne.NewPos(x, a.pos.Y), fyne.NewPos(x, a.bottom()))
+		if !reference {
+			// labels are staggered so markers that are close together don't cover each other
+			text := a.objects.text(label, markerColor)
+			text.Move(fyne.NewPos(x+2, a.pos.Y+float32(i%3)*chartLabelSize))
+		}
+	}
+
+	a.line(curve.Ambient, origin, a.scale.maxTemperature, fade(ambientColor))
+	a.line(curve.RoR, origin, a.scale.maxRoR, fade(rorColor))
+	a.line(curve.Bean, origin, a.scale.maxTemperature, fade(beanColor))
+}
+
+// line connects the points that are on the chart and returns how many lines it drew. Points less than a pixel
+// from the last one are skipped so a long roast doesn't need a line for every reading
+func (a chartArea) line(points []controller.CurvePoint, origin time.Time, maxValue float64, c color.Color) int {
+	var lines int
+	var last fyne.Position
+	started := false
+	for _, p := range points {
+		elapsed := p.Time.Sub(origin)
+		if elapsed < 0 || elapsed > a.scale.duration {
+			continue
+		}
+
+		pos := fyne.NewPos(a.x(elapsed), a.y(p.Value, maxValue))
+		if !started {
+			last, started = pos, true
+			continue
+		}
+		if pos.X-last.X < 1 {
+			continue
+		}
+		a.objects.line(c, 2, last, pos)
+		lines++
+		last = pos
+	}
+	return lines
+}
+
+// selectReferenceRoast asks for a previous roast's log and calls set with its curve. The log's bean temperatures
+// are the readings that the thermocouple sent to TWChart
+func selectReferenceRoast(window fyne.Window, cfg controller.Config, set func(*controller.RoastCurve)) {
+	path, err := nativeDialog.File().
+		Filter("Roast logs", "jsonl").
+		Title("Select reference roast").
+		SetStartDir(cfg.RoastLogDir).
+		Load()
+	if errors.Is(err, nativeDialog.ErrCancelled) {
+		return
+	}
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("select reference roast: %w", err), window)
+		return
+	}
+
+	reference, err := loadReferenceRoast(path, cfg)
+	if err != nil {
+		dialog.ShowError(err, window)
+		return
+	}
+	set(reference)
+}
+
+func loadReferenceRoast(path string, cfg controller.Config) (*controller.RoastCurve, error) {
+	beanProbe := cfg.ThermocoupleProbe
+	if beanProbe == "" {
+		beanProbe = "Beans"
+	}
+
+	reference, err := controller.LoadRoastCurve(path, beanProbe, cfg.RoRWindow)
+	if err != nil {
+		return nil, fmt.Errorf("error loading reference roast: %w", err)
+	}
+	if len(reference.Bean) == 0 && len(reference.Markers) == 0 {
+		return nil, fmt.Errorf("%s has no temperatures or events to chart", filepath.Base(path))
+	}
+	return reference, nil
+}
@@ -0,0 +1,187 @@
+package ui
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/test"
+	"github.com/calvinmclean/autoroast/controller"
+	"github.com/calvinmclean/autoroast/twchart"
+)
+
+func TestNewChartScale(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	scale := newChartScale(nil)
+	if scale != (chartScale{duration: chartMinDuration, maxTemperature: chartMinTemperature, maxRoR: chartMinRoR}) {
+		t.Errorf("newChartScale() = %+v, want the minimums", scale)
+	}
+	if scale.timeStep() != time.Minute {
+		t.Errorf("timeStep() = %s, want 1m", scale.timeStep())
+	}
+
+	live := controller.NewRoastCurve("live", nil, "")
+	live.Start = start
+	live.AddBean(start.Add(12*time.Minute+30*time.Second), 512)
+	live.RoR = append(live.RoR, controller.CurvePoint{Time: start.Add(time.Minute), Value: 32})
+
+	reference := controller.NewRoastCurve("reference", nil, "")
+	reference.AddCommand("S", start.Add(-time.Hour))
+	reference.AddCommand("DONE", start.Add(-time.Hour+14*time.Minute))
+
+	scale = newChartScale(live, reference)
+	want := chartScale{duration: 14 * time.Minute, maxTemperature: 550, maxRoR: 35}
+	if scale != want {
+		t.Errorf("newChartScale() = %+v, want %+v", scale, want)
+	}
+	if scale.timeStep() != 2*time.Minute {
+		t.Errorf("timeStep() = %s, want 2m", scale.timeStep())
+	}
+}
+
+func TestChartArea(t *testing.T) {
+	area := chartArea{
+		pos:     fyne.NewPos(10, 20),
+		size:    fyne.NewSize(600, 200),
+		scale:   chartScale{duration: 10 * time.Minute, maxTemperature: 500, maxRoR: 30},
+		objects: &chartObjects{},
+	}
+
+	if got := area.x(5 * time.Minute); got != 310 {
+		t.Errorf("x(5m) = %v, want 310", got)
+	}
+	for value, want := range map[float64]float32{0: 220, 250: 120, 500: 20, 600: 20, -10: 220} {
+		if got := area.y(value, 500); got != want {
+			t.Errorf("y(%v) = %v, want %v", value, got, want)
+		}
+	}
+
+	// a second is a pixel, so only every third point is far enough from the last one to be drawn
+	var points []controller.CurvePoint
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i := -5; i <= 25; i++ {
+		points = append(points, controller.CurvePoint{Time: start.Add(time.Duration(i) * 400 * time.Millisecond), Value: 300})
+	}
+	lines := area.line(points, start, 500, beanColor)
+	if lines != 8 || len(area.objects.drawn) != 8 {
+		t.Errorf("line() drew %d lines, want 8 from the points after the origin", lines)
+	}
+}
+
+func TestRoastChartDraw(t *testing.T) {
+	app := test.NewApp()
+	defer app.Quit()
+
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	probes := twchart.Probes{{Name: "Ambient", Position: 1}}
+	live := controller.NewRoastCurve("live", probes, "")
+	live.AddCommand("S", start)
+	live.AddCommand("PREHEAT", start)
+	for s := 0; s <= 120; s += 2 {
+		live.AddBean(start.Add(time.Duration(s)*time.Second), 200+float64(s)/4)
+		live.AddReading(controller.ProbeReading{Position: 1, Time: start.Add(time.Duration(s) * time.Second), Temperature: 75})
+	}
+	live.AddCommand("F6", start.Add(time.Minute))
+	live.AddCommand("NOTE smells like fresh bread", start.Add(90*time.Second))
+
+	reference := controller.NewRoastCurve("Colombia", nil, "")
+	reference.AddCommand("S", start.Add(-time.Hour))
+	reference.AddCommand("FC", start.Add(-time.Hour+8*time.Minute))
+	reference.AddCommand("P7", start.Add(-time.Hour+9*time.Minute))
+
+	chart := newRoastChart()
+	chart.SetCurve(live)
+	chart.SetReference(reference)
+
+	var texts []string
+	var lines int
+	for _, object := range chart.draw(fyne.NewSize(800, 300)) {
+		switch object := object.(type) {
+		case *canvas.Text:
+			texts = append(texts, object.Text)
+		case *canvas.Line:
+			lines++
+		}
+	}
+
+	for _, want := range []string{"Preheat", "F6", "smells like fres…", "Reference: Colombia", "0:00", "500°"} {
+		if !slices.Contains(texts, want) {
+			t.Errorf("texts = %q, want %q", texts, want)
+		}
+	}
+	// the reference's markers aren't labeled
+	if slices.Contains(texts, "First Crack") || slices.Contains(texts, "P7") {
+		t.Errorf("texts = %q, want no labels for the reference's markers", texts)
+	}
+	if lines < 2*60 {
+		t.Errorf("drew %d lines, want at least one for each bean and ambient reading", lines)
+	}
+
+	// drawing again updates the same objects instead of creating new ones
+	first := chart.draw(fyne.NewSize(800, 300))
+	live.AddBean(start.Add(122*time.Second), 231)
+	second := chart.draw(fyne.NewSize(800, 300))
+	if len(second) != len(first)+1 {
+		t.Errorf("drew %d objects after adding a reading, want %d", len(second), len(first)+1)
+	}
+	for i, object := range first {
+		if !slices.Contains(second, object) {
+			t.Fatalf("object %d was created again, want it to be reused", i)
+		}
+	}
+
+	chart.SetReference(nil)
+	for _, object := range chart.draw(fyne.NewSize(800, 300)) {
+		if text, ok := object.(*canvas.Text); ok && text.Text == "Reference: Colombia" {
+			t.Error("reference still in the legend after removing it")
+		}
+	}
+
+	if objects := chart.draw(fyne.NewSize(50, 50)); len(objects) != 0 {
+		t.Errorf("drew %d objects in a chart smaller than its margins, want 0", len(objects))
+	}
+}
+
+func TestLoadReferenceRoast(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	writeLog := func(name string, entries ...controller.RoastLogEntry) string {
+		t.Helper()
+		var data []byte
+		for _, entry := range entries {
+			line, err := json.Marshal(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = append(append(data, line...), '\n')
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := writeLog("roast.jsonl",
+		controller.RoastLogEntry{Time: start, Type: controller.RoastLogSession, Name: "Kenya", Probes: twchart.Probes{{Name: "Roaster", Position: 1}}},
+		controller.RoastLogEntry{Time: start, Type: controller.RoastLogData, Data: []twchart.Data{{Time: start, ProbeData: []float64{210}}}},
+	)
+	reference, err := loadReferenceRoast(path, controller.Config{ThermocoupleProbe: "Roaster"})
+	if err != nil {
+		t.Fatalf("loadReferenceRoast() error = %v", err)
+	}
+	if reference.Name != "Kenya" || len(reference.Bean) != 1 {
+		t.Errorf("reference = %+v, want Kenya with the Roaster probe's temperature", reference)
+	}
+
+	empty := writeLog("empty.jsonl", controller.RoastLogEntry{Time: start, Type: controller.RoastLogSession, Name: "Empty"})
+	if _, err := loadReferenceRoast(empty, controller.Config{}); err == nil {
+		t.Error("loadReferenceRoast() error = nil, want error for a roast with nothing to chart")
+	}
+}
@@ -3,8 +3,11 @@ package ui
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -18,6 +21,12 @@ import (
 type ConfigWindow struct {
 	app      fyne.App
 	OnSubmit func()
+
+	// savedReplayVars are the replay variables saved in the preferences and the ones that are edited. Variables
+	// from the -var flag are only used for this roast, so they aren't saved unless they are edited
+	savedReplayVars map[string]string
+	// flagReplayVars are the variables from the -var flag
+	flagReplayVars map[string]string
 }
 
 func NewConfigWindow(app fyne.App) *ConfigWindow {
@@ -35,7 +44,23 @@ func (cw *ConfigWindow) loadConfigFromPreferences(cfg *controller.Config) {
 	cfg.ProbesInput = prefs.StringWithFallback("probesInput", "1=Ambient,2=Beans")
 	cfg.InitialFanSetting = prefs.IntWithFallback("initialFanSetting", 5)
 	cfg.InitialPowerSetting = prefs.IntWithFallback("initialPowerSetting", 5)
-	cfg.RoastFile = prefs.StringWithFallback("roastFile", "")
+	// the file from the -replay flag is used instead of the saved one
+	if cfg.RoastFile == "" {
+		cfg.RoastFile = prefs.StringWithFallback("roastFile", "")
+	}
+
+	cw.savedReplayVars = map[string]string{}
+	for _, input := range prefs.StringList("replayVars") {
+		name, value, err := controller.ParseReplayVar(input)
+		if err == nil {
+			cw.savedReplayVars[name] = value
+		}
+	}
+	// variables from the -var flag are used instead of the saved ones
+	cw.flagReplayVars = maps.Clone(cfg.ReplayVars)
+	if cfg.ReplayVars == nil {
+		cfg.ReplayVars = maps.Clone(cw.savedReplayVars)
+	}
 }
 
 func (cw *ConfigWindow) saveConfigToPreferences(cfg *controller.Config) {
@@ -48,6 +73,13 @@ func (cw *ConfigWindow) saveConfigToPreferences(cfg *controller.Config) {
 	prefs.SetInt("initialFanSetting", cfg.InitialFanSetting)
 	prefs.SetInt("initialPowerSetting", cfg.InitialPowerSetting)
 	prefs.SetString("roastFile", cfg.RoastFile)
+
+	var replayVars []string
+	for name, value := range cw.savedReplayVars {
+		replayVars = append(replayVars, name+"="+value)
+	}
+	slices.Sort(replayVars)
+	prefs.SetStringList("replayVars", replayVars)
 }
 
 func (cw *ConfigWindow) Show(cfg *controller.Config) {
@@ -62,6 +94,18 @@ func (cw *ConfigWindow) Show(cfg *controller.Config) {
 
 	// Load config from preferences
 	cw.loadConfigFromPreferences(cfg)
+	// keep the session, TWChart queue, and roast logs with the app's data instead of the working directory,
+	// unless they are set by environment variables
+	storagePath := cw.app.Storage().RootURI().Path()
+	if cfg.SessionFile == "" || cfg.SessionFile == controller.DefaultSessionFile {
+		cfg.SessionFile = filepath.Join(storagePath, controller.DefaultSessionFile)
+	}
+	if cfg.TWChartQueueFile == "" || cfg.TWChartQueueFile == controller.DefaultTWChartQueueFile {
+		cfg.TWChartQueueFile = filepath.Join(storagePath, controller.DefaultTWChartQueueFile)
+	}
+	if cfg.RoastLogDir == controller.DefaultRoastLogDir {
+		cfg.RoastLogDir = filepath.Join(storagePath, controller.DefaultRoastLogDir)
+	}
 
 	submitButton := widget.NewButton("Submit", func() {
 		cw.saveConfigToPreferences(cfg)
@@ -90,7 +134,7 @@ func (cw *ConfigWindow) Show(cfg *controller.Config) {
 		return
 	}
 
-	serialPorts = append(serialPorts, controller.SerialPortNone)
+	serialPorts = append(serialPorts, controller.SerialPortNone, controller.SerialPortSim)
 
 	serialEntry := widget.NewSelect(serialPorts, func(s string) {
 		validateForm()
@@ -134,6 +178,61 @@ func (cw *ConfigWindow) Show(cfg *controller.Config) {
 	initSettingsEntries := container.NewHBox(fanEntry, powerEntry)
 	initSettingsEntries.Resize(fyne.NewSize(120, initSettingsEntries.MinSize().Height))
 
+	// replayVarsContainer has an entry for each variable declared in the replay file to override its value
+	replayVarsContainer := container.NewVBox()
+	refreshReplayVars := func() {
+		replayVarsContainer.RemoveAll()
+		if cfg.RoastFile == "" {
+			cfg.ReplayVars = map[string]string{}
+			cw.savedReplayVars = map[string]string{}
+			return
+		}
+
+		vars, err := controller.LoadReplayVars(cfg.RoastFile)
+		if err != nil {
+			replayVarsContainer.Add(widget.NewLabel("Invalid replay file: " + err.Error()))
+			return
+		}
+
+		// -var flags for variables that aren't in this file are probably typos, so they are shown instead of being
+		// ignored
+		err = controller.CheckReplayVarOverrides(vars, cw.flagReplayVars)
+		if err != nil {
+			replayVarsContainer.Add(widget.NewLabel("Ignoring -var: " + err.Error()))
+		}
+
+		// overrides are only kept for variables in this file
+		overrides := map[string]string{}
+		saved := map[string]string{}
+		for _, v := range vars {
+			if value, ok := cfg.ReplayVars[v.Name]; ok {
+				overrides[v.Name] = value
+			}
+			if value, ok := cw.savedReplayVars[v.Name]; ok {
+				saved[v.Name] = value
+			}
+		}
+		cfg.ReplayVars = overrides
+		cw.savedReplayVars = saved
+
+		for _, v := range vars {
+			entry := widget.NewEntry()
+			entry.SetPlaceHolder(v.Value)
+			entry.SetText(cfg.ReplayVars[v.Name])
+			entry.OnChanged = func(s string) {
+				s = strings.TrimSpace(s)
+				if s == "" || s == v.Value {
+					delete(cfg.ReplayVars, v.Name)
+					delete(cw.savedReplayVars, v.Name)
+					return
+				}
+				cfg.ReplayVars[v.Name] = s
+				cw.savedReplayVars[v.Name] = s
+			}
+			replayVarsContainer.Add(container.NewGridWithColumns(2, widget.NewLabel(v.Name+":"), entry))
+		}
+	}
+
 	roastFileLabel := widget.NewLabel(roastFileDisplay(cfg.RoastFile))
 	selectRoastFile := widget.NewButton("Browse", func() {
 		path, err := nativeDialog.File().Filter("Roast files", "roast").Title("Select roast replay file").Load()
@@ -147,11 +246,35 @@ func (cw *ConfigWindow) Show(cfg *controller.Config) {
 
 		cfg.RoastFile = path
 		roastFileLabel.SetText(roastFileDisplay(cfg.RoastFile))
+		refreshReplayVars()
 	})
 	clearRoastFile := widget.NewButton("Clear", func() {
 		cfg.RoastFile = ""
 		roastFileLabel.SetText(roastFileDisplay(cfg.RoastFile))
+		refreshReplayVars()
 	})
+	refreshReplayVars()
+
+	resumeCheck := widget.NewCheck("Resume roast", func(resume bool) {
+		cfg.Resume = resume
+		validateForm()
+	})
+	savedSession, err := controller.LoadSessionState(cfg.SessionFile)
+	if err != nil {
+		resumeCheck.Disable()
+	} else {
+		resumeCheck.Text = "Resume roast: " + savedSession.Name
+		resumeCheck.OnChanged = func(resume bool) {
+			cfg.Resume = resume
+			if resume {
+				sessionEntry.SetText(savedSession.Name)
+				sessionEntry.Disable()
+			} else {
+				sessionEntry.Enable()
+			}
+			validateForm()
+		}
+	}
 
 	// Add listeners to field changes
 	sessionEntry.OnChanged = func(_ string) { validateForm() }
@@ -188,10 +311,15 @@ func (cw *ConfigWindow) Show(cfg *controller.Config) {
 				widget.NewLabel("Initial Fan/Power:"),
 				container.NewWithoutLayout(initSettingsEntries),
 			),
+			container.NewGridWithColumns(2,
+				widget.NewLabel("Resume:"),
+				resumeCheck,
+			),
 			container.NewGridWithColumns(2,
 				widget.NewLabel("Replay File:"),
 				container.NewBorder(nil, nil, selectRoastFile, clearRoastFile, roastFileLabel),
 			),
+			replayVarsContainer,
 		)),
 		container.NewHBox(
 			widget.NewButton("Cancel", func() {
@@ -20,6 +20,11 @@ func (c *controllerWrapper) Note(note string) {
 	c.write("NOTE %s\n", note)
 }
 
+// Confirm continues a replay that is waiting with WAITFOR CONFIRM
+func (c *controllerWrapper) Confirm() {
+	c.write("CONFIRM\n")
+}
+
 func (c *controllerWrapper) Click() {
 	c.write("C\n")
 }
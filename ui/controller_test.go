@@ -40,6 +40,13 @@ func TestConfigWindowPersistsRoastFile(t *testing.T) {
 	if got, want := cfg.RoastFile, "/tmp/roast.roast"; got != want {
 		t.Errorf("RoastFile = %q, want %q", got, want)
 	}
+
+	// a file from the -replay flag is used instead
+	cfg = controller.Config{RoastFile: "/tmp/flag.roast"}
+	configWindow.loadConfigFromPreferences(&cfg)
+	if got, want := cfg.RoastFile, "/tmp/flag.roast"; got != want {
+		t.Errorf("RoastFile = %q, want %q from the flag", got, want)
+	}
 }
 
 func TestCreateSliderSetterUpdatesSlider(t *testing.T) {
@@ -0,0 +1,64 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"github.com/calvinmclean/autoroast/controller"
+	nativeDialog "github.com/sqweek/dialog"
+)
+
+// exportRoast asks where to save the roast and writes it as a replay file that can be selected in the
+// configuration window to roast it again
+func exportRoast(window fyne.Window, roast controller.RecordedRoast) {
+	if len(roast.Steps) == 0 {
+		dialog.ShowInformation("Export as Planned Roast", "Nothing has been sent to the roaster yet.", window)
+		return
+	}
+
+	name := roast.Name
+	if name == "" {
+		name = "roast"
+	}
+	path, err := nativeDialog.File().
+		Filter("Roast files", "roast").
+		Title("Export as planned roast").
+		SetStartFile(name + ".roast").
+		Save()
+	if errors.Is(err, nativeDialog.ErrCancelled) {
+		return
+	}
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("select export file: %w", err), window)
+		return
+	}
+	if filepath.Ext(path) != ".roast" {
+		path += ".roast"
+	}
+
+	err = writeReplayFile(path, roast)
+	if err != nil {
+		dialog.ShowError(err, window)
+		return
+	}
+	dialog.ShowInformation("Export as Planned Roast", "Saved "+filepath.Base(path), window)
+}
+
+func writeReplayFile(path string, roast controller.RecordedRoast) error {
+	var b strings.Builder
+	err := controller.WriteReplay(&b, roast, controller.ConvertOptions{})
+	if err != nil {
+		return fmt.Errorf("error writing replay: %w", err)
+	}
+
+	err = os.WriteFile(path, []byte(b.String()), 0o644)
+	if err != nil {
+		return fmt.Errorf("error writing replay file: %w", err)
+	}
+	return nil
+}
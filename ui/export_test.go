@@ -0,0 +1,31 @@
+package ui
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/calvinmclean/autoroast/controller"
+)
+
+func TestWriteReplayFile(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+	recorder := controller.NewRoastRecorder("Ethiopia")
+	recorder.Record("S", start)
+	recorder.Record("F6", start.Add(30*time.Second))
+	recorder.Record("COOL", start.Add(5*time.Minute))
+
+	path := filepath.Join(t.TempDir(), "ethiopia.roast")
+	if err := writeReplayFile(path, recorder.Roast()); err != nil {
+		t.Fatalf("writeReplayFile() error = %v", err)
+	}
+
+	// the exported file can be loaded as the replay file
+	actions, err := controller.LoadReplay(path)
+	if err != nil {
+		t.Fatalf("LoadReplay() error = %v", err)
+	}
+	if len(actions) != 5 {
+		t.Errorf("actions = %v, want S, F6, COOL, and two WAITs", actions)
+	}
+}
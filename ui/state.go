@@ -3,6 +3,9 @@ package ui
 import (
 	"strconv"
 	"strings"
+
+	"github.com/calvinmclean/autoroast"
+	"github.com/calvinmclean/autoroast/controller"
 )
 
 type state int
@@ -58,21 +61,36 @@ func (s state) command() string {
 	}
 }
 
+// stateForStage returns the state matching the name of a saved session stage
+func stateForStage(name string) state {
+	for s := statePreheat; s <= stateDone; s++ {
+		if s.String() == name {
+			return s
+		}
+	}
+	return stateNone
+}
+
+// stateForCommand returns the state that the command changes to. Like replays, it is case-insensitive
 func stateForCommand(command string) state {
-	switch strings.ToUpper(strings.TrimSpace(command)) {
-	case "PREHEAT":
-		return statePreheat
-	case "ROAST", "ROASTING":
-		return stateRoasting
-	case "FC", "CRACK":
-		return stateFirstCrack
-	case "COOL":
-		return stateCooling
-	case "DONE":
+	cmd, ok := controller.LookupExternalCommand(strings.TrimSpace(command))
+	switch {
+	case !ok:
+		return stateNone
+	case cmd.Name == "DONE":
 		return stateDone
 	default:
-		return stateNone
+		return stateForStage(cmd.Stage)
+	}
+}
+
+// syncedState returns the state to use after syncing with the device's status. The device only knows
+// whether it has been started, so any later states are kept
+func syncedState(current state, status autoroast.DeviceStatus) state {
+	if status.Started && current == stateNone {
+		return statePreheat
 	}
+	return current
 }
 
 func settingValue(command string) (byte, float64, bool) {
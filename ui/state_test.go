@@ -1,10 +1,15 @@
 package ui
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/calvinmclean/autoroast"
+)
 
 func TestStateForCommand(t *testing.T) {
 	tests := map[string]state{
 		"PREHEAT":  statePreheat,
+		"ph":       statePreheat,
 		"ROAST":    stateRoasting,
 		"ROASTING": stateRoasting,
 		"FC":       stateFirstCrack,
@@ -12,6 +17,7 @@ func TestStateForCommand(t *testing.T) {
 		"COOL":     stateCooling,
 		"DONE":     stateDone,
 		"F5":       stateNone,
+		"CONFIRM":  stateNone,
 	}
 
 	for command, want := range tests {
@@ -43,3 +49,29 @@ func TestSettingValue(t *testing.T) {
 		})
 	}
 }
+
+func TestSyncedState(t *testing.T) {
+	if got := syncedState(stateNone, autoroast.DeviceStatus{}); got != stateNone {
+		t.Errorf("syncedState() = %v for stopped device, want %v", got, stateNone)
+	}
+	if got := syncedState(stateNone, autoroast.DeviceStatus{Started: true}); got != statePreheat {
+		t.Errorf("syncedState() = %v for started device, want %v", got, statePreheat)
+	}
+	if got := syncedState(stateFirstCrack, autoroast.DeviceStatus{Started: true}); got != stateFirstCrack {
+		t.Errorf("syncedState() = %v after first crack, want %v", got, stateFirstCrack)
+	}
+}
+
+func TestStateForStage(t *testing.T) {
+	for name, want := range map[string]state{
+		"Preheat":     statePreheat,
+		"Roasting":    stateRoasting,
+		"First Crack": stateFirstCrack,
+		"Cooling":     stateCooling,
+		"Unknown":     stateNone,
+	} {
+		if got := stateForStage(name); got != want {
+			t.Errorf("stateForStage(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
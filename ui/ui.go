@@ -2,6 +2,7 @@ package ui
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"image/color"
 	"io"
@@ -9,10 +10,12 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/layout"
@@ -46,6 +49,34 @@ func alertHandler(window fyne.Window) func(message string) {
 	}
 }
 
+// showReplayEditor shows a dialog to enter planned actions, one per line like a replay file. The input is checked
+// while typing and submit is called with it when it is saved
+func showReplayEditor(window fyne.Window, title, text string, submit func(string) error) {
+	entry := widget.NewMultiLineEntry()
+	entry.SetText(text)
+	entry.SetMinRowsVisible(4)
+	entry.Validator = func(input string) error {
+		actions, err := controller.ParseReplay(strings.NewReader(input))
+		if err == nil && len(actions) == 0 {
+			err = errors.New("no actions to queue")
+		}
+		return err
+	}
+
+	item := widget.NewFormItem("Actions", entry)
+	item.HintText = "One action per line, like F5 or WAIT 30s"
+	d := dialog.NewForm(title, "Save", "Cancel", []*widget.FormItem{item}, func(save bool) {
+		if !save {
+			return
+		}
+		if err := submit(entry.Text); err != nil {
+			dialog.ShowError(err, window)
+		}
+	}, window)
+	d.Resize(fyne.NewSize(500, 300))
+	d.Show()
+}
+
 func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool) {
 	application := app.NewWithID("auto.roast.calvinmclean.github.io")
 
@@ -56,6 +87,8 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 	overallTimer := newTimer(false)
 	lastEventTimer := newTimer(true)
 	fcTimer := newTimer(true)
+	// rorText is the bean temperature's rate of rise, which is updated with each reading
+	rorText := canvas.NewText(formatRateOfRise(0, false), nil)
 
 	waitForStart := make(chan struct{})
 	overallTimer.Go(waitForStart)
@@ -100,13 +133,73 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 			lastEventTimer.Stop()
 		}
 	}
+	// curve is the current roast's temperatures and events, which are charted
+	var curve *controller.RoastCurve
+	chart := newRoastChart()
+	// resumeSession moves to the saved session's stage and restores the timers and stage markers without sending any
+	// commands
+	resumeSession := func(session controller.SessionState) {
+		if !session.StartTime.IsZero() && currentState == stateNone {
+			advanceState()
+			overallTimer.Set(session.StartTime)
+			lastEventTimer.Set(session.StartTime)
+		}
+		curve.Start = session.StartTime
+		for _, stage := range session.Stages {
+			target := stateForStage(stage.Name)
+			if target == stateNone {
+				continue
+			}
+			curve.AddMarker(controller.CurveMarkerStage, stage.Start, stage.Name)
+			for currentState < target {
+				advanceState()
+			}
+
+			lastEventTimer.Set(stage.Start)
+			switch target {
+			case statePreheat, stateRoasting:
+				overallTimer.Set(stage.Start)
+			case stateFirstCrack:
+				fcTimer.Set(stage.Start)
+			}
+		}
+	}
 	stateButton = widget.NewButton(currentState.next().String(), func() {
 		stateButton.Disable()
 		cw.RunStateCommand(currentState.next())
 	})
 	var setFanSlider, setPowerSlider func(float64)
+	var replay *controller.Replay
+	// mu guards recorder and roaster, which are replaced when a roast is configured and used from the
+	// controller's goroutines
+	var mu sync.Mutex
+	// recorder keeps the roast so it can be exported as a replay file
+	var recorder *controller.RoastRecorder
+	var roaster *controller.Controller
+	currentRecorder := func() *controller.RoastRecorder {
+		mu.Lock()
+		defer mu.Unlock()
+		return recorder
+	}
+	currentRoaster := func() *controller.Controller {
+		mu.Lock()
+		defer mu.Unlock()
+		return roaster
+	}
 	applyCommand := func(command string) {
+		now := time.Now()
+		if recorder := currentRecorder(); recorder != nil {
+			recorder.Record(command, now)
+		}
 		fyne.Do(func() {
+			if curve != nil {
+				curve.AddCommand(command, now)
+				chart.Refresh()
+			}
+			// commands sent manually can be anchors for the replay's AT actions
+			if replay != nil {
+				replay.ObserveCommand(command, now)
+			}
 			if target := stateForCommand(command); target != stateNone {
 				if currentState.next() == target {
 					advanceState()
@@ -142,15 +235,19 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 	logAccordion, logEntry := createLogAccordion()
 	ui.logEntry = logEntry
 
-	var replay *controller.Replay
+	logAccordion.Append(createCalibrationItem(ctx, currentRoaster, func(err error) { fmt.Fprintf(ui, "Error: %v\n", err) }))
+
 	var replayQueueItems []controller.ReplayQueuedAction
 	var replayQueue *widget.List
 	var replayButton *widget.Button
 	var skipReplayButton *widget.Button
+	var pauseReplayButton *widget.Button
 	replayStatus := widget.NewLabel("Manual control")
 	replayStatus.Wrapping = fyne.TextWrapWord
 	waitCountdown := widget.NewLabel("")
 	waitCountdown.Hide()
+	rampProgress := widget.NewProgressBar()
+	rampProgress.Hide()
 	var waitCountdownCancel context.CancelFunc
 	var waitCountdownID uint
 	updateWaitCountdown := func(waitUntil time.Time) {
@@ -190,6 +287,37 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 			}
 		}()
 	}
+	var confirmDialog *dialog.ConfirmDialog
+	// showConfirm shows the message for the replay's WAITFOR CONFIRM. The dialog is hidden when the replay stops
+	// waiting, like when it times out or CONFIRM is sent from somewhere else
+	showConfirm := func(message string) {
+		if confirmDialog != nil {
+			d := confirmDialog
+			confirmDialog = nil
+			d.Hide()
+		}
+		if message == "" {
+			return
+		}
+
+		var d *dialog.ConfirmDialog
+		d = dialog.NewCustomConfirm("Waiting for Confirmation", "Confirm", "Skip", widget.NewLabel(message), func(confirmed bool) {
+			if d != confirmDialog {
+				return
+			}
+			confirmDialog = nil
+			if confirmed {
+				cw.Confirm()
+			} else if replay != nil {
+				replay.Skip()
+			}
+		}, window)
+		confirmDialog = d
+		d.Resize(fyne.NewSize(500, 250))
+		d.Show()
+	}
+	// confirmID is the replay action that the confirmation dialog was shown for so it is only shown once
+	confirmID := -1
 	replayQueue = widget.NewList(
 		func() int { return len(replayQueueItems) },
 		func() fyne.CanvasObject {
@@ -200,8 +328,11 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 					replay.MoveQueuedTo(itemID, to)
 				}
 			})
+			insertButton := widget.NewButtonWithIcon("", theme.ContentAddIcon(), nil)
+			editButton := widget.NewButtonWithIcon("", theme.DocumentCreateIcon(), nil)
 			removeButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), nil)
-			return container.NewBorder(nil, nil, handle, removeButton, label)
+			buttons := container.NewHBox(insertButton, editButton, removeButton)
+			return container.NewBorder(nil, nil, handle, buttons, label)
 		},
 		func(id widget.ListItemID, object fyne.CanvasObject) {
 			item := replayQueueItems[id]
@@ -210,7 +341,24 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 			handle := row.Objects[1].(*dragHandle)
 			handle.itemID = item.ID
 			handle.index = id
-			removeButton := row.Objects[2].(*widget.Button)
+			buttons := row.Objects[2].(*fyne.Container)
+			buttons.Objects[0].(*widget.Button).OnTapped = func() {
+				if replay == nil {
+					return
+				}
+				showReplayEditor(window, "Insert After "+item.Text, "", func(input string) error {
+					return replay.InsertQueuedAfter(item.ID, input)
+				})
+			}
+			buttons.Objects[1].(*widget.Button).OnTapped = func() {
+				if replay == nil {
+					return
+				}
+				showReplayEditor(window, "Edit Planned Action", item.Text, func(input string) error {
+					return replay.UpdateQueued(item.ID, input)
+				})
+			}
+			removeButton := buttons.Objects[2].(*widget.Button)
 			removeButton.Enable()
 			removeButton.OnTapped = func() {
 				if replay != nil && replay.RemoveQueued(item.ID) {
@@ -264,6 +412,15 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 		}
 	})
 	skipReplayButton.Hide()
+	pauseReplayButton = widget.NewButtonWithIcon("", theme.MediaPauseIcon(), func() {
+		if replay == nil {
+			return
+		}
+		if !replay.Pause() {
+			replay.Resume()
+		}
+	})
+	pauseReplayButton.Hide()
 
 	clickButton := widget.NewButton("Click", func() {
 		cw.Click()
@@ -274,6 +431,12 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 	increaseTimeButton := widget.NewButton("Increase Time", func() {
 		cw.IncreaseTime()
 	})
+	var syncFromDevice func()
+	syncButton := widget.NewButton("Sync", func() {
+		if syncFromDevice != nil {
+			syncFromDevice()
+		}
+	})
 
 	noteEntry := widget.NewEntry()
 	noteEntry.OnSubmitted = func(s string) {
@@ -287,10 +450,11 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 		noteEntry.OnSubmitted(noteEntry.Text)
 	})
 
-	buttonContainer := container.NewGridWithColumns(3,
+	buttonContainer := container.NewGridWithColumns(4,
 		clickButton,
 		debugButton,
 		increaseTimeButton,
+		syncButton,
 	)
 
 	manualControls := container.NewVBox(
@@ -298,6 +462,7 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 			container.NewPadded(overallTimer.text),
 			container.NewPadded(lastEventTimer.text),
 			layout.NewSpacer(),
+			container.NewPadded(rorText),
 			container.NewPadded(fcTimer.text),
 		),
 		stateButton,
@@ -306,11 +471,20 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 		container.NewBorder(nil, nil, nil, noteButton, noteEntry),
 		buttonContainer,
 	)
+	exportButton := widget.NewButtonWithIcon("Export", theme.DocumentSaveIcon(), func() {
+		recorder := currentRecorder()
+		if recorder == nil {
+			return
+		}
+		exportRoast(window, recorder.Roast())
+	})
+
 	replayControls := container.NewBorder(
 		container.NewVBox(
 			widget.NewLabel("Planned Roast"),
 			container.NewBorder(nil, nil, nil, waitCountdown, replayStatus),
-			container.NewHBox(replayButton, skipReplayButton),
+			rampProgress,
+			container.NewHBox(replayButton, pauseReplayButton, skipReplayButton, layout.NewSpacer(), exportButton),
 			container.NewBorder(nil, nil, nil, addReplayButton, addReplayEntry),
 		),
 		nil,
@@ -321,7 +495,28 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 	leftPane := container.NewBorder(manualControls, nil, nil, nil, logAccordion)
 	roastSplit := container.NewHSplit(leftPane, replayControls)
 	roastSplit.SetOffset(0.45)
-	contentContainer := roastSplit
+
+	var clearReferenceButton *widget.Button
+	referenceButton := widget.NewButtonWithIcon("Reference", theme.FolderOpenIcon(), func() {
+		selectReferenceRoast(window, cfg, func(reference *controller.RoastCurve) {
+			chart.SetReference(reference)
+			clearReferenceButton.Enable()
+		})
+	})
+	clearReferenceButton = widget.NewButtonWithIcon("", theme.CancelIcon(), func() {
+		chart.SetReference(nil)
+		clearReferenceButton.Disable()
+	})
+	clearReferenceButton.Disable()
+	chartPane := container.NewBorder(
+		container.NewHBox(widget.NewLabel("Roast Curve"), layout.NewSpacer(), referenceButton, clearReferenceButton),
+		nil,
+		nil,
+		nil,
+		chart,
+	)
+	contentContainer := container.NewVSplit(chartPane, roastSplit)
+	contentContainer.SetOffset(0.4)
 
 	go func() {
 		<-ctx.Done()
@@ -331,7 +526,7 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 	}()
 
 	window.SetContent(contentContainer)
-	window.Resize(fyne.NewSize(880, 480))
+	window.Resize(fyne.NewSize(880, 800))
 
 	// Show config window on startup
 	configWindow := NewConfigWindow(application)
@@ -342,9 +537,14 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 		replayQueueItems = nil
 		startReplay = nil
 		replayButton.Disable()
+		// the controller's callbacks keep using this roast's recorder
+		roastRecorder := controller.NewRoastRecorder(cfg.SessionName)
+		mu.Lock()
+		recorder = roastRecorder
+		mu.Unlock()
 		if cfg.RoastFile != "" {
 			var err error
-			actions, err := controller.LoadReplay(cfg.RoastFile)
+			actions, err := controller.LoadReplayWithVars(cfg.RoastFile, cfg.ReplayVars)
 			if err != nil {
 				showError(application, window, fmt.Errorf("error loading replay file: %w", err))
 				return
@@ -354,12 +554,50 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 					replayQueueItems = state.Queued
 					replayQueue.Refresh()
 					updateWaitCountdown(state.WaitUntil)
+					if state.Paused && state.Remaining > 0 {
+						// the countdown is stopped while paused, so only show what is left
+						waitCountdown.SetText(formatWaitRemaining(state.Remaining))
+						waitCountdown.Show()
+					}
+					if state.Running {
+						if state.Paused {
+							pauseReplayButton.SetIcon(theme.MediaPlayIcon())
+						} else {
+							pauseReplayButton.SetIcon(theme.MediaPauseIcon())
+						}
+						pauseReplayButton.Show()
+					} else {
+						pauseReplayButton.Hide()
+					}
+					if state.Running && state.Ramping {
+						rampProgress.SetValue(state.Progress)
+						rampProgress.Show()
+					} else {
+						rampProgress.Hide()
+					}
+					switch {
+					case state.Confirm == "":
+						confirmID = -1
+						showConfirm("")
+					case state.CurrentID != confirmID:
+						confirmID = state.CurrentID
+						showConfirm(state.Confirm)
+					}
 					switch {
+					case state.Running && state.Paused:
+						if state.Current != "" {
+							replayStatus.SetText("Paused: " + state.Current)
+						} else {
+							replayStatus.SetText("Planned roast paused")
+						}
+						replayButton.SetText("Cancel Planned Roast")
+						replayButton.Enable()
+						skipReplayButton.Hide()
 					case state.Running && state.Current != "":
 						replayStatus.SetText("Current: " + state.Current)
 						replayButton.SetText("Cancel Planned Roast")
 						replayButton.Enable()
-						if strings.HasPrefix(state.Current, "WAIT") {
+						if state.Waiting {
 							skipReplayButton.Show()
 						} else {
 							skipReplayButton.Hide()
@@ -369,6 +607,13 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 						replayButton.SetText("Cancel Planned Roast")
 						replayButton.Enable()
 						skipReplayButton.Hide()
+					case state.Cancelled && state.Error != "":
+						cancelReplay = nil
+						replayStatus.SetText("Planned roast stopped: " + state.Error + ". Manual control enabled.")
+						replayButton.SetText("Start Planned Roast")
+						replayButton.Disable()
+						skipReplayButton.Hide()
+						refreshStateButton()
 					case state.Cancelled:
 						cancelReplay = nil
 						replayStatus.SetText("Planned roast cancelled. Manual control enabled.")
@@ -411,6 +656,106 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 			showError(application, window, fmt.Errorf("error creating controller: %w", err))
 			return
 		}
+		mu.Lock()
+		roaster = c
+		mu.Unlock()
+
+		// the thermocouple's readings are charted as the bean temperature even if they aren't sent to TWChart, so
+		// only the ambient probe is used from the other temperature sources
+		probes, err := c.Probes()
+		if err != nil {
+			fmt.Fprintf(ui, "Error: %v\n", err)
+		}
+		curve = controller.NewRoastCurve(cfg.SessionName, probes, "")
+		chart.SetCurve(curve)
+
+		controllerCtx, cancel := context.WithCancel(ctx)
+
+		// sliders are updated when commands are sent, so they are re-synced from the device if a command
+		// fails. If the device can't report its status, they go back to the last setting the firmware accepted
+		confirmedFan := float64(cfg.InitialFanSetting)
+		confirmedPower := float64(cfg.InitialPowerSetting)
+		if cfg.Resume {
+			session := c.Session()
+			if session.Fan != 0 && session.Power != 0 {
+				confirmedFan = float64(session.Fan)
+				confirmedPower = float64(session.Power)
+				setFanSlider(confirmedFan)
+				setPowerSlider(confirmedPower)
+			}
+			resumeSession(session)
+			if replay != nil {
+				replay.ResumeSession(session)
+			}
+		}
+		syncFromDevice = func() {
+			go func() {
+				status, err := c.Status(controllerCtx)
+				fyne.Do(func() {
+					if err != nil {
+						fmt.Fprintf(ui, "Error: %v\n", err)
+						setFanSlider(confirmedFan)
+						setPowerSlider(confirmedPower)
+						return
+					}
+
+					if status.Fan != 0 {
+						confirmedFan = float64(status.Fan)
+					}
+					if status.Power != 0 {
+						confirmedPower = float64(status.Power)
+					}
+					setFanSlider(confirmedFan)
+					setPowerSlider(confirmedPower)
+
+					if syncedState(currentState, status.DeviceStatus) != currentState {
+						advanceState()
+						overallTimer.Set(status.StartTime)
+						lastEventTimer.Set(status.StartTime)
+					}
+				})
+			}()
+		}
+		c.OnTemperature(func(temperature controller.Temperature) {
+			ror, ok := c.RateOfRise()
+			text := formatRateOfRise(ror, ok)
+			fyne.Do(func() {
+				rorText.Text = text
+				rorText.Refresh()
+				curve.AddBean(temperature.Time, float64(temperature.Bean))
+				if ok {
+					curve.AddRoR(temperature.Time, ror)
+				}
+				chart.Refresh()
+			})
+		})
+		c.OnProbeReading(func(reading controller.ProbeReading) {
+			fyne.Do(func() {
+				curve.AddReading(reading)
+				chart.Refresh()
+			})
+		})
+		c.OnResult(func(result controller.CommandResult, err error) {
+			setting, value, isSetting := settingValue(result.Command)
+			if err != nil && isSetting {
+				syncFromDevice()
+			}
+			if err != nil {
+				// commands that the roaster rejected are not exported
+				roastRecorder.Reject(result.Command)
+			}
+			fyne.Do(func() {
+				switch {
+				case isSetting && err == nil && setting == 'F':
+					confirmedFan = value
+				case isSetting && err == nil:
+					confirmedPower = value
+				}
+				if err != nil && replay != nil {
+					replay.Fail(err)
+				}
+			})
+		})
 
 		commandReader, commandWriter := io.Pipe()
 		controllerReader, controllerInputWriter := io.Pipe()
@@ -427,7 +772,6 @@ func (ui *RoasterUI) Run(ctx context.Context, cfg controller.Config, debug bool)
 			controllerOutput = io.MultiWriter(os.Stdout, controllerOutput)
 		}
 
-		controllerCtx, cancel := context.WithCancel(ctx)
 		go func() {
 			err := runCommands(commandReader, controllerInputWriter, applyCommand)
 			if err != nil {
@@ -580,3 +924,12 @@ func formatWaitRemaining(remaining time.Duration) string {
 	seconds := int(math.Ceil(remaining.Seconds()))
 	return fmt.Sprintf("%02d:%02d", seconds/60, seconds%60)
 }
+
+// formatRateOfRise shows the rate of rise in degrees Fahrenheit per minute, or dashes until there are enough
+// readings to calculate it
+func formatRateOfRise(ror float64, ok bool) string {
+	if !ok {
+		return "RoR --.-"
+	}
+	return fmt.Sprintf("RoR %.1f°F/min", ror)
+}
@@ -18,3 +18,20 @@ func TestFormatWaitRemaining(t *testing.T) {
 		}
 	}
 }
+
+func TestFormatRateOfRise(t *testing.T) {
+	tests := []struct {
+		ror  float64
+		ok   bool
+		want string
+	}{
+		{0, false, "RoR --.-"},
+		{12.46, true, "RoR 12.5°F/min"},
+		{-3, true, "RoR -3.0°F/min"},
+	}
+	for _, tt := range tests {
+		if got := formatRateOfRise(tt.ror, tt.ok); got != tt.want {
+			t.Errorf("formatRateOfRise(%v, %v) = %q, want %q", tt.ror, tt.ok, got, tt.want)
+		}
+	}
+}